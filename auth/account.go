@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"server/models"
+	"server/services"
+	"server/users"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateAccountHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.GET("/account", csrf, accountGetHandler())
+	router.POST("/account/password", csrf, changePasswordHandler(provider))
+	router.POST("/account/email", csrf, changeEmailHandler(provider))
+	router.POST("/account/delete", csrf, deleteAccountHandler(provider))
+}
+
+func accountGetHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		renderHTML(context, http.StatusOK, "account.tmpl", gin.H{
+			"error": nil,
+		})
+	}
+}
+
+// authenticateAccount checks the password, and the second factor when it's
+// enabled, before any change to the account. It renders the error itself.
+func authenticateAccount(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+) *models.User {
+	userRepo := provider.GetUserRepo()
+
+	email := context.PostForm("email")
+
+	user, status, message := authenticateUser(context, provider, email, context.PostForm("password"))
+
+	if user != nil && !user.Validated {
+		user, status, message = nil, http.StatusBadRequest, invalidCredentialsMessage
+	}
+
+	if user == nil {
+		renderHTML(context, status, "account.tmpl", gin.H{
+			"error": message,
+			"email": email,
+		})
+		return nil
+	}
+
+	if user.TOTPEnabled {
+		code := context.PostForm("code")
+
+		if !userRepo.VerifyTOTP(user, code) && !userRepo.UseRecoveryCode(user, code) {
+			recordFailedLogin(context, provider, email, user, "two_factor")
+
+			renderHTML(context, http.StatusBadRequest, "account.tmpl", gin.H{
+				"error": invalidTwoFactorMessage,
+				"email": email,
+			})
+			return nil
+		}
+	}
+
+	userRepo.ClearFailedLogins(email)
+
+	return user
+}
+
+func changePasswordHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		passwordPolicy := provider.GetPasswordPolicy()
+
+		user := authenticateAccount(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		password := context.PostForm("new_password")
+
+		if policyErr := passwordPolicy.Check(password, user.Email, user.Name); policyErr != nil {
+			renderHTML(context, http.StatusBadRequest, "account.tmpl", gin.H{
+				"error": policyErr.Error(),
+				"email": user.Email,
+			})
+			return
+		}
+
+		hash, pwdErr := users.HashPassword(password)
+
+		if pwdErr != nil {
+			renderHTML(context, http.StatusInternalServerError, "account.tmpl", gin.H{
+				"error": "There was an error changing your password",
+				"email": user.Email,
+			})
+			return
+		}
+
+		userRepo.ChangePassword(user, hash, context.ClientIP())
+		recordAudit(context, provider, services.AuditPasswordChanged, user.Email, "", "")
+
+		renderHTML(context, http.StatusOK, "account.tmpl", gin.H{
+			"message": "Your password has been changed. You will need to sign in again on your other devices.",
+			"email":   user.Email,
+		})
+	}
+}
+
+// changeEmailHandler keeps the current address until the new one is
+// verified through the usual verification link.
+func changeEmailHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		codeGen := provider.GetCodeGenerator()
+		emailService := provider.GetEmailService()
+
+		user := authenticateAccount(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		newEmail := strings.TrimSpace(context.PostForm("new_email"))
+
+		if address, err := mail.ParseAddress(newEmail); err != nil || address.Address != newEmail {
+			renderHTML(context, http.StatusBadRequest, "account.tmpl", gin.H{
+				"error": "Invalid field: new email",
+				"email": user.Email,
+			})
+			return
+		}
+
+		if strings.EqualFold(newEmail, user.Email) || userRepo.EmailInUse(newEmail) {
+			renderHTML(context, http.StatusBadRequest, "account.tmpl", gin.H{
+				"error": fmt.Sprintf("Email %s already exists", newEmail),
+				"email": user.Email,
+			})
+			return
+		}
+
+		code := codeGen.GenCode()
+
+		userRepo.RequestEmailChange(user, newEmail, code)
+
+		emailService.SendVerificationLink(newEmail, code, user.Locale)
+
+		renderHTML(context, http.StatusAccepted, "account.tmpl", gin.H{
+			"message": fmt.Sprintf("We sent a verification link to %s. Your email will change once you follow it.", newEmail),
+			"email":   user.Email,
+		})
+	}
+}
+
+// deleteAccountHandler removes the trainer's profile, pages and images from
+// the backend before the account itself, so nothing is left orphaned if the
+// backend can't be reached.
+func deleteAccountHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		backendService := provider.GetBackendService()
+		session := provider.GetSession()
+
+		user := authenticateAccount(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		if context.PostForm("confirm") != "true" {
+			renderHTML(context, http.StatusBadRequest, "account.tmpl", gin.H{
+				"error": "Please confirm that you want to delete your account",
+				"email": user.Email,
+			})
+			return
+		}
+
+		if err := backendService.DeleteAccount(user.Email); err != nil {
+			renderHTML(context, http.StatusBadGateway, "account.tmpl", gin.H{
+				"error": "There was an error deleting your account. Please try again later.",
+				"email": user.Email,
+			})
+			return
+		}
+
+		if err := userRepo.DeleteUser(user, context.ClientIP()); err != nil {
+			renderHTML(context, http.StatusInternalServerError, "account.tmpl", gin.H{
+				"error": "There was an error deleting your account. Please try again later.",
+				"email": user.Email,
+			})
+			return
+		}
+
+		if store, err := session.Start(context, context.Writer, context.Request); err == nil {
+			store.Flush()
+		}
+
+		renderHTML(context, http.StatusOK, "account.tmpl", gin.H{
+			"message": "Your account has been deleted.",
+			"deleted": true,
+		})
+	}
+}
+
+// confirmEmailChange finishes a change of address once the link sent to the
+// new address is followed.
+func confirmEmailChange(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+	user *models.User,
+	code string,
+) {
+	userRepo := provider.GetUserRepo()
+	backendService := provider.GetBackendService()
+
+	newEmail := user.PendingEmail
+
+	if userRepo.ValidateCode(user, code) != nil {
+		renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
+			"error": "This verification link is invalid or has expired. Please change your email again from your account page.",
+		})
+		return
+	}
+
+	if existing, _ := userRepo.GetPendingUser(newEmail); existing != nil {
+		renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
+			"error": fmt.Sprintf("Email %s already exists", newEmail),
+		})
+		return
+	}
+
+	if err := backendService.ChangeEmail(user.Email, newEmail); err != nil {
+		renderHTML(context, http.StatusBadGateway, "validate-email.tmpl", gin.H{
+			"error": "There was an error changing your email. Please try the link again later.",
+		})
+		return
+	}
+
+	if err := userRepo.ConfirmEmailChange(user, context.ClientIP()); err != nil {
+		renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
+			"error": fmt.Sprintf("Email %s already exists", newEmail),
+		})
+		return
+	}
+
+	recordAudit(context, provider, services.AuditEmailValidated, newEmail, "", "email_changed")
+
+	renderHTML(context, http.StatusAccepted, "validate-email.tmpl", gin.H{
+		"message": fmt.Sprintf("Thank you for verifying your email. You can now sign in as %s.", newEmail),
+		"email":   newEmail,
+	})
+}
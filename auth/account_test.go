@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"server/users"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func AccountForm(password string, values url.Values) url.Values {
+	values.Set("email", testUser)
+	values.Set("password", password)
+	return values
+}
+
+func TestAccountChangePassword(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	userRepo := services.CreateUserRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	pending, _ := userRepo.GetUser(testUser)
+	userRepo.UpdateResetCode(pending, "reset-code")
+
+	wrong := PostForm(router, "/account/password", AccountForm("wrong-password", url.Values{
+		"new_password": {"new-password"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, wrong.Code)
+	assert.Contains(t, wrong.Body.String(), invalidCredentialsMessage)
+
+	short := PostForm(router, "/account/password", AccountForm(testPassword, url.Values{
+		"new_password": {"short"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, short.Code)
+
+	w := PostForm(router, "/account/password", AccountForm(testPassword, url.Values{
+		"new_password": {"new-password"},
+	}), nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, users.CheckPasswordHash("new-password", user.Password))
+	assert.Empty(t, user.ResetCode)
+	assert.True(t, user.ResetExpiration.IsZero())
+
+	refreshed, _ := RefreshTokens(router, tokens["refresh_token"].(string))
+
+	assert.NotEqual(t, http.StatusOK, refreshed.Code)
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.Equal(t, services.PasswordChanged, family.RevokedReason)
+}
+
+func TestAccountPageExplainsPasswordlessAccounts(t *testing.T) {
+	db := Setup()
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	w := GetWithCookies(router, "/account", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<a href="/forgot-password">set a password</a>`)
+}
+
+func TestAccountRequiresTwoFactorCode(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	SetupTwoFactorUser(db, mockClock)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	form := AccountForm(testPassword, url.Values{"new_password": {"new-password"}})
+
+	w := PostForm(router, "/account/password", form, nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), invalidTwoFactorMessage)
+
+	form.Set("code", CurrentTOTPCode())
+
+	w = PostForm(router, "/account/password", form, nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAccountChangeEmail(t *testing.T) {
+	db := Setup()
+
+	email := &mocks.MockEmailService{}
+	backend := &mocks.MockBackendService{}
+	mockClock := &mocks.MockClock{Time: now}
+
+	router := SetupRouter(db, email, backend, mockClock, &mocks.MockCodeGenerator{Code: "email-code"})
+
+	defer Teardown(db)
+
+	consentRepo := services.CreateConsentRepo(db, mockClock, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, "other-client", "openid")
+
+	w := PostForm(router, "/account/email", AccountForm(testPassword, url.Values{
+		"new_email": {"new@example.com"},
+	}), nil)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "new@example.com", email.VerificationEmail)
+	assert.Equal(t, "email-code", email.ValidationCode)
+
+	var user models.User
+	db.First(&user)
+
+	assert.Equal(t, testUser, user.Email)
+	assert.Equal(t, "new@example.com", user.PendingEmail)
+
+	invalid := GetWithCookies(router, "/validate-email?email=new%40example.com&code=wrong", nil)
+
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+
+	confirmed := GetWithCookies(router, "/validate-email?email=new%40example.com&code=email-code", nil)
+
+	assert.Equal(t, http.StatusAccepted, confirmed.Code)
+	assert.Equal(t, testUser, backend.OldEmail)
+	assert.Equal(t, "new@example.com", backend.NewEmail)
+
+	db.First(&user)
+
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.Empty(t, user.PendingEmail)
+	assert.True(t, consentRepo.HasConsent("new@example.com", "other-client", "openid"))
+
+	login := PostLogin(router, "new@example.com", testPassword)
+
+	assert.Equal(t, "/auth", login.Header().Get("Location"))
+}
+
+func TestAccountChangeEmailRejectsTakenEmail(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	db.Exec("insert into users (name, email, password, validated) values(?,?,?,?)", "Other", "other@example.com", "hash", true)
+
+	w := PostForm(router, "/account/email", AccountForm(testPassword, url.Values{
+		"new_email": {"other@example.com"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	invalid := PostForm(router, "/account/email", AccountForm(testPassword, url.Values{
+		"new_email": {"not an email"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+}
+
+func TestAccountDelete(t *testing.T) {
+	db := Setup()
+
+	backend := &mocks.MockBackendService{}
+
+	router := SetupRouter(db, backend, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	UserSubject(db, "other-client")
+
+	store := services.CreateSessionStore(db, &mocks.MockClock{Time: now}, 0)
+
+	for sid, email := range map[string]string{"own-session": testUser, "other-session": "other@example.com"} {
+		session, _ := store.Create(context.Background(), sid, 60)
+		session.Set(services.AuthenticatedUserKey, email)
+		session.Save()
+	}
+
+	unconfirmed := PostForm(router, "/account/delete", AccountForm(testPassword, url.Values{}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, unconfirmed.Code)
+	assert.Empty(t, backend.DeletedEmail)
+
+	w := PostForm(router, "/account/delete", AccountForm(testPassword, url.Values{
+		"confirm": {"true"},
+	}), nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testUser, backend.DeletedEmail)
+
+	var count int64
+	db.Unscoped().Model(&models.User{}).Count(&count)
+
+	assert.Equal(t, int64(0), count)
+
+	db.Model(&models.PairwiseSubject{}).Count(&count)
+
+	assert.Equal(t, int64(0), count)
+
+	own, _ := store.Check(context.Background(), "own-session")
+	other, _ := store.Check(context.Background(), "other-session")
+
+	assert.False(t, own)
+	assert.True(t, other)
+}
+
+func TestAccountDeleteKeepsAccountWhenBackendFails(t *testing.T) {
+	db := Setup()
+
+	backend := &mocks.MockBackendService{Err: errors.New("unavailable")}
+
+	router := SetupRouter(db, backend, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	w := PostForm(router, "/account/delete", AccountForm(testPassword, url.Values{
+		"confirm": {"true"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+
+	assert.Equal(t, int64(1), count)
+}
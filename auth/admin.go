@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"server/models"
+	"server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const adminUserKey = "AdminUser"
+
+// requireAdmin only lets through bearer tokens carrying the admin role, and
+// only while the user is still an enabled admin. Tokens have to be issued to
+// the first-party client, an admin consenting to a registered client doesn't
+// give it admin access. The admin is available to handlers under
+// adminUserKey.
+func requireAdmin(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		srv := provider.GetOauthServer()
+		userRepo := provider.GetUserRepo()
+
+		token, err := srv.ValidationBearerToken(context.Request)
+		if err != nil {
+			bearerError(context, http.StatusUnauthorized, "invalid_token", err.Error())
+			context.Abort()
+			return
+		}
+
+		if token.GetClientID() != services.FirstPartyClientID() {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "admin access is required")
+			context.Abort()
+			return
+		}
+
+		user, userErr := userRepo.GetUser(token.GetUserID())
+		if userErr != nil || !user.Admin || user.Disabled || !services.TokenHasRole(token.GetAccess(), services.AdminRole) {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "admin access is required")
+			context.Abort()
+			return
+		}
+
+		context.Set(adminUserKey, user)
+		context.Next()
+	}
+}
+
+func adminUser(context *gin.Context) *models.User {
+	return context.MustGet(adminUserKey).(*models.User)
+}
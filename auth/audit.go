@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"server/models"
+	"server/services"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-session/session"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+func CreateAuditHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	admin := router.Group("/admin", requireAdmin(provider))
+
+	admin.GET("/audit", listAuditEventsHandler(provider))
+	admin.GET("/audit/export", exportAuditEventsHandler(provider))
+}
+
+func recordAudit(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+	eventType string,
+	userID string,
+	clientID string,
+	detail string,
+) {
+	auditRepo := provider.GetAuditRepo()
+	auditRepo.RecordRequest(context.Request, eventType, userID, clientID, detail)
+}
+
+// returnClientID is the client the user is signing in to, taken from the
+// authorize request saved in the session.
+func returnClientID(store session.Store) string {
+	if v, ok := store.Get("ReturnUri"); ok {
+		if form, ok := v.(url.Values); ok {
+			return form.Get("client_id")
+		}
+	}
+	return ""
+}
+
+// auditFilter reads the type, user, client, since and until query
+// parameters. Times are RFC 3339.
+func auditFilter(context *gin.Context) (services.AuditFilter, bool) {
+	filter := services.AuditFilter{
+		Type:     context.Query("type"),
+		UserID:   context.Query("user"),
+		ClientID: context.Query("client"),
+	}
+
+	for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := context.Query(name); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				context.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 time"})
+				return filter, false
+			}
+			*value = parsed
+		}
+	}
+
+	return filter, true
+}
+
+func listAuditEventsHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		auditRepo := provider.GetAuditRepo()
+
+		filter, ok := auditFilter(context)
+		if !ok {
+			return
+		}
+
+		page, err := strconv.Atoi(context.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		limit, err := strconv.Atoi(context.DefaultQuery("limit", strconv.Itoa(defaultAuditPageSize)))
+		if err != nil || limit < 1 {
+			limit = defaultAuditPageSize
+		}
+
+		if limit > maxAuditPageSize {
+			limit = maxAuditPageSize
+		}
+
+		events, total := auditRepo.Query(filter, (page-1)*limit, limit)
+
+		if events == nil {
+			events = []models.AuditEvent{}
+		}
+
+		context.JSON(http.StatusOK, gin.H{
+			"events": events,
+			"total":  total,
+			"page":   page,
+			"limit":  limit,
+		})
+	}
+}
+
+// exportAuditEventsHandler streams every matching event, oldest first, as
+// JSON lines.
+func exportAuditEventsHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		auditRepo := provider.GetAuditRepo()
+
+		filter, ok := auditFilter(context)
+		if !ok {
+			return
+		}
+
+		context.Header("Content-Type", "application/x-ndjson")
+		context.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		context.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(context.Writer)
+
+		auditRepo.Each(filter, func(event *models.AuditEvent) error {
+			return encoder.Encode(event)
+		})
+	}
+}
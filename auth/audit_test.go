@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func AuditEvents(db *gorm.DB, eventType string) []models.AuditEvent {
+	var events []models.AuditEvent
+	db.Where("type = ?", eventType).Order("id").Find(&events)
+	return events
+}
+
+func TestAuditRecordsLogins(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+
+	db := Setup()
+
+	router := SetupRouter(db, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	PostLogin(router, testUser, "bad-password")
+
+	w := httptest.NewRecorder()
+
+	form := url.Values{"email": {testUser}, "password": {testPassword}}
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+	req.RemoteAddr = "10.0.0.2:41234"
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	failed := AuditEvents(db, services.AuditLoginFailed)
+
+	assert.Len(t, failed, 1)
+	assert.Equal(t, testUser, failed[0].UserID)
+	assert.Equal(t, "password", failed[0].Detail)
+
+	succeeded := AuditEvents(db, services.AuditLoginSucceeded)
+
+	assert.Len(t, succeeded, 1)
+	assert.Equal(t, "test-agent", succeeded[0].UserAgent)
+	assert.Equal(t, "203.0.113.7", succeeded[0].IPAddress)
+	assert.True(t, now.Equal(succeeded[0].CreatedAt))
+}
+
+func TestAuditRecordsLockout(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	for i := 0; i < 5; i++ {
+		PostLogin(router, testUser, "bad-password")
+	}
+
+	assert.Len(t, AuditEvents(db, services.AuditLoginFailed), 5)
+	assert.Len(t, AuditEvents(db, services.AuditAccountLocked), 1)
+}
+
+func TestAuditRecordsTokens(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	refreshed := AuditEvents(db, services.AuditTokenRefreshed)
+
+	assert.Len(t, refreshed, 1)
+	assert.Equal(t, testUser, refreshed[0].UserID)
+	assert.Equal(t, testClientID, refreshed[0].ClientID)
+
+	PostClientForm(router, "/oauth/revoke", url.Values{"token": {tokens["refresh_token"].(string)}}, testClientID, testClientSecret)
+
+	revoked := AuditEvents(db, services.AuditTokenRevoked)
+
+	assert.Len(t, revoked, 1)
+	assert.Equal(t, services.TokenRevoked, revoked[0].Detail)
+}
+
+func TestAuditQueryAndExport(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+	mockClock := &mocks.MockClock{Time: now}
+
+	router := SetupRouter(db, srv, mockClock)
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	PostLogin(router, testUser, "bad-password")
+	PostLogin(router, testUser, testPassword)
+
+	w, data := SendJSON(router, "GET", "/admin/audit?type="+services.AuditLoginFailed, nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), data["total"])
+
+	w, _ = SendJSON(router, "GET", "/admin/audit?since=yesterday", nil, token)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = SendJSON(router, "GET", "/admin/audit/export?user="+testUser, nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var types []string
+
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var event models.AuditEvent
+		json.Unmarshal(scanner.Bytes(), &event)
+		types = append(types, event.Type)
+	}
+
+	assert.Equal(t, []string{services.AuditLoginFailed, services.AuditLoginSucceeded}, types)
+
+	w, _ = SendJSON(router, "GET", "/admin/audit", nil, "access-token")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAuditDeleteExpired(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	auditRepo := services.CreateAuditRepo(db, mockClock)
+
+	defer Teardown(db)
+
+	auditRepo.Record(models.AuditEvent{Type: services.AuditSignup, UserID: "old@example.com"})
+
+	mockClock.Time = now.Add(time.Hour * 24 * 30)
+
+	auditRepo.Record(models.AuditEvent{Type: services.AuditSignup, UserID: "new@example.com"})
+
+	assert.Equal(t, int64(1), auditRepo.DeleteExpired(time.Hour*24*7))
+
+	events := AuditEvents(db, services.AuditSignup)
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, "new@example.com", events[0].UserID)
+}
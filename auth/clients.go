@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"server/services"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type clientRequest struct {
+	Name                   string   `json:"name"`
+	RedirectURIs           []string `json:"redirect_uris"`
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris"`
+	GrantTypes             []string `json:"grant_types"`
+	Scopes                 []string `json:"scopes"`
+	Public                 bool     `json:"public"`
+}
+
+// registrationRequest is the RFC 7591 client metadata we support.
+type registrationRequest struct {
+	ClientName              string   `json:"client_name"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	PostLogoutRedirectURIs  []string `json:"post_logout_redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	Scope                   string   `json:"scope"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+}
+
+func CreateClientHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	admin := router.Group("/admin", requireAdmin(provider))
+
+	admin.GET("/clients", listClientsHandler(provider))
+	admin.POST("/clients", createClientHandler(provider))
+	admin.GET("/clients/:id", getClientHandler(provider))
+	admin.POST("/clients/:id/secret", rotateClientSecretHandler(provider))
+	admin.POST("/clients/:id/disable", disableClientHandler(provider))
+
+	router.POST("/oauth/register", registerClientHandler(provider))
+}
+
+func clientJSON(client *services.Client) gin.H {
+	return gin.H{
+		"client_id":                 client.ID,
+		"name":                      client.Name,
+		"redirect_uris":             client.RedirectURIs,
+		"post_logout_redirect_uris": client.PostLogoutRedirectURIs,
+		"grant_types":               client.GrantTypes,
+		"scopes":                    client.Scopes,
+		"public":                    client.Public,
+		"disabled":                  client.Disabled,
+		"dynamic":                   client.Dynamic,
+		"created_at":                client.CreatedAt,
+	}
+}
+
+func clientError(context *gin.Context, err error, description string) {
+	context.JSON(http.StatusBadRequest, gin.H{
+		"error":             err.Error(),
+		"error_description": description,
+	})
+}
+
+func listClientsHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		clientStore := provider.GetClientStore()
+
+		clients := []gin.H{}
+
+		for _, client := range clientStore.GetClients() {
+			clients = append(clients, clientJSON(&client))
+		}
+
+		context.JSON(http.StatusOK, clients)
+	}
+}
+
+func createClientHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		clientStore := provider.GetClientStore()
+
+		var request clientRequest
+
+		if err := context.ShouldBindJSON(&request); err != nil {
+			clientError(context, services.ErrInvalidClientMetadata, err.Error())
+			return
+		}
+
+		client := &services.Client{
+			Name:         request.Name,
+			RedirectURIs: request.RedirectURIs,
+			GrantTypes:   request.GrantTypes,
+			Scopes:       request.Scopes,
+			Public:       request.Public,
+
+			PostLogoutRedirectURIs: request.PostLogoutRedirectURIs,
+		}
+
+		if err := services.ValidateClientMetadata(client); err != nil {
+			clientError(context, err, "redirect_uris must be absolute URIs, grant_types and scopes must be supported")
+			return
+		}
+
+		secret, err := clientStore.CreateClient(client)
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response := clientJSON(client)
+		if secret != "" {
+			response["client_secret"] = secret
+		}
+
+		context.JSON(http.StatusCreated, response)
+	}
+}
+
+func getClientHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		clientStore := provider.GetClientStore()
+
+		client, err := clientStore.GetClient(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+			return
+		}
+
+		context.JSON(http.StatusOK, clientJSON(client))
+	}
+}
+
+func rotateClientSecretHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		clientStore := provider.GetClientStore()
+
+		client, err := clientStore.GetClient(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+			return
+		}
+
+		secret, err := clientStore.RotateSecret(client)
+		if err != nil {
+			clientError(context, err, "public clients have no secret")
+			return
+		}
+
+		response := clientJSON(client)
+		response["client_secret"] = secret
+
+		context.JSON(http.StatusOK, response)
+	}
+}
+
+func disableClientHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		clientStore := provider.GetClientStore()
+
+		client, err := clientStore.GetClient(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+			return
+		}
+
+		if err := clientStore.DisableClient(client, context.ClientIP()); err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		context.JSON(http.StatusOK, clientJSON(client))
+	}
+}
+
+// registerClientHandler implements RFC 7591 dynamic registration when it's
+// turned on. Registered clients always need the user's consent.
+func registerClientHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		clientStore := provider.GetClientStore()
+
+		if !services.DynamicRegistrationEnabled() {
+			context.JSON(http.StatusNotFound, gin.H{"error": "dynamic registration is disabled"})
+			return
+		}
+
+		if token := os.Getenv("CLIENT_REGISTRATION_TOKEN"); token != "" {
+			bearer := strings.TrimPrefix(context.GetHeader("Authorization"), "Bearer ")
+
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				bearerError(context, http.StatusUnauthorized, "invalid_token", "a registration access token is required")
+				return
+			}
+		}
+
+		var request registrationRequest
+
+		if err := context.ShouldBindJSON(&request); err != nil {
+			clientError(context, services.ErrInvalidClientMetadata, err.Error())
+			return
+		}
+
+		for _, responseType := range request.ResponseTypes {
+			if responseType != "code" {
+				clientError(context, services.ErrInvalidClientMetadata, "only the code response type is supported")
+				return
+			}
+		}
+
+		authMethod := request.TokenEndpointAuthMethod
+		if authMethod == "" {
+			authMethod = "client_secret_post"
+		}
+
+		if authMethod != "client_secret_post" && authMethod != "none" {
+			clientError(context, services.ErrInvalidClientMetadata, "token_endpoint_auth_method must be client_secret_post or none")
+			return
+		}
+
+		client := &services.Client{
+			Name:         request.ClientName,
+			RedirectURIs: request.RedirectURIs,
+			GrantTypes:   request.GrantTypes,
+			Scopes:       strings.Fields(request.Scope),
+			Public:       authMethod == "none",
+			Dynamic:      true,
+
+			PostLogoutRedirectURIs: request.PostLogoutRedirectURIs,
+		}
+
+		if err := services.ValidateClientMetadata(client); err != nil {
+			clientError(context, err, "redirect_uris must be absolute URIs, grant_types and scope must be supported")
+			return
+		}
+
+		secret, err := clientStore.CreateClient(client)
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response := gin.H{
+			"client_id":                  client.ID,
+			"client_id_issued_at":        client.CreatedAt.Unix(),
+			"client_name":                client.Name,
+			"redirect_uris":              client.RedirectURIs,
+			"post_logout_redirect_uris":  client.PostLogoutRedirectURIs,
+			"grant_types":                client.GrantTypes,
+			"response_types":             []string{"code"},
+			"scope":                      strings.Join(client.Scopes, " "),
+			"token_endpoint_auth_method": authMethod,
+		}
+
+		if secret != "" {
+			response["client_secret"] = secret
+			response["client_secret_expires_at"] = 0
+		}
+
+		context.Header("Cache-Control", "no-store")
+		context.JSON(http.StatusCreated, response)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	oauthModels "github.com/go-oauth2/oauth2/v4/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// SetupAdmin makes the test user an admin and returns an access token for
+// them carrying the admin role.
+func SetupAdmin(t *testing.T, db *gorm.DB, tokenStore oauth2.TokenStore) string {
+	db.Exec("update users set admin = ? where email = ?", true, testUser)
+
+	return StoreAdminToken(t, tokenStore, testUser)
+}
+
+// StoreAdminToken stores an admin token issued to testClientID, which is
+// the first-party client for the rest of the test.
+func StoreAdminToken(t *testing.T, tokenStore oauth2.TokenStore, email string) string {
+	t.Setenv("CLIENT_ID", testClientID)
+
+	return StoreClientAdminToken(tokenStore, email, testClientID)
+}
+
+func StoreClientAdminToken(tokenStore oauth2.TokenStore, email string, clientID string) string {
+	access, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   email,
+		"roles": []string{services.AdminRole},
+	}).SignedString([]byte("test"))
+
+	tokenInfo := oauthModels.NewToken()
+	tokenInfo.SetClientID(clientID)
+	tokenInfo.SetUserID(email)
+	tokenInfo.SetScope("all")
+	tokenInfo.SetAccess(access)
+	tokenInfo.SetAccessCreateAt(time.Now())
+	tokenInfo.SetAccessExpiresIn(time.Hour)
+
+	tokenStore.Create(context.Background(), tokenInfo)
+
+	return access
+}
+
+func SendJSON(router http.Handler, method string, path string, body interface{}, token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+
+	encoded, _ := json.Marshal(body)
+
+	req, _ := http.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	router.ServeHTTP(w, req)
+
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+
+	return w, data
+}
+
+func TestClientAdminRequiresAdmin(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w, _ := SendJSON(router, "GET", "/admin/clients", nil, "")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, data := SendJSON(router, "GET", "/admin/clients", nil, "access-token")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "insufficient_scope", data["error"])
+}
+
+func TestClientAdminRequiresFirstPartyClient(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	SetupAdmin(t, db, tokenStore)
+
+	// the admin consented to another client, which mustn't get admin access
+	token := StoreClientAdminToken(tokenStore, testUser, "other-client")
+
+	w, data := SendJSON(router, "GET", "/admin/clients", nil, token)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "insufficient_scope", data["error"])
+}
+
+func TestClientAdminCreateAndList(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	w, created := SendJSON(router, "POST", "/admin/clients", gin.H{
+		"name":          "Partner",
+		"redirect_uris": []string{"https://partner.example/callback", "https://partner.example/other"},
+		"scopes":        []string{"openid", "email"},
+	}, token)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, created["client_id"])
+	assert.NotEmpty(t, created["client_secret"])
+	assert.Equal(t, []interface{}{"authorization_code", "refresh_token"}, created["grant_types"])
+
+	var row models.OauthClient
+	db.Where("id = ?", created["client_id"]).First(&row)
+
+	assert.Equal(t, services.HashToken(created["client_secret"].(string)), row.Secret)
+	assert.NotContains(t, string(row.Data), created["client_secret"])
+
+	w, _ = SendJSON(router, "GET", "/admin/clients", nil, token)
+
+	var clients []map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &clients)
+
+	assert.Len(t, clients, 3)
+	for _, client := range clients {
+		assert.Nil(t, client["client_secret"])
+	}
+
+	w, invalid := SendJSON(router, "POST", "/admin/clients", gin.H{
+		"redirect_uris": []string{"/relative"},
+	}, token)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_redirect_uri", invalid["error"])
+
+	w, invalid = SendJSON(router, "POST", "/admin/clients", gin.H{
+		"redirect_uris": []string{"https://partner.example/callback"},
+		"grant_types":   []string{"password"},
+	}, token)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_client_metadata", invalid["error"])
+}
+
+func TestClientAdminRotateSecret(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	w, rotated := SendJSON(router, "POST", "/admin/clients/other-client/secret", nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, "other-secret", rotated["client_secret"])
+
+	old := PostClientForm(router, "/oauth/introspect", url.Values{"token": {"access-token"}}, "other-client", "other-secret")
+
+	assert.Equal(t, http.StatusUnauthorized, old.Code)
+
+	data := PostClientForm(router, "/oauth/introspect", url.Values{"token": {"access-token"}}, "other-client", rotated["client_secret"].(string))
+
+	assert.Equal(t, http.StatusOK, data.Code)
+}
+
+func TestClientAdminDisable(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	SetupAdmin(t, db, tokenStore)
+
+	_, first := RefreshTokens(router, "refresh-token")
+
+	missing, _ := SendJSON(router, "POST", "/admin/clients/missing/disable", nil, first["access_token"].(string))
+
+	assert.Equal(t, http.StatusNotFound, missing.Code)
+
+	w, disabled := SendJSON(router, "POST", "/admin/clients/"+testClientID+"/disable", nil, first["access_token"].(string))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, disabled["disabled"])
+
+	w, data := RefreshTokens(router, first["refresh_token"].(string))
+
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, data["error"])
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.True(t, family.Revoked)
+	assert.Equal(t, services.ClientDisabled, family.RevokedReason)
+}
+
+func TestAuthorizeMatchesRedirectURIsExactly(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	clientStore := services.CreateClientStore(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	clientStore.EnsureClient(&services.Client{
+		ID:           testClientID,
+		RedirectURIs: []string{"http://localhost:3000/other", testRedirectURI},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       services.SupportedScopes,
+	}, testClientSecret)
+
+	_, w := LoginAndAuthorize(router, "openid")
+
+	assert.Equal(t, "/consent", w.Header().Get("Location"))
+
+	query := url.Values{
+		"client_id":     {testClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {"http://localhost:3000/evil"},
+	}
+
+	invalid := GetWithCookies(router, "/oauth/authorize?"+query.Encode(), nil)
+
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assert.Contains(t, invalid.Body.String(), "invalid_request")
+}
+
+func TestAuthorizeRejectsScopeOutsideClient(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	clientStore := services.CreateClientStore(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	clientStore.EnsureClient(&services.Client{
+		ID:           testClientID,
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       []string{"openid"},
+	}, testClientSecret)
+
+	consentRepo := services.CreateConsentRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, testClientID, "openid email")
+
+	_, w := LoginAndAuthorize(router, "openid email")
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	assert.Equal(t, "invalid_scope", location.Query().Get("error"))
+	assert.Empty(t, location.Query().Get("code"))
+}
+
+func TestRefreshRequiresClientSecret(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w := PostForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"refresh-token"},
+		"client_id":     {testClientID},
+		"client_secret": {"wrong"},
+	}, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	other := PostForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {"refresh-token"},
+		"client_id":     {"other-client"},
+		"client_secret": {"other-secret"},
+	}, nil)
+
+	assert.NotEqual(t, http.StatusOK, other.Code)
+}
+
+func TestDynamicClientRegistration(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	w, _ := SendJSON(router, "POST", "/oauth/register", gin.H{}, "")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	os.Setenv("DYNAMIC_CLIENT_REGISTRATION", "true")
+	os.Setenv("CLIENT_REGISTRATION_TOKEN", "registration-token")
+	defer os.Unsetenv("DYNAMIC_CLIENT_REGISTRATION")
+	defer os.Unsetenv("CLIENT_REGISTRATION_TOKEN")
+
+	request := gin.H{
+		"client_name":   "Dynamic",
+		"redirect_uris": []string{"https://dynamic.example/callback"},
+		"scope":         "openid profile",
+	}
+
+	w, _ = SendJSON(router, "POST", "/oauth/register", request, "")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, registered := SendJSON(router, "POST", "/oauth/register", request, "registration-token")
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, registered["client_id"])
+	assert.NotEmpty(t, registered["client_secret"])
+	assert.Equal(t, "openid profile", registered["scope"])
+	assert.Equal(t, "client_secret_post", registered["token_endpoint_auth_method"])
+
+	clientStore := services.CreateClientStore(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	client, _ := clientStore.GetClient(registered["client_id"].(string))
+
+	assert.True(t, client.Dynamic)
+	assert.True(t, client.VerifyPassword(registered["client_secret"].(string)))
+
+	request["token_endpoint_auth_method"] = "none"
+
+	_, public := SendJSON(router, "POST", "/oauth/register", request, "registration-token")
+
+	assert.Nil(t, public["client_secret"])
+
+	request["scope"] = "admin"
+
+	w, invalid := SendJSON(router, "POST", "/oauth/register", request, "registration-token")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_client_metadata", invalid["error"])
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"server/services"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-session/session"
+)
+
+type scopeDescription struct {
+	Name        string
+	Description string
+}
+
+func CreateConsentHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.GET("/consent", csrf, consentGetHandler(provider))
+	router.POST("/consent", csrf, consentPostHandler(provider))
+	router.GET("/consents", listConsentsHandler(provider))
+	router.DELETE("/consents/:client_id", revokeConsentHandler(provider))
+}
+
+// pendingConsent returns the user and authorize request waiting for consent.
+func pendingConsent(
+	provider services.ServiceProviderType,
+	store session.Store,
+) (string, url.Values, bool) {
+	userID, ok := sessionValueBefore(store, services.ConsentUserKey, services.ConsentExpiresKey, provider.GetClock())
+
+	if !ok {
+		return "", nil, false
+	}
+
+	form, ok := store.Get("ReturnUri")
+
+	if !ok {
+		return "", nil, false
+	}
+
+	return userID, form.(url.Values), true
+}
+
+func consentGetHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		userID, form, ok := pendingConsent(provider, store)
+
+		if !ok {
+			context.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		var scopes []scopeDescription
+
+		for _, s := range strings.Fields(services.NormalizeScope(form.Get("scope"))) {
+			scopes = append(scopes, scopeDescription{s, services.ScopeDescriptions[s]})
+		}
+
+		renderHTML(context, http.StatusOK, "consent.tmpl", gin.H{
+			"client": form.Get("client_id"),
+			"email":  userID,
+			"scopes": scopes,
+		})
+	}
+}
+
+func consentPostHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+		consentRepo := provider.GetConsentRepo()
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		userID, form, ok := pendingConsent(provider, store)
+
+		if !ok {
+			context.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		store.Delete(services.ConsentUserKey)
+		store.Delete(services.ConsentExpiresKey)
+
+		if context.PostForm("decision") != "allow" {
+			store.Delete("ReturnUri")
+			store.Save()
+
+			denyAuthorization(context, form)
+			return
+		}
+
+		consentRepo.GrantConsent(userID, form.Get("client_id"), services.NormalizeScope(form.Get("scope")))
+
+		store.Set("LoggedInUserID", userID)
+		store.Save()
+
+		context.Redirect(http.StatusFound, "/oauth/authorize")
+	}
+}
+
+// denyAuthorization sends the client the access_denied error. The redirect
+// URI was already checked against the client before consent was asked for.
+func denyAuthorization(context *gin.Context, form url.Values) {
+	redirectURI, err := url.Parse(form.Get("redirect_uri"))
+
+	if err != nil || form.Get("redirect_uri") == "" {
+		renderHTML(context, http.StatusOK, "consent.tmpl", gin.H{
+			"denied": true,
+		})
+		return
+	}
+
+	query := redirectURI.Query()
+	query.Set("error", "access_denied")
+	query.Set("error_description", "The user denied the request")
+
+	if state := form.Get("state"); state != "" {
+		query.Set("state", state)
+	}
+
+	redirectURI.RawQuery = query.Encode()
+
+	context.Redirect(http.StatusFound, redirectURI.String())
+}
+
+// listConsentsHandler and revokeConsentHandler only accept tokens issued to
+// our own frontend, so a registered client can't see or revoke the user's
+// other grants.
+func listConsentsHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		srv := provider.GetOauthServer()
+		consentRepo := provider.GetConsentRepo()
+
+		token, err := srv.ValidationBearerToken(context.Request)
+		if err != nil {
+			bearerError(context, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		if token.GetClientID() != services.FirstPartyClientID() {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "consents can only be managed from HomeTrainers.net")
+			return
+		}
+
+		consents := []gin.H{}
+
+		for _, grant := range consentRepo.GetConsents(token.GetUserID()) {
+			consents = append(consents, gin.H{
+				"client_id":  grant.ClientID,
+				"scope":      grant.Scope,
+				"granted_at": grant.UpdatedAt,
+			})
+		}
+
+		context.Header("Cache-Control", "no-store")
+		context.JSON(http.StatusOK, consents)
+	}
+}
+
+func revokeConsentHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		srv := provider.GetOauthServer()
+		consentRepo := provider.GetConsentRepo()
+
+		token, err := srv.ValidationBearerToken(context.Request)
+		if err != nil {
+			bearerError(context, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		if token.GetClientID() != services.FirstPartyClientID() {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "consents can only be managed from HomeTrainers.net")
+			return
+		}
+
+		if !consentRepo.RevokeConsent(token.GetUserID(), context.Param("client_id"), context.ClientIP()) {
+			context.JSON(http.StatusNotFound, gin.H{
+				"error": "consent not found",
+			})
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func SetupConsentRouter(db *gorm.DB) *gin.Engine {
+	session := &services.SessionApi{}
+
+	srv, _ := SetupOauthServerWithSession(db, session)
+
+	return SetupRouter(db, srv, &mocks.MockClock{Time: now})
+}
+
+func GetWithCookies(router http.Handler, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", path, nil)
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func AuthorizePath(scope string) string {
+	query := url.Values{
+		"client_id":     {testClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {testRedirectURI},
+		"scope":         {scope},
+		"state":         {"state-123"},
+	}
+
+	return "/oauth/authorize?" + query.Encode()
+}
+
+// LoginAndAuthorize logs in and starts an authorize request, returning the
+// session cookies and the response.
+func LoginAndAuthorize(router http.Handler, scope string) ([]*http.Cookie, *httptest.ResponseRecorder) {
+	login := PostLogin(router, testUser, testPassword)
+	cookies := login.Result().Cookies()
+
+	return cookies, GetWithCookies(router, AuthorizePath(scope), cookies)
+}
+
+func TestAuthorizeAsksForConsent(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	cookies, w := LoginAndAuthorize(router, "openid email")
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/consent", w.Header().Get("Location"))
+
+	page := GetWithCookies(router, "/consent", cookies)
+
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), testClientID)
+	assert.Contains(t, page.Body.String(), services.ScopeDescriptions["openid"])
+	assert.Contains(t, page.Body.String(), services.ScopeDescriptions["email"])
+	assert.NotContains(t, page.Body.String(), services.ScopeDescriptions["profile"])
+}
+
+func TestConsentAllowIssuesCodeWithGrantedScope(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	cookies, _ := LoginAndAuthorize(router, "openid email admin")
+
+	allow := PostForm(router, "/consent", url.Values{"decision": {"allow"}}, cookies)
+
+	assert.Equal(t, "/oauth/authorize", allow.Header().Get("Location"))
+
+	var grant models.ConsentGrant
+	db.Where("user_id = ? and client_id = ?", testUser, testClientID).First(&grant)
+
+	assert.Equal(t, "openid email", grant.Scope)
+
+	w := GetWithCookies(router, "/oauth/authorize", cookies)
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	assert.Equal(t, testRedirectURI, fmt.Sprintf("%s://%s%s", location.Scheme, location.Host, location.Path))
+	assert.Equal(t, "state-123", location.Query().Get("state"))
+
+	token := PostForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {testClientID},
+		"client_secret": {testClientSecret},
+	}, nil)
+
+	var data map[string]interface{}
+	json.Unmarshal(token.Body.Bytes(), &data)
+
+	assert.Equal(t, http.StatusOK, token.Code)
+	assert.Equal(t, "openid email", data["scope"])
+}
+
+func TestConsentDenyRedirectsWithError(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	cookies, _ := LoginAndAuthorize(router, "openid")
+
+	deny := PostForm(router, "/consent", url.Values{"decision": {"deny"}}, cookies)
+
+	location, _ := url.Parse(deny.Header().Get("Location"))
+
+	assert.Equal(t, "access_denied", location.Query().Get("error"))
+	assert.Equal(t, "state-123", location.Query().Get("state"))
+
+	var count int64
+	db.Model(&models.ConsentGrant{}).Count(&count)
+
+	assert.Equal(t, int64(0), count)
+
+	retry := PostForm(router, "/consent", url.Values{"decision": {"allow"}}, cookies)
+
+	assert.Equal(t, "/login", retry.Header().Get("Location"))
+}
+
+func TestAuthorizeSkipsConsentWhenGranted(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	consentRepo := services.CreateConsentRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, testClientID, "openid email")
+
+	_, w := LoginAndAuthorize(router, "openid")
+
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), testRedirectURI+"?code="))
+
+	_, more := LoginAndAuthorize(router, "openid profile")
+
+	assert.Equal(t, "/consent", more.Header().Get("Location"))
+}
+
+func TestConsentRequiresPendingRequest(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	w := GetWithCookies(router, "/consent", nil)
+
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+func TestListAndRevokeConsents(t *testing.T) {
+	db := Setup()
+
+	t.Setenv("CLIENT_ID", testClientID)
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	ti, _ := tokenStore.GetByAccess(context.Background(), "access-token")
+	families := CreateFamilyRepo(db)
+	families.StartFamily(ti, "")
+
+	consentRepo := services.CreateConsentRepo(db, mockClock, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, testClientID, "openid")
+	consentRepo.GrantConsent(testUser, testClientID, "email openid")
+
+	list := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/consents", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(list, req)
+
+	var consents []map[string]interface{}
+	json.Unmarshal(list.Body.Bytes(), &consents)
+
+	assert.Equal(t, http.StatusOK, list.Code)
+	assert.Len(t, consents, 1)
+	assert.Equal(t, testClientID, consents[0]["client_id"])
+	assert.Equal(t, "openid email", consents[0]["scope"])
+
+	missing := httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/consents/other-client", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(missing, req)
+
+	assert.Equal(t, http.StatusNotFound, missing.Code)
+
+	revoke := httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/consents/"+testClientID, nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(revoke, req)
+
+	assert.Equal(t, http.StatusNoContent, revoke.Code)
+	assert.False(t, consentRepo.HasConsent(testUser, testClientID, ""))
+	assert.True(t, families.IsRevoked("refresh-token"))
+
+	again := httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/consents", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(again, req)
+
+	assert.Equal(t, http.StatusUnauthorized, again.Code)
+}
+
+func TestConsentsRequireFirstPartyToken(t *testing.T) {
+	db := Setup()
+
+	t.Setenv("CLIENT_ID", "first-party")
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	consentRepo := services.CreateConsentRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, "other-client", "openid")
+
+	list := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/consents", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(list, req)
+
+	assert.Equal(t, http.StatusForbidden, list.Code)
+
+	revoke := httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/consents/other-client", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(revoke, req)
+
+	assert.Equal(t, http.StatusForbidden, revoke.Code)
+	assert.True(t, consentRepo.HasConsent(testUser, "other-client", ""))
+}
+
+func TestNormalizeScope(t *testing.T) {
+	assert.Equal(t, "openid email", services.NormalizeScope("openid admin email openid"))
+	assert.Equal(t, "", services.NormalizeScope("all"))
+}
+
+func TestUserInfoIncludesScope(t *testing.T) {
+	db := Setup()
+
+	t.Setenv("CLIENT_ID", testClientID)
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/user-info", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	router.ServeHTTP(w, req)
+
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "all", data["scope"])
+	assert.Equal(t, testClientID, data["client_id"])
+}
+
+func TestUserInfoRequiresFirstPartyToken(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	for _, path := range []string{"/user-info", "/validate"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer access-token")
+		router.ServeHTTP(w, req)
+
+		var data map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &data)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "insufficient_scope", data["error"])
+		assert.Nil(t, data["email"])
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"server/services"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	csrfContextKey = "CSRFToken"
+	csrfCookieName = "hpt_csrf"
+	csrfFormField  = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+)
+
+var csrfFailedMessage = "Your session has expired or the form was submitted from another site. Please go back and try again."
+
+// csrfProtect makes sure the browser has a signed CSRF cookie, making its
+// token available to renderHTML, and rejects unsafe requests that don't send
+// the token back in the csrf_token form field or the X-CSRF-Token header.
+// Nothing is stored server side, so anonymous visits don't start sessions.
+func csrfProtect(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+
+		token := ""
+		if cookie, err := context.Request.Cookie(csrfCookieName); err == nil && validCSRFToken(session, cookie.Value) {
+			token = cookie.Value
+		}
+
+		if token == "" {
+			nonce := services.RandomToken(32)
+			token = nonce + "." + session.Sign(nonce)
+
+			session.SetCookie(context.Writer, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+			})
+		}
+
+		context.Set(csrfContextKey, token)
+
+		switch context.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			submitted := context.GetHeader(csrfHeader)
+			if submitted == "" {
+				submitted = context.PostForm(csrfFormField)
+			}
+
+			if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+				renderHTML(context, http.StatusForbidden, "error.tmpl", gin.H{
+					"error": csrfFailedMessage,
+				})
+				context.Abort()
+				return
+			}
+		}
+
+		context.Next()
+	}
+}
+
+// validCSRFToken checks that a token from the cookie was signed by us, so a
+// cookie planted from a sibling domain can't be paired with a forged form.
+func validCSRFToken(session services.SessionApiType, token string) bool {
+	nonce, signature, found := strings.Cut(token, ".")
+	if !found || nonce == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(session.Sign(nonce))) == 1
+}
+
+// renderHTML renders a template with the request's CSRF token, which every
+// form posts back as csrf_token.
+func renderHTML(
+	context *gin.Context,
+	status int,
+	name string,
+	data gin.H,
+) {
+	data["csrfToken"] = context.GetString(csrfContextKey)
+	context.HTML(status, name, data)
+}
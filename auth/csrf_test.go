@@ -0,0 +1,293 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+func CSRFTokenFrom(body string) string {
+	if match := csrfInput.FindStringSubmatch(body); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// SignRequest loads a form page with the request's cookies and sends the
+// CSRF token back in a header, the way a browser submitting the form would.
+// Any session cookie the page sets is added to the request and copied to
+// the response.
+func SignRequest(router http.Handler, w *httptest.ResponseRecorder, req *http.Request) {
+	page := GetWithCookies(router, "/forgot-password", req.Cookies())
+
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range req.Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+
+	for _, cookie := range page.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+		w.Header().Add("Set-Cookie", cookie.String())
+	}
+
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	req.Header.Set(csrfHeader, CSRFTokenFrom(page.Body.String()))
+}
+
+func TestFormsIncludeCSRFToken(t *testing.T) {
+	db := Setup()
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	for _, path := range []string{"/login", "/signup", "/forgot-password"} {
+		w := GetWithCookies(router, path, nil)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, CSRFTokenFrom(w.Body.String()), 87, path)
+	}
+}
+
+func TestCSRFTokenDoesNotStartSession(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	clock := &mocks.MockClock{Time: now}
+	signingKey, _ := services.GenerateSigningKey()
+
+	sessionApi := services.CreateSessionApi(services.CreateSessionStore(db, clock, 0), services.SessionConfig{
+		CookieName: "test_session",
+		Secret:     []byte("secret"),
+		TTL:        time.Hour,
+		Secure:     true,
+		SameSite:   "Lax",
+	})
+
+	serviceProvider := services.CreateServiceProvider(
+		sessionApi,
+		db,
+		services.OauthServerType(&mocks.MockOauthServer{}),
+		&mocks.MockEmailService{},
+		&mocks.MockBackendService{},
+		&mocks.MockUpstreamService{},
+		&mocks.MockCodeGenerator{},
+		clock,
+		services.CreateKeySet(signingKey, nil, clock),
+		services.PasswordPolicy{MinLength: 8},
+	)
+
+	router := setupRouter(&serviceProvider)
+
+	w := GetWithCookies(router, "/login", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	db.Model(&models.Session{}).Count(&count)
+
+	assert.Equal(t, int64(0), count)
+
+	var csrfCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == csrfCookieName {
+			csrfCookie = cookie
+		}
+	}
+
+	assert.NotNil(t, csrfCookie)
+	assert.Equal(t, CSRFTokenFrom(w.Body.String()), csrfCookie.Value)
+	assert.True(t, csrfCookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, csrfCookie.SameSite)
+}
+
+func TestForgedCSRFCookieIsRejected(t *testing.T) {
+	db := Setup()
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	forged := "attacker-nonce.forged-signature"
+
+	form := url.Values{
+		"email":      {testUser},
+		"password":   {testPassword},
+		"csrf_token": {forged},
+	}
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: forged})
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "submitted from another site")
+}
+
+func TestPostWithoutCSRFTokenIsRejected(t *testing.T) {
+	db := Setup()
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	form := url.Values{"email": {testUser}, "password": {testPassword}}
+
+	for _, path := range []string{"/login", "/signup", "/validate-email?email=" + testUser} {
+		w := httptest.NewRecorder()
+
+		req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+	}
+
+	assert.Len(t, AuditEvents(db, services.AuditLoginSucceeded), 0)
+}
+
+func TestPostWithCSRFFormField(t *testing.T) {
+	db := Setup()
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	page := GetWithCookies(router, "/login", nil)
+	cookies := page.Result().Cookies()
+
+	form := url.Values{
+		"email":      {testUser},
+		"password":   {testPassword},
+		"csrf_token": {"wrong-token"},
+	}
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	form.Set("csrf_token", CSRFTokenFrom(page.Body.String()))
+
+	w = httptest.NewRecorder()
+
+	req, _ = http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+}
+
+func TestTokenFromAnotherBrowserIsRejected(t *testing.T) {
+	db := Setup()
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	other := GetWithCookies(router, "/login", nil)
+
+	form := url.Values{
+		"email":      {testUser},
+		"password":   {testPassword},
+		"csrf_token": {CSRFTokenFrom(other.Body.String())},
+	}
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCORSAllowsConfiguredOrigins(t *testing.T) {
+	redirect, _ := url.Parse(testRedirectURI)
+	clientOrigin := redirect.Scheme + "://" + redirect.Host
+
+	t.Setenv("CORS_ORIGINS", clientOrigin+", https://app.example.com")
+
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	// registered clients don't get CORS access, anyone could register one
+	clientStore := services.CreateClientStore(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	clientStore.CreateClient(&services.Client{
+		RedirectURIs: []string{"https://registered.example.com/callback"},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       services.SupportedScopes,
+		Dynamic:      true,
+	})
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	for origin, allowed := range map[string]bool{
+		clientOrigin:                     true,
+		"https://app.example.com":        true,
+		"https://evil.example.com":       false,
+		"https://registered.example.com": false,
+	} {
+		w := httptest.NewRecorder()
+
+		req, _ := http.NewRequest("OPTIONS", "/userinfo", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+
+		router.ServeHTTP(w, req)
+
+		if allowed {
+			assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"))
+		} else {
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
+
+func TestCORSOriginsDefaultToFirstPartyClient(t *testing.T) {
+	t.Setenv("CORS_ORIGINS", "")
+	t.Setenv("CLIENT_REDIRECT_URIS", "https://www.example.com/api/auth/callback/auth")
+	t.Setenv("CLIENT_POST_LOGOUT_REDIRECT_URIS", "https://www.example.com, https://admin.example.com/")
+
+	assert.Equal(t, []string{
+		"https://www.example.com",
+		"https://www.example.com",
+		"https://admin.example.com",
+	}, services.CORSOrigins())
+}
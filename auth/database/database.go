@@ -41,7 +41,21 @@ func initializeDb(db *gorm.DB, dbErr error) {
 	db.Logger = logger.Default.LogMode(logger.Info)
 
 	log.Println("running migrations")
-	db.AutoMigrate(&models.User{})
+	db.AutoMigrate(
+		&models.User{},
+		&models.AuthorizationNonce{},
+		&models.TokenFamily{},
+		&models.RefreshToken{},
+		&models.TokenEvent{},
+		&models.LoginAttempt{},
+		&models.RecoveryCode{},
+		&models.Session{},
+		&models.ConsentGrant{},
+		&models.OauthClient{},
+		&models.LinkedIdentity{},
+		&models.AuditEvent{},
+		&models.PairwiseSubject{},
+	)
 
 	DB = Dbinstance{
 		Db: db,
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mailer"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"server/emails"
+	"server/mocks"
+	"server/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderEmailsInEveryLocale(t *testing.T) {
+	link := "https://auth.example.com/reset-password?code=abc&email=a%2Bb%40example.com"
+
+	for _, name := range []string{"verification", "password-reset", "lockout", "login-link"} {
+		for _, locale := range emails.Locales {
+			content, err := emails.Templates.Render(name, locale, map[string]string{"Link": link})
+
+			assert.Nil(t, err, name+" "+locale)
+			assert.NotEmpty(t, content.Subject, name+" "+locale)
+			assert.Contains(t, content.Text, link, name+" "+locale)
+			assert.Contains(t, content.HTML, `lang="`+locale+`"`, name+" "+locale)
+			assert.Contains(t, content.HTML, "code=abc&amp;email=a%2Bb%40example.com", name+" "+locale)
+		}
+	}
+
+	content, _ := emails.Templates.Render("verification", "fr-CA", map[string]string{"Link": link})
+
+	assert.Equal(t, "Verify email", content.Subject)
+
+	_, err := emails.Templates.Render("missing", "en", nil)
+
+	assert.Error(t, err)
+}
+
+func TestMatchLocale(t *testing.T) {
+	assert.Equal(t, "es", emails.Templates.MatchLocale("es-MX,es;q=0.9,en;q=0.8"))
+	assert.Equal(t, "es", emails.Templates.MatchLocale("fr;q=0.9, es;q=0.5, en;q=0.1"))
+	assert.Equal(t, "en", emails.Templates.MatchLocale("fr, de"))
+	assert.Equal(t, "en", emails.Templates.MatchLocale(""))
+	assert.Equal(t, "es", emails.Templates.SupportedLocale("ES-ar"))
+}
+
+func TestMessageBytes(t *testing.T) {
+	content, _ := emails.Templates.Render("login-link", "es", map[string]string{"Link": "https://auth.example.com/login"})
+
+	message := mailer.Message{
+		From:    mail.Address{Name: "HomeTrainers.net", Address: "support@hometrainers.net"},
+		To:      mail.Address{Address: "user@example.com"},
+		ReplyTo: &mail.Address{Name: "José", Address: "jose@example.com"},
+		Date:    now,
+		Content: content,
+	}
+
+	data, err := message.Bytes()
+
+	assert.Nil(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+
+	assert.Nil(t, err)
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+
+	assert.Equal(t, "Enlace de inicio de sesión", subject)
+	assert.Equal(t, "<user@example.com>", parsed.Header.Get("To"))
+	assert.Equal(t, "1.0", parsed.Header.Get("MIME-Version"))
+	assert.True(t, strings.HasSuffix(parsed.Header.Get("Message-ID"), "@hometrainers.net>"))
+
+	replyTo, _ := parsed.Header.AddressList("Reply-To")
+
+	assert.Equal(t, "José", replyTo[0].Name)
+
+	date, _ := parsed.Header.Date()
+
+	assert.True(t, now.Truncate(time.Second).Equal(date))
+
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+
+	var types []string
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+
+		body, _ := io.ReadAll(part)
+		types = append(types, part.Header.Get("Content-Type"))
+
+		assert.Contains(t, string(body), "sesión")
+	}
+
+	assert.Equal(t, []string{"text/plain; charset=UTF-8", "text/html; charset=UTF-8"}, types)
+}
+
+func TestSignupUsesPreferredLocale(t *testing.T) {
+	db := Setup()
+
+	db.Exec("delete from users")
+
+	mockEmail := mocks.MockEmailService{}
+
+	router := SetupRouter(db, &mockEmail)
+
+	defer Teardown(db)
+
+	form := url.Values{"email": {testUser}, "password": {testPassword}, "name": {testName}}
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9")
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "es", mockEmail.Locale)
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.Equal(t, "es", user.Locale)
+
+	PostForm(router, "/forgot-password", url.Values{"email": {testUser}}, nil)
+
+	assert.Equal(t, "", mockEmail.ResetEmail)
+
+	db.Exec("update users set validated = ?", true)
+
+	PostForm(router, "/forgot-password", url.Values{"email": {testUser}}, nil)
+
+	assert.Equal(t, testUser, mockEmail.ResetEmail)
+	assert.Equal(t, "es", mockEmail.Locale)
+}
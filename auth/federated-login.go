@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"server/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-session/session"
+)
+
+const (
+	federatedProviderKey = "FederatedProvider"
+	federatedStateKey    = "FederatedState"
+	federatedNonceKey    = "FederatedNonce"
+	federatedVerifierKey = "FederatedVerifier"
+	federatedExpiresKey  = "FederatedExpires"
+)
+
+var federatedExpiredMessage = "Your sign in session has expired. Please try again."
+
+func CreateFederatedLoginHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.GET("/login/federated/:provider", csrf, federatedLoginHandler(provider))
+	router.GET("/login/federated/:provider/callback", csrf, federatedCallbackHandler(provider))
+}
+
+func federatedRedirectURI(upstream services.UpstreamProvider) string {
+	return fmt.Sprintf("%s/login/federated/%s/callback", services.GetHost(), upstream.ID)
+}
+
+// federatedLoginHandler sends the user to the upstream provider. The state,
+// nonce and PKCE verifier are kept in the session for ten minutes.
+func federatedLoginHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+		upstreamService := provider.GetUpstreamService()
+		clock := provider.GetClock()
+
+		upstream, ok := upstreamService.GetProvider(context.Param("provider"))
+
+		if !ok {
+			renderHTML(context, http.StatusNotFound, "login.tmpl", gin.H{
+				"error": "Unknown sign in provider",
+			})
+			return
+		}
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// 32 random bytes give a 43 character verifier, the shortest PKCE allows
+		state := services.RandomToken(32)
+		nonce := services.RandomToken(32)
+		verifier := services.RandomToken(32)
+
+		location, urlErr := upstreamService.AuthCodeURL(upstream, federatedRedirectURI(upstream), state, nonce, verifier)
+
+		if urlErr != nil {
+			log.Printf("failed to start sign in with %s: %s", upstream.ID, urlErr.Error())
+
+			renderHTML(context, http.StatusBadGateway, "login.tmpl", gin.H{
+				"error": fmt.Sprintf("Sign in with %s is unavailable. Please try again later.", upstream.Name),
+			})
+			return
+		}
+
+		store.Set(federatedProviderKey, upstream.ID)
+		store.Set(federatedStateKey, state)
+		store.Set(federatedNonceKey, nonce)
+		store.Set(federatedVerifierKey, verifier)
+		store.Set(federatedExpiresKey, clock.AddTime(clock.GetCurrentTime(), 0, 10, 0).Format(time.RFC3339))
+		store.Save()
+
+		context.Redirect(http.StatusFound, location)
+	}
+}
+
+func federatedCallbackHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+		upstreamService := provider.GetUpstreamService()
+		userRepo := provider.GetUserRepo()
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		query := context.Request.URL.Query()
+
+		upstream, ok := upstreamService.GetProvider(context.Param("provider"))
+		state, started := sessionValueBefore(store, federatedStateKey, federatedExpiresKey, provider.GetClock())
+		providerID, _ := store.Get(federatedProviderKey)
+		nonce, _ := store.Get(federatedNonceKey)
+		verifier, _ := store.Get(federatedVerifierKey)
+		nonceValue, _ := nonce.(string)
+		verifierValue, _ := verifier.(string)
+
+		clearFederatedLogin(store)
+
+		if !ok || !started || providerID != upstream.ID ||
+			subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+			renderHTML(context, http.StatusBadRequest, "login.tmpl", gin.H{
+				"error": federatedExpiredMessage,
+			})
+			return
+		}
+
+		if query.Get("error") != "" {
+			renderHTML(context, http.StatusBadRequest, "login.tmpl", gin.H{
+				"error": fmt.Sprintf("Sign in with %s was cancelled", upstream.Name),
+			})
+			return
+		}
+
+		identity, exchangeErr := upstreamService.Exchange(
+			upstream,
+			federatedRedirectURI(upstream),
+			query.Get("code"),
+			verifierValue,
+			nonceValue,
+		)
+
+		if exchangeErr != nil {
+			log.Printf("failed to complete sign in with %s: %s", upstream.ID, exchangeErr.Error())
+
+			renderHTML(context, http.StatusBadGateway, "login.tmpl", gin.H{
+				"error": fmt.Sprintf("There was an error signing in with %s. Please try again.", upstream.Name),
+			})
+			return
+		}
+
+		user, linkErr := userRepo.LinkUpstreamIdentity(upstream.ID, identity)
+
+		if errors.Is(linkErr, services.ErrUpstreamEmailUnverified) {
+			renderHTML(context, http.StatusBadRequest, "login.tmpl", gin.H{
+				"error": fmt.Sprintf("Please verify your %s email address before using it to sign in.", upstream.Name),
+			})
+			return
+		}
+
+		if linkErr != nil {
+			renderHTML(context, http.StatusInternalServerError, "login.tmpl", gin.H{
+				"error": fmt.Sprintf("There was an error signing in with %s. Please try again.", upstream.Name),
+			})
+			return
+		}
+
+		if user.Disabled {
+			renderHTML(context, http.StatusForbidden, "login.tmpl", gin.H{
+				"error": disabledMessage,
+			})
+			return
+		}
+
+		if user.ResetRequired {
+			recordAudit(context, provider, services.AuditLoginFailed, user.Email, "", "password_reset_required")
+
+			renderHTML(context, http.StatusForbidden, "login.tmpl", gin.H{
+				"error": resetRequiredMessage,
+			})
+			return
+		}
+
+		if user.TOTPEnabled {
+			startTwoFactorLogin(provider, store, user.Email)
+
+			context.Redirect(http.StatusFound, "/login/2fa")
+			return
+		}
+
+		userRepo.ClearFailedLogins(user.Email)
+		recordAudit(context, provider, services.AuditLoginSucceeded, user.Email, returnClientID(store), "federated:"+upstream.ID)
+
+		store.Set("LoggedInUserID", user.Email)
+		store.Save()
+
+		context.Redirect(http.StatusFound, "/auth")
+	}
+}
+
+func clearFederatedLogin(store session.Store) {
+	store.Delete(federatedProviderKey)
+	store.Delete(federatedStateKey)
+	store.Delete(federatedNonceKey)
+	store.Delete(federatedVerifierKey)
+	store.Delete(federatedExpiresKey)
+	store.Save()
+}
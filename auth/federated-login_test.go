@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var testUpstream = services.UpstreamProvider{
+	ID:           "google",
+	Name:         "Google",
+	Issuer:       "https://accounts.example.com",
+	ClientID:     "upstream-client",
+	ClientSecret: "upstream-secret",
+}
+
+func SetupFederatedRouter(db *gorm.DB, identity *services.UpstreamIdentity) (*gin.Engine, *mocks.MockUpstreamService) {
+	session := &services.SessionApi{}
+
+	srv, _ := SetupOauthServerWithSession(db, session)
+
+	upstream := &mocks.MockUpstreamService{
+		Providers: []services.UpstreamProvider{testUpstream},
+		Identity:  identity,
+	}
+
+	return SetupRouter(db, srv, upstream, &mocks.MockClock{Time: now}), upstream
+}
+
+// StartFederatedLogin follows the redirect to the upstream provider and
+// returns the session cookies and the state it was sent.
+func StartFederatedLogin(t *testing.T, router http.Handler, cookies []*http.Cookie) ([]*http.Cookie, string) {
+	w := GetWithCookies(router, "/login/federated/google", cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	if cookies == nil {
+		cookies = w.Result().Cookies()
+	}
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	return cookies, location.Query().Get("state")
+}
+
+func FederatedCallbackPath(state string) string {
+	return "/login/federated/google/callback?" + url.Values{
+		"state": {state},
+		"code":  {"upstream-code"},
+	}.Encode()
+}
+
+func TestLoginShowsUpstreamProviders(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupFederatedRouter(db, nil)
+
+	defer Teardown(db)
+
+	w := GetWithCookies(router, "/login", nil)
+
+	assert.Contains(t, w.Body.String(), "Continue with Google")
+	assert.Contains(t, w.Body.String(), "/login/federated/google")
+}
+
+func TestFederatedLoginLinksExistingUser(t *testing.T) {
+	db := Setup()
+
+	router, upstream := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-1",
+		Email:         testUser,
+		EmailVerified: true,
+	})
+
+	defer Teardown(db)
+
+	authorize := GetWithCookies(router, AuthorizePath("openid email"), nil)
+	cookies, state := StartFederatedLogin(t, router, authorize.Result().Cookies())
+
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+	assert.Equal(t, "upstream-code", upstream.Code)
+	assert.Equal(t, services.GetHost()+"/login/federated/google/callback", upstream.RedirectURI)
+
+	GetWithCookies(router, "/auth", cookies)
+	w = GetWithCookies(router, "/oauth/authorize", cookies)
+
+	assert.Equal(t, "/consent", w.Header().Get("Location"))
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	var linked models.LinkedIdentity
+	db.Where("provider = ? and subject = ?", "google", "upstream-1").First(&linked)
+
+	assert.Equal(t, user.ID, linked.UserID)
+}
+
+func TestFederatedLoginUsesLinkedIdentity(t *testing.T) {
+	db := Setup()
+
+	router, upstream := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-1",
+		Email:         testUser,
+		EmailVerified: true,
+	})
+
+	defer Teardown(db)
+
+	cookies, state := StartFederatedLogin(t, router, nil)
+	GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	// the upstream email changing doesn't move the identity to another user
+	upstream.Identity = &services.UpstreamIdentity{
+		Subject:       "upstream-1",
+		Email:         "changed@example.com",
+		EmailVerified: true,
+	}
+
+	cookies, state = StartFederatedLogin(t, router, nil)
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	var count int64
+	db.Model(&models.User{}).Where("email = ?", "changed@example.com").Count(&count)
+
+	assert.Zero(t, count)
+}
+
+func TestFederatedLoginCreatesUser(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-2",
+		Email:         "new@example.com",
+		EmailVerified: true,
+		Name:          "New User",
+	})
+
+	defer Teardown(db)
+
+	cookies, state := StartFederatedLogin(t, router, nil)
+
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	var user models.User
+	db.Where("email = ?", "new@example.com").First(&user)
+
+	assert.True(t, user.Validated)
+	assert.Equal(t, "New User", user.Name)
+	assert.Equal(t, "", user.Password)
+}
+
+func TestFederatedLoginDropsPendingPassword(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-3",
+		Email:         testUser,
+		EmailVerified: true,
+	})
+
+	defer Teardown(db)
+
+	db.Exec("update users set validated = ? where email = ?", false, testUser)
+
+	cookies, state := StartFederatedLogin(t, router, nil)
+
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, user.Validated)
+	assert.Equal(t, "", user.Password)
+}
+
+func TestFederatedLoginUnverifiedEmail(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject: "upstream-4",
+		Email:   testUser,
+	})
+
+	defer Teardown(db)
+
+	cookies, state := StartFederatedLogin(t, router, nil)
+
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var count int64
+	db.Model(&models.LinkedIdentity{}).Count(&count)
+
+	assert.Zero(t, count)
+}
+
+func TestFederatedLoginStateMismatch(t *testing.T) {
+	db := Setup()
+
+	router, upstream := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-1",
+		Email:         testUser,
+		EmailVerified: true,
+	})
+
+	defer Teardown(db)
+
+	cookies, _ := StartFederatedLogin(t, router, nil)
+
+	w := GetWithCookies(router, FederatedCallbackPath("other-state"), cookies)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), federatedExpiredMessage)
+	assert.Equal(t, "", upstream.Code)
+}
+
+func TestFederatedLoginTwoFactor(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-1",
+		Email:         testUser,
+		EmailVerified: true,
+	})
+
+	defer Teardown(db)
+
+	SetupTwoFactorUser(db, &mocks.MockClock{Time: now})
+
+	cookies, state := StartFederatedLogin(t, router, nil)
+
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login/2fa", w.Header().Get("Location"))
+}
+
+func TestFederatedLoginResetRequired(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupFederatedRouter(db, &services.UpstreamIdentity{
+		Subject:       "upstream-1",
+		Email:         testUser,
+		EmailVerified: true,
+	})
+
+	defer Teardown(db)
+
+	db.Model(&models.User{}).Where("email = ?", testUser).Update("reset_required", true)
+
+	cookies, state := StartFederatedLogin(t, router, nil)
+
+	w := GetWithCookies(router, FederatedCallbackPath(state), cookies)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), resetRequiredMessage)
+
+	w = GetWithCookies(router, "/auth", cookies)
+
+	assert.NotEqual(t, "/oauth/authorize", w.Header().Get("Location"))
+}
+
+func TestUpstreamServiceExchange(t *testing.T) {
+	key, _ := services.GenerateSigningKey()
+
+	var issuer string
+	var form url.Values
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(services.JWKS{Keys: []services.JWK{key.JWK()}})
+	})
+	claims := jwt.MapClaims{
+		"sub":            "upstream-1",
+		"aud":            "upstream-client",
+		"email":          testUser,
+		"email_verified": true,
+		"nonce":          "nonce-123",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		claims["iss"] = issuer
+
+		token := jwt.NewWithClaims(key.Method, claims)
+		token.Header["kid"] = key.ID
+
+		idToken, _ := token.SignedString(key.Private)
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	issuer = server.URL
+
+	upstream := services.UpstreamProvider{ID: "test", Issuer: issuer, ClientID: "upstream-client", ClientSecret: "upstream-secret"}
+	service := services.CreateUpstreamService([]services.UpstreamProvider{upstream}, &mocks.MockClock{Time: now})
+
+	location, err := service.AuthCodeURL(upstream, "https://auth.example.com/callback", "state-123", "nonce-123", "verifier-123")
+
+	assert.Nil(t, err)
+
+	authURL, _ := url.Parse(location)
+
+	assert.Equal(t, issuer+"/authorize", authURL.Scheme+"://"+authURL.Host+authURL.Path)
+	assert.Equal(t, services.CodeChallengeS256("verifier-123"), authURL.Query().Get("code_challenge"))
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+
+	identity, err := service.Exchange(upstream, "https://auth.example.com/callback", "code-123", "verifier-123", "nonce-123")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "upstream-1", identity.Subject)
+	assert.Equal(t, testUser, identity.Email)
+	assert.True(t, identity.EmailVerified)
+	assert.Equal(t, "verifier-123", form.Get("code_verifier"))
+	assert.Equal(t, "upstream-secret", form.Get("client_secret"))
+
+	_, err = service.Exchange(upstream, "https://auth.example.com/callback", "code-123", "verifier-123", "other-nonce")
+
+	assert.Equal(t, services.ErrInvalidUpstreamToken, err)
+	delete(claims, "exp")
+
+	_, err = service.Exchange(upstream, "https://auth.example.com/callback", "code-123", "verifier-123", "nonce-123")
+
+	assert.Equal(t, services.ErrInvalidUpstreamToken, err)
+
+	claims["exp"] = now.Add(time.Hour).Unix()
+	delete(claims, "iat")
+
+	_, err = service.Exchange(upstream, "https://auth.example.com/callback", "code-123", "verifier-123", "nonce-123")
+
+	assert.Equal(t, services.ErrInvalidUpstreamToken, err)
+}
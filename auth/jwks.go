@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateJWKSHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	router.GET("/.well-known/jwks.json", jwksHandler(provider))
+}
+
+func jwksHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		keySet := provider.GetKeySet()
+
+		context.Header("Cache-Control", "public, max-age=300")
+		context.JSON(http.StatusOK, keySet.GetJWKS())
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"server/mocks"
+	"server/services"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthModels "github.com/go-oauth2/oauth2/v4/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestJWKSPublishesActiveAndRetiredKeys(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	signing, _ := services.GenerateSigningKey()
+	retired, _ := services.GenerateSigningKey()
+	expired, _ := services.GenerateSigningKey()
+
+	retired.ExpiresAt = now.Add(time.Hour)
+	expired.ExpiresAt = now.Add(-time.Hour)
+
+	keySet := services.CreateKeySet(signing, []*services.SigningKey{retired, expired}, mockClock)
+
+	router := SetupRouter(db, mockClock, keySet)
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var jwks services.JWKS
+	json.Unmarshal(w.Body.Bytes(), &jwks)
+
+	kids := []string{}
+	for _, key := range jwks.Keys {
+		kids = append(kids, key.Kid)
+		assert.Equal(t, "RSA", key.Kty)
+		assert.Equal(t, "RS256", key.Alg)
+		assert.Equal(t, "sig", key.Use)
+		assert.NotEmpty(t, key.N)
+		assert.NotEmpty(t, key.E)
+	}
+
+	assert.Equal(t, []string{signing.ID, retired.ID}, kids)
+}
+
+func TestLoadKeySetFromPEM(t *testing.T) {
+	private, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(private)
+	signingPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	oldDer, _ := x509.MarshalPKIXPublicKey(&oldKey.PublicKey)
+	retiredPEM := pem.EncodeToMemory(&pem.Block{
+		Type:    "PUBLIC KEY",
+		Headers: map[string]string{"Key-Id": "old-key", "Expires": "2023-04-02T10:00:00Z"},
+		Bytes:   oldDer,
+	})
+
+	t.Setenv("JWT_SIGNING_KEY", string(signingPEM))
+	t.Setenv("JWT_RETIRED_KEYS", string(retiredPEM))
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	keySet, err := services.LoadKeySet(mockClock)
+	assert.Nil(t, err)
+
+	reloaded, _ := services.LoadKeySet(mockClock)
+
+	signing := keySet.GetSigningKey()
+
+	assert.Equal(t, "ES256", signing.Method.Alg())
+	assert.Equal(t, signing.ID, reloaded.GetSigningKey().ID)
+
+	old, ok := keySet.GetPublicKey("old-key")
+	assert.True(t, ok)
+	assert.Equal(t, "P-256", old.JWK().Crv)
+
+	expiredClock := &mocks.MockClock{Time: now.Add(time.Hour * 48)}
+	expiredSet, _ := services.LoadKeySet(expiredClock)
+
+	_, ok = expiredSet.GetPublicKey("old-key")
+	assert.False(t, ok)
+}
+
+// GenerateAccessToken issues an access token for the test user and returns
+// its verified claims.
+func GenerateAccessToken(t *testing.T, db *gorm.DB, clientID string, scope string) jwt.MapClaims {
+	mockClock := &mocks.MockClock{Time: now}
+
+	signing, _ := services.GenerateSigningKey()
+	keySet := services.CreateKeySet(signing, nil, mockClock)
+
+	generator := services.CreateJWTAccessGenerate(
+		keySet,
+		"http://localhost:9096",
+		services.CreateUserRepo(db, mockClock, CreateFamilyRepo(db)),
+	)
+
+	tokenInfo := oauthModels.NewToken()
+	tokenInfo.SetAccessCreateAt(time.Now())
+	tokenInfo.SetAccessExpiresIn(time.Hour)
+	tokenInfo.SetScope(scope)
+
+	access, refresh, err := generator.Token(context.Background(), &oauth2.GenerateBasic{
+		Client:    &oauthModels.Client{ID: clientID},
+		UserID:    testUser,
+		TokenInfo: tokenInfo,
+	}, true)
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, refresh)
+
+	token, parseErr := jwt.Parse(access, func(token *jwt.Token) (interface{}, error) {
+		key, _ := keySet.GetPublicKey(token.Header["kid"].(string))
+		return key.Public, nil
+	})
+
+	assert.Nil(t, parseErr)
+	assert.Equal(t, "RS256", token.Method.Alg())
+
+	return token.Claims.(jwt.MapClaims)
+}
+
+func TestJWTAccessGenerateSignsWithKid(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	claims := GenerateAccessToken(t, db, "222222", "openid profile email")
+
+	assert.Equal(t, "http://localhost:9096", claims["iss"])
+	assert.Equal(t, UserSubject(db, "222222"), claims["sub"])
+	assert.NotEqual(t, testUser, claims["sub"])
+	assert.Equal(t, "222222", claims["aud"])
+	assert.Equal(t, "openid profile email", claims["scope"])
+	assert.Equal(t, testUser, claims["email"])
+	assert.Equal(t, testName, claims["name"])
+}
+
+func TestJWTAccessGenerateLimitedByScope(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	claims := GenerateAccessToken(t, db, "222222", "openid")
+
+	assert.Equal(t, UserSubject(db, "222222"), claims["sub"])
+	assert.Nil(t, claims["email"])
+	assert.Nil(t, claims["name"])
+
+	claims = GenerateAccessToken(t, db, "222222", "openid profile")
+
+	assert.Nil(t, claims["email"])
+	assert.Equal(t, testName, claims["name"])
+
+	claims = GenerateAccessToken(t, db, "222222", "openid email")
+
+	assert.Equal(t, testUser, claims["email"])
+	assert.Nil(t, claims["name"])
+}
+
+func TestJWTAccessGenerateFirstPartyClaims(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	t.Setenv("CLIENT_ID", "222222")
+
+	claims := GenerateAccessToken(t, db, "222222", "")
+
+	assert.Equal(t, testUser, claims["sub"])
+	assert.Equal(t, testUser, claims["email"])
+	assert.Equal(t, testName, claims["name"])
+}
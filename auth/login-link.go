@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const loginLinkSentMessage = "If an account exists for that email, a sign in link has been sent. Please check your email."
+const invalidLoginLinkMessage = "This sign in link is invalid or has expired"
+
+func CreateLoginLinkHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.POST("/login/link", csrf, loginLinkPostHandler(provider))
+	router.GET("/login/link/confirm", csrf, loginLinkConfirmGetHandler(provider))
+	router.POST("/login/link/confirm", csrf, loginLinkConfirmPostHandler(provider))
+}
+
+func loginLinkPostHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		codeGen := provider.GetCodeGenerator()
+		emailService := provider.GetEmailService()
+
+		email := context.Request.FormValue("email")
+
+		if email == "" {
+			renderHTML(context, http.StatusBadRequest, "login.tmpl", gin.H{
+				"error": "Invalid field: email",
+			})
+			return
+		}
+
+		user, _ := userRepo.GetUser(email)
+
+		// Same response whether or not the account exists, as with password
+		// resets.
+		if user != nil && user.Validated && !user.Disabled {
+			code := codeGen.GenCode()
+
+			userRepo.UpdateLoginCode(user, code)
+
+			emailService.SendLoginLink(email, code, user.Locale)
+		}
+
+		renderHTML(context, http.StatusAccepted, "login.tmpl", gin.H{
+			"message": loginLinkSentMessage,
+			"email":   email,
+		})
+	}
+}
+
+// loginLinkConfirmGetHandler only shows a button to finish signing in. Mail
+// scanners that prefetch links would otherwise use up the code.
+func loginLinkConfirmGetHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+
+		email := context.Request.URL.Query().Get("email")
+		code := context.Request.URL.Query().Get("code")
+
+		user, _ := userRepo.GetUser(email)
+
+		if user == nil || !userRepo.ValidLoginCode(user, code) {
+			renderHTML(context, http.StatusBadRequest, "login-link.tmpl", gin.H{
+				"error": invalidLoginLinkMessage,
+			})
+			return
+		}
+
+		renderHTML(context, http.StatusOK, "login-link.tmpl", gin.H{
+			"email": email,
+			"code":  code,
+			"form":  true,
+		})
+	}
+}
+
+func loginLinkConfirmPostHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+		userRepo := provider.GetUserRepo()
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		email := context.Request.FormValue("email")
+		code := context.Request.FormValue("code")
+		ip := context.ClientIP()
+
+		if !userRepo.LoginLockedUntil(email, ip).IsZero() {
+			renderHTML(context, http.StatusTooManyRequests, "login-link.tmpl", gin.H{
+				"error": lockedOutMessage,
+			})
+			return
+		}
+
+		user, _ := userRepo.GetUser(email)
+
+		if user == nil || !user.Validated || user.Disabled || !userRepo.UseLoginCode(user, code) {
+			recordFailedLogin(context, provider, email, user, "login_link")
+
+			renderHTML(context, http.StatusBadRequest, "login-link.tmpl", gin.H{
+				"error": invalidLoginLinkMessage,
+			})
+			return
+		}
+
+		// like password login, a forced reset blocks every other way in
+		if user.ResetRequired {
+			recordAudit(context, provider, services.AuditLoginFailed, email, "", "password_reset_required")
+
+			renderHTML(context, http.StatusForbidden, "login-link.tmpl", gin.H{
+				"error": resetRequiredMessage,
+			})
+			return
+		}
+
+		if user.TOTPEnabled {
+			startTwoFactorLogin(provider, store, email)
+
+			context.Redirect(http.StatusFound, "/login/2fa")
+			return
+		}
+
+		userRepo.ClearFailedLogins(email)
+		recordAudit(context, provider, services.AuditLoginSucceeded, email, returnClientID(store), "login_link")
+
+		store.Set("LoggedInUserID", email)
+		store.Save()
+
+		context.Redirect(http.StatusFound, "/auth")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var loginCode = "login-123"
+
+func SetupLoginLinkRouter(db *gorm.DB, mockClock *mocks.MockClock, mockEmail *mocks.MockEmailService) *gin.Engine {
+	session := &services.SessionApi{}
+
+	srv, _ := SetupOauthServerWithSession(db, session)
+
+	return SetupRouter(db, srv, mockClock, mockEmail, &mocks.MockCodeGenerator{Code: loginCode})
+}
+
+func LoginLinkForm(email string, code string) url.Values {
+	return url.Values{
+		"email": {email},
+		"code":  {code},
+	}
+}
+
+func TestLoginLinkSignsIn(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	authorize := GetWithCookies(router, AuthorizePath("openid email"), nil)
+	cookies := authorize.Result().Cookies()
+
+	assert.Equal(t, "/login", authorize.Header().Get("Location"))
+
+	w := PostForm(router, "/login/link", url.Values{"email": {testUser}}, cookies)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), loginLinkSentMessage)
+	assert.Equal(t, testUser, mockEmail.LoginEmail)
+	assert.Equal(t, loginCode, mockEmail.LoginCode)
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.Equal(t, services.HashToken(loginCode), user.LoginCode)
+	assert.True(t, user.LoginExpiration.Equal(mockClock.AddTime(now, 0, 15, 0)))
+
+	page := GetWithCookies(router, "/login/link/confirm?"+LoginLinkForm(testUser, loginCode).Encode(), cookies)
+
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), testUser)
+
+	w = PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, loginCode), cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+
+	w = GetWithCookies(router, "/auth", cookies)
+
+	assert.Equal(t, "/oauth/authorize", w.Header().Get("Location"))
+
+	w = GetWithCookies(router, "/oauth/authorize", cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/consent", w.Header().Get("Location"))
+}
+
+func TestLoginLinkSingleUse(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	PostForm(router, "/login/link", url.Values{"email": {testUser}}, nil)
+
+	w := PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, loginCode), nil)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	w = PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, loginCode), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), invalidLoginLinkMessage)
+
+	w = GetWithCookies(router, "/login/link/confirm?"+LoginLinkForm(testUser, loginCode).Encode(), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLoginLinkExpired(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	PostForm(router, "/login/link", url.Values{"email": {testUser}}, nil)
+
+	mockClock.Time = mockClock.AddTime(now, 0, 15, 0)
+
+	w := PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, loginCode), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), invalidLoginLinkMessage)
+}
+
+func TestLoginLinkResetRequired(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	PostForm(router, "/login/link", url.Values{"email": {testUser}}, nil)
+
+	db.Model(&models.User{}).Where("email = ?", testUser).Update("reset_required", true)
+
+	w := PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, loginCode), nil)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), resetRequiredMessage)
+
+	w = GetWithCookies(router, "/auth", w.Result().Cookies())
+
+	assert.NotEqual(t, "/oauth/authorize", w.Header().Get("Location"))
+}
+
+func TestLoginLinkWrongCode(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	PostForm(router, "/login/link", url.Values{"email": {testUser}}, nil)
+
+	w := PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, "wrong-code"), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var attempts int64
+	db.Model(&models.LoginAttempt{}).Where("failures > 0").Count(&attempts)
+
+	assert.NotZero(t, attempts)
+}
+
+func TestLoginLinkUnknownEmail(t *testing.T) {
+	db := Setup()
+
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, &mocks.MockClock{Time: now}, mockEmail)
+
+	defer Teardown(db)
+
+	w := PostForm(router, "/login/link", url.Values{"email": {"other-user"}}, nil)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), loginLinkSentMessage)
+	assert.Equal(t, "", mockEmail.LoginEmail)
+}
+
+func TestLoginLinkTwoFactor(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupLoginLinkRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	SetupTwoFactorUser(db, mockClock)
+
+	PostForm(router, "/login/link", url.Values{"email": {testUser}}, nil)
+
+	w := PostForm(router, "/login/link/confirm", LoginLinkForm(testUser, loginCode), nil)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login/2fa", w.Header().Get("Location"))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func PostLogin(router http.Handler, email string, password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestLoginLocksAccountAfterFailures(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	for i := 0; i < 4; i++ {
+		w := PostLogin(router, testUser, "bad-password")
+		assert.Contains(t, w.Body.String(), invalidCredentialsMessage)
+	}
+
+	assert.Equal(t, "", mockEmail.LockoutEmail)
+
+	PostLogin(router, testUser, "bad-password")
+
+	assert.Equal(t, testUser, mockEmail.LockoutEmail)
+
+	w := PostLogin(router, testUser, testPassword)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), lockedOutMessage)
+
+	mockClock.Time = now.Add(time.Minute * 2)
+
+	w = PostLogin(router, testUser, testPassword)
+
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+}
+
+func TestLoginLocksUnknownEmail(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupRouter(db, mockClock, mockEmail)
+
+	defer Teardown(db)
+
+	for i := 0; i < 5; i++ {
+		PostLogin(router, "other-user", "bad-password")
+	}
+
+	w := PostLogin(router, "other-user", "bad-password")
+
+	assert.Contains(t, w.Body.String(), lockedOutMessage)
+	assert.Equal(t, "", mockEmail.LockoutEmail)
+}
+
+func TestLoginLockoutBacksOff(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	mockClock := &mocks.MockClock{Time: now}
+	userRepo := services.CreateUserRepo(db, mockClock, CreateFamilyRepo(db))
+
+	for i := 0; i < 5; i++ {
+		userRepo.RecordFailedLogin(testUser, "10.0.0.1")
+	}
+
+	assert.True(t, userRepo.LoginLockedUntil(testUser, "10.0.0.2").Equal(now.Add(time.Minute)))
+
+	userRepo.RecordFailedLogin(testUser, "10.0.0.1")
+	userRepo.RecordFailedLogin(testUser, "10.0.0.1")
+
+	assert.True(t, userRepo.LoginLockedUntil(testUser, "10.0.0.2").Equal(now.Add(time.Minute*4)))
+
+	userRepo.ClearFailedLogins(testUser)
+
+	assert.True(t, userRepo.LoginLockedUntil(testUser, "10.0.0.2").IsZero())
+}
+
+func TestLoginLocksIPAddress(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	mockClock := &mocks.MockClock{Time: now}
+	userRepo := services.CreateUserRepo(db, mockClock, CreateFamilyRepo(db))
+
+	for i := 0; i < 20; i++ {
+		userRepo.RecordFailedLogin(strings.Repeat("a", i+1), "10.0.0.1")
+	}
+
+	assert.False(t, userRepo.LoginLockedUntil(testUser, "10.0.0.1").IsZero())
+	assert.True(t, userRepo.LoginLockedUntil(testUser, "10.0.0.2").IsZero())
+
+	mockClock.Time = now.Add(time.Hour * 25)
+
+	assert.True(t, userRepo.LoginLockedUntil(testUser, "10.0.0.1").IsZero())
+}
+
+func TestLoginLockoutIgnoresSpoofedForwardedFor(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+
+		form := url.Values{"email": {strings.Repeat("a", i+1)}, "password": {"bad-password"}}
+
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+		req.RemoteAddr = "198.51.100.1:41234"
+
+		SignRequest(router, w, req)
+		router.ServeHTTP(w, req)
+	}
+
+	userRepo := services.CreateUserRepo(db, mockClock, CreateFamilyRepo(db))
+
+	assert.False(t, userRepo.LoginLockedUntil(testUser, "198.51.100.1").IsZero())
+	assert.True(t, userRepo.LoginLockedUntil(testUser, "203.0.113.1").IsZero())
+}
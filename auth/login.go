@@ -2,18 +2,39 @@ package main
 
 import (
 	"net/http"
+	"server/models"
 	"server/services"
 	"server/users"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
+var invalidCredentialsMessage = "Invalid email or password"
+var lockedOutMessage = "Too many failed sign in attempts. Please try again later."
+var disabledMessage = "This account has been disabled. Please contact support@hometrainers.net."
+var resetRequiredMessage = "Your password needs to be reset. Please check your email for a reset link."
+
+var dummyHash string
+var dummyHashOnce sync.Once
+
+// dummyPasswordHash is made on first use, once the hash parameters are
+// configured, so checking it takes as long as checking a real password.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = users.HashPassword("not-a-real-password")
+	})
+	return dummyHash
+}
+
 func CreateLoginHandler(
 	router *gin.Engine,
 	provider services.ServiceProviderType,
 ) {
-	router.POST("/login", loginHandler(provider))
-	router.GET("/login", loginHandler(provider))
+	csrf := csrfProtect(provider)
+
+	router.POST("/login", csrf, loginHandler(provider))
+	router.GET("/login", csrf, loginHandler(provider))
 }
 
 func loginHandler(
@@ -44,19 +65,19 @@ func loginHandler(
 			email := request.Form.Get("email")
 			password := request.Form.Get("password")
 
-			user, _ := userRepo.GetUser(email)
+			user, status, message := authenticateUser(context, provider, email, password)
 
 			if user == nil {
-				context.HTML(http.StatusBadRequest, "login.tmpl", gin.H{
-					"error":    "User not found",
+				renderHTML(context, status, "login.tmpl", gin.H{
+					"error":    message,
 					"email":    email,
-					"password": password,
+					"password": nil,
 				})
 				return
 			}
 
 			if !user.Validated {
-				context.HTML(http.StatusBadRequest, "login.tmpl", gin.H{
+				renderHTML(context, http.StatusBadRequest, "login.tmpl", gin.H{
 					"error":    "Email verification required. Please check your email for a verification link.",
 					"email":    email,
 					"password": password,
@@ -64,15 +85,16 @@ func loginHandler(
 				return
 			}
 
-			if !users.CheckPasswordHash(password, user.Password) {
-				context.HTML(http.StatusBadRequest, "login.tmpl", gin.H{
-					"error":    "Incorrect password",
-					"email":    email,
-					"password": password,
-				})
+			if user.TOTPEnabled {
+				startTwoFactorLogin(provider, store, email)
+
+				context.Redirect(http.StatusFound, "/login/2fa")
 				return
 			}
 
+			userRepo.ClearFailedLogins(email)
+			recordAudit(context, provider, services.AuditLoginSucceeded, email, returnClientID(store), "password")
+
 			store.Set("LoggedInUserID", email)
 			store.Save()
 
@@ -80,10 +102,82 @@ func loginHandler(
 			return
 		}
 
-		context.HTML(http.StatusOK, "login.tmpl", gin.H{
+		renderHTML(context, http.StatusOK, "login.tmpl", gin.H{
 			"error":    nil,
 			"email":    nil,
 			"password": nil,
 		})
 	}
 }
+
+// authenticateUser checks the lockout state and the password. On failure it
+// returns the status and message to render. Failed attempts are only cleared
+// once the whole login, including any second step, succeeds.
+func authenticateUser(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+	email string,
+	password string,
+) (*models.User, int, string) {
+	userRepo := provider.GetUserRepo()
+
+	if !userRepo.LoginLockedUntil(email, context.ClientIP()).IsZero() {
+		return nil, http.StatusTooManyRequests, lockedOutMessage
+	}
+
+	user, _ := userRepo.GetUser(email)
+
+	// compare against a dummy hash for unknown emails so both cases take
+	// the same time
+	hash := dummyPasswordHash()
+	if user != nil {
+		hash = user.Password
+	}
+
+	if !users.CheckPasswordHash(password, hash) || user == nil {
+		recordFailedLogin(context, provider, email, user, "password")
+		return nil, http.StatusBadRequest, invalidCredentialsMessage
+	}
+
+	if users.NeedsRehash(user.Password) {
+		if upgraded, err := users.HashPassword(password); err == nil {
+			userRepo.UpdatePasswordHash(user, upgraded)
+		}
+	}
+
+	// only reported once the password is right, so they don't reveal
+	// anything about the account
+	if user.Disabled {
+		recordAudit(context, provider, services.AuditLoginFailed, email, "", "account_disabled")
+		return nil, http.StatusForbidden, disabledMessage
+	}
+
+	if user.ResetRequired {
+		recordAudit(context, provider, services.AuditLoginFailed, email, "", "password_reset_required")
+		return nil, http.StatusForbidden, resetRequiredMessage
+	}
+
+	return user, http.StatusOK, ""
+}
+
+// recordFailedLogin counts a failed attempt towards the lockout. method is
+// the step that failed, e.g. password or two_factor.
+func recordFailedLogin(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+	email string,
+	user *models.User,
+	method string,
+) {
+	userRepo := provider.GetUserRepo()
+
+	recordAudit(context, provider, services.AuditLoginFailed, email, "", method)
+
+	if userRepo.RecordFailedLogin(email, context.ClientIP()) {
+		recordAudit(context, provider, services.AuditAccountLocked, email, "", "")
+
+		if user != nil {
+			provider.GetEmailService().SendLockoutNotice(user.Email, user.Locale)
+		}
+	}
+}
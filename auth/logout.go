@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateLogoutHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.GET("/logout", csrf, logoutHandler(provider))
+	router.POST("/logout", csrf, logoutHandler(provider))
+}
+
+// logoutHandler implements OIDC RP-initiated logout. The client is taken from
+// client_id or the audience of id_token_hint, and post_logout_redirect_uri
+// has to be one the client registered. Without a valid id_token_hint any site
+// could link here, so the user confirms with a CSRF protected POST.
+func logoutHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+		clientStore := provider.GetClientStore()
+		families := provider.GetTokenFamilyRepo()
+		userRepo := provider.GetUserRepo()
+
+		clientID := context.Request.FormValue("client_id")
+		redirectURI := context.Request.FormValue("post_logout_redirect_uri")
+		userID := ""
+
+		if hint := context.Request.FormValue("id_token_hint"); hint != "" {
+			claims, err := services.ParseIDTokenHint(provider.GetKeySet(), services.GetHost(), hint)
+
+			if err != nil {
+				logoutError(context, "id_token_hint is invalid")
+				return
+			}
+
+			audience, _ := claims["aud"].(string)
+
+			if clientID != "" && clientID != audience {
+				logoutError(context, "client_id does not match id_token_hint")
+				return
+			}
+
+			clientID = audience
+
+			if user, userErr := userRepo.GetUserBySubject(claims["sub"].(string), audience); userErr == nil {
+				userID = user.Email
+			}
+		}
+
+		if redirectURI != "" {
+			client, err := clientStore.GetClient(clientID)
+
+			if err != nil || !client.AllowsPostLogoutRedirectURI(redirectURI) {
+				logoutError(context, "post_logout_redirect_uri is not registered for the client")
+				return
+			}
+		}
+
+		if userID == "" && context.Request.Method != http.MethodPost {
+			renderHTML(context, http.StatusOK, "logout.tmpl", gin.H{
+				"clientID":    clientID,
+				"redirectURI": redirectURI,
+				"state":       context.Request.FormValue("state"),
+			})
+			return
+		}
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if userID == "" {
+			if uid, ok := store.Get(services.AuthenticatedUserKey); ok {
+				userID = uid.(string)
+			}
+		}
+
+		if userID != "" && clientID != "" {
+			families.RevokeClient(userID, clientID, services.UserLoggedOut, context.ClientIP())
+		}
+
+		store.Flush()
+
+		if redirectURI == "" {
+			context.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		location, _ := url.Parse(redirectURI)
+
+		if state := context.Request.FormValue("state"); state != "" {
+			query := location.Query()
+			query.Set("state", state)
+			location.RawQuery = query.Encode()
+		}
+
+		context.Redirect(http.StatusFound, location.String())
+	}
+}
+
+func logoutError(context *gin.Context, description string) {
+	context.JSON(http.StatusBadRequest, gin.H{
+		"error":             "invalid_request",
+		"error_description": description,
+	})
+}
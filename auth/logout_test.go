@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var testPostLogoutURI = "http://localhost:3000"
+
+func SetupLogoutRouter(db *gorm.DB) (*gin.Engine, services.KeySetType) {
+	clock := &mocks.MockClock{Time: now}
+
+	srv, _ := SetupOauthServerWithSession(db, &services.SessionApi{})
+
+	clientStore := services.CreateClientStore(db, clock, CreateFamilyRepo(db))
+	clientStore.EnsureClient(&services.Client{
+		ID:           testClientID,
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       services.SupportedScopes,
+
+		PostLogoutRedirectURIs: []string{testPostLogoutURI},
+	}, testClientSecret)
+
+	signingKey, _ := services.GenerateSigningKey()
+	keySet := services.KeySetType(services.CreateKeySet(signingKey, nil, clock))
+
+	return SetupRouter(db, srv, clock, keySet), keySet
+}
+
+func IDTokenHint(db *gorm.DB, keySet services.KeySetType, clientID string) string {
+	hint, _ := services.SignClaims(keySet, jwt.MapClaims{
+		"iss": services.GetHost(),
+		"sub": UserSubject(db, clientID),
+		"aud": clientID,
+		"exp": now.Add(-time.Hour).Unix(),
+	})
+
+	return hint
+}
+
+func LogoutPath(query url.Values) string {
+	return "/logout?" + query.Encode()
+}
+
+func TestLogoutRevokesClientTokens(t *testing.T) {
+	db := Setup()
+
+	router, keySet := SetupLogoutRouter(db)
+
+	defer Teardown(db)
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	w := GetWithCookies(router, LogoutPath(url.Values{
+		"id_token_hint":            {IDTokenHint(db, keySet, testClientID)},
+		"post_logout_redirect_uri": {testPostLogoutURI},
+		"state":                    {"state-123"},
+	}), nil)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, testPostLogoutURI+"?state=state-123", w.Header().Get("Location"))
+
+	refreshed, _ := RefreshTokens(router, tokens["refresh_token"].(string))
+
+	assert.NotEqual(t, http.StatusOK, refreshed.Code)
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.True(t, family.Revoked)
+	assert.Equal(t, services.UserLoggedOut, family.RevokedReason)
+}
+
+func TestLogoutUsesSessionUser(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupLogoutRouter(db)
+
+	defer Teardown(db)
+
+	consentRepo := services.CreateConsentRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, testClientID, "openid")
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	cookies, authorized := LoginAndAuthorize(router, "openid")
+
+	assert.Contains(t, authorized.Header().Get("Location"), testRedirectURI)
+
+	confirm := GetWithCookies(router, LogoutPath(url.Values{"client_id": {testClientID}}), cookies)
+
+	assert.Equal(t, http.StatusOK, confirm.Code)
+	assert.Contains(t, confirm.Body.String(), `name="csrf_token"`)
+	assert.Contains(t, confirm.Body.String(), `name="client_id" value="`+testClientID+`"`)
+
+	w := PostForm(router, "/logout", url.Values{"client_id": {testClientID}}, cookies)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	refreshed, _ := RefreshTokens(router, tokens["refresh_token"].(string))
+
+	assert.NotEqual(t, http.StatusOK, refreshed.Code)
+}
+
+func TestLogoutRejectsUnregisteredRedirect(t *testing.T) {
+	db := Setup()
+
+	router, keySet := SetupLogoutRouter(db)
+
+	defer Teardown(db)
+
+	w := GetWithCookies(router, LogoutPath(url.Values{
+		"id_token_hint":            {IDTokenHint(db, keySet, testClientID)},
+		"post_logout_redirect_uri": {"http://localhost:3000/evil"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = GetWithCookies(router, LogoutPath(url.Values{
+		"post_logout_redirect_uri": {testPostLogoutURI},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = GetWithCookies(router, LogoutPath(url.Values{
+		"client_id":                {"other-client"},
+		"post_logout_redirect_uri": {testPostLogoutURI},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLogoutRejectsInvalidHint(t *testing.T) {
+	db := Setup()
+
+	router, keySet := SetupLogoutRouter(db)
+
+	defer Teardown(db)
+
+	signingKey, _ := services.GenerateSigningKey()
+	otherKeys := services.CreateKeySet(signingKey, nil, &mocks.MockClock{Time: now})
+
+	w := GetWithCookies(router, LogoutPath(url.Values{
+		"id_token_hint": {IDTokenHint(db, otherKeys, testClientID)},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = GetWithCookies(router, LogoutPath(url.Values{
+		"id_token_hint": {IDTokenHint(db, keySet, testClientID)},
+		"client_id":     {"other-client"},
+	}), nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLogoutWithoutHintNeedsConfirmation(t *testing.T) {
+	db := Setup()
+
+	router, _ := SetupLogoutRouter(db)
+
+	defer Teardown(db)
+
+	consentRepo := services.CreateConsentRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, testClientID, "openid")
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	cookies, _ := LoginAndAuthorize(router, "openid")
+
+	w := GetWithCookies(router, LogoutPath(url.Values{"client_id": {testClientID}}), cookies)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Do you want to sign out")
+
+	// a cross-site form post has no CSRF token
+	forged := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/logout", strings.NewReader(url.Values{"client_id": {testClientID}}.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	router.ServeHTTP(forged, req)
+
+	assert.Equal(t, http.StatusForbidden, forged.Code)
+
+	refreshed, _ := RefreshTokens(router, tokens["refresh_token"].(string))
+
+	assert.Equal(t, http.StatusOK, refreshed.Code)
+}
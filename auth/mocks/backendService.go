@@ -0,0 +1,27 @@
+package mocks
+
+type MockBackendService struct {
+	DeletedEmail string
+	OldEmail     string
+	NewEmail     string
+	Err          error
+}
+
+func (backendService *MockBackendService) DeleteAccount(email string) error {
+	if backendService.Err != nil {
+		return backendService.Err
+	}
+
+	backendService.DeletedEmail = email
+	return nil
+}
+
+func (backendService *MockBackendService) ChangeEmail(email string, newEmail string) error {
+	if backendService.Err != nil {
+		return backendService.Err
+	}
+
+	backendService.OldEmail = email
+	backendService.NewEmail = newEmail
+	return nil
+}
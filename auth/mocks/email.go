@@ -8,6 +8,12 @@ type MockEmailService struct {
 	Args              services.EmailArgs
 	VerificationEmail string
 	ValidationCode    string
+	ResetEmail        string
+	ResetCode         string
+	LockoutEmail      string
+	LoginEmail        string
+	LoginCode         string
+	Locale            string
 }
 
 func (emailService *MockEmailService) SendEmail(args services.EmailArgs) error {
@@ -15,8 +21,29 @@ func (emailService *MockEmailService) SendEmail(args services.EmailArgs) error {
 	return nil
 }
 
-func (emailService *MockEmailService) SendVerificationLink(email string, code string) error {
+func (emailService *MockEmailService) SendVerificationLink(email string, code string, locale string) error {
+	emailService.Locale = locale
 	emailService.VerificationEmail = email
 	emailService.ValidationCode = code
 	return nil
 }
+
+func (emailService *MockEmailService) SendPasswordResetLink(email string, code string, locale string) error {
+	emailService.Locale = locale
+	emailService.ResetEmail = email
+	emailService.ResetCode = code
+	return nil
+}
+
+func (emailService *MockEmailService) SendLockoutNotice(email string, locale string) error {
+	emailService.Locale = locale
+	emailService.LockoutEmail = email
+	return nil
+}
+
+func (emailService *MockEmailService) SendLoginLink(email string, code string, locale string) error {
+	emailService.Locale = locale
+	emailService.LoginEmail = email
+	emailService.LoginCode = code
+	return nil
+}
@@ -1,12 +1,15 @@
 package mocks
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/go-oauth2/oauth2/v4"
 )
 
-type MockOauthServer struct{}
+type MockOauthServer struct {
+	TokenInfo oauth2.TokenInfo
+}
 
 func (srv *MockOauthServer) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request) error {
 	return nil
@@ -14,6 +17,15 @@ func (srv *MockOauthServer) HandleAuthorizeRequest(w http.ResponseWriter, r *htt
 func (srv *MockOauthServer) HandleTokenRequest(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
+func (srv *MockOauthServer) HandleRevocationRequest(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+func (srv *MockOauthServer) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
 func (srv *MockOauthServer) ValidationBearerToken(r *http.Request) (oauth2.TokenInfo, error) {
-	return nil, nil
+	if srv.TokenInfo == nil {
+		return nil, errors.New("invalid access token")
+	}
+	return srv.TokenInfo, nil
 }
@@ -3,16 +3,18 @@ package mocks
 import (
 	"context"
 	"net/http"
+	"server/services"
 
 	"github.com/go-session/session"
 )
 
 type MockSession struct {
+	services.SessionApi
 	storeFn func(session.Store)
 }
 
 func CreateSession(storeFn func(session.Store)) MockSession {
-	return MockSession{storeFn}
+	return MockSession{storeFn: storeFn}
 }
 
 func (mockSession *MockSession) Start(
@@ -0,0 +1,61 @@
+package mocks
+
+import (
+	"net/url"
+	"server/services"
+)
+
+type MockUpstreamService struct {
+	Providers   []services.UpstreamProvider
+	Identity    *services.UpstreamIdentity
+	Err         error
+	RedirectURI string
+	Code        string
+	Verifier    string
+	Nonce       string
+}
+
+func (upstream *MockUpstreamService) GetProviders() []services.UpstreamProvider {
+	return upstream.Providers
+}
+
+func (upstream *MockUpstreamService) GetProvider(id string) (services.UpstreamProvider, bool) {
+	for _, provider := range upstream.Providers {
+		if provider.ID == id {
+			return provider, true
+		}
+	}
+	return services.UpstreamProvider{}, false
+}
+
+func (upstream *MockUpstreamService) AuthCodeURL(
+	provider services.UpstreamProvider,
+	redirectURI string,
+	state string,
+	nonce string,
+	verifier string,
+) (string, error) {
+	query := url.Values{
+		"client_id":    {provider.ClientID},
+		"redirect_uri": {redirectURI},
+		"state":        {state},
+		"nonce":        {nonce},
+	}
+
+	return provider.Issuer + "/authorize?" + query.Encode(), nil
+}
+
+func (upstream *MockUpstreamService) Exchange(
+	provider services.UpstreamProvider,
+	redirectURI string,
+	code string,
+	verifier string,
+	nonce string,
+) (*services.UpstreamIdentity, error) {
+	upstream.RedirectURI = redirectURI
+	upstream.Code = code
+	upstream.Verifier = verifier
+	upstream.Nonce = nonce
+
+	return upstream.Identity, upstream.Err
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AuditEvent is an entry in the security audit log. Entries are only ever
+// added, and removed once they're older than the retention period.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Type      string    `gorm:"not null; index" json:"type"`
+	UserID    string    `gorm:"index" json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
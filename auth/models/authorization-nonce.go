@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+type AuthorizationNonce struct {
+	Code      string `gorm:"primaryKey"`
+	Nonce     string `gorm:"not null"`
+	ExpiresAt time.Time
+}
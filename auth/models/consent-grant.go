@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// ConsentGrant records the scopes a user has allowed a client to access.
+type ConsentGrant struct {
+	UserID    string `gorm:"primaryKey"`
+	ClientID  string `gorm:"primaryKey"`
+	Scope     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
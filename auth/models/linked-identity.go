@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+// LinkedIdentity ties an account at an upstream identity provider to a user.
+type LinkedIdentity struct {
+	gorm.Model
+	Provider string `gorm:"not null; uniqueIndex:idx_linked_identity_subject"`
+	Subject  string `gorm:"not null; uniqueIndex:idx_linked_identity_subject"`
+	UserID   uint   `gorm:"not null; index"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt counts consecutive failed logins for an account or an IP
+// address. Key is prefixed with "account:" or "ip:".
+type LoginAttempt struct {
+	Key         string `gorm:"primaryKey"`
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+// OauthClient is a row of the oauth2_clients table created by the
+// go-oauth2-pg client store. Secret holds a hash of the client secret and
+// Data the JSON encoded client settings.
+type OauthClient struct {
+	ID     string `gorm:"primaryKey"`
+	Secret string `gorm:"not null"`
+	Domain string `gorm:"not null"`
+	Data   []byte `gorm:"type:jsonb; not null"`
+}
+
+func (OauthClient) TableName() string {
+	return "oauth2_clients"
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PairwiseSubject is the sub claim a third-party client sees for a user, so
+// that two clients can't correlate their users.
+type PairwiseSubject struct {
+	Subject   string `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null; uniqueIndex:idx_pairwise_subject_client"`
+	ClientID  string `gorm:"not null; uniqueIndex:idx_pairwise_subject_client"`
+	CreatedAt time.Time
+}
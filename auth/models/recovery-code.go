@@ -0,0 +1,10 @@
+package models
+
+import "gorm.io/gorm"
+
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null; index"`
+	CodeHash string `gorm:"not null"`
+	Used     bool   `gorm:"default:false"`
+}
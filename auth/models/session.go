@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type Session struct {
+	ID        string `gorm:"primaryKey"`
+	Data      []byte
+	UserID    string    `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
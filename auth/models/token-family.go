@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TokenFamily groups every refresh token issued from one authorization code
+// grant, so the whole chain can be revoked when a used token is replayed.
+type TokenFamily struct {
+	ID            string `gorm:"primaryKey"`
+	UserID        string `gorm:"not null; index"`
+	ClientID      string `gorm:"not null"`
+	CreatedAt     time.Time
+	Revoked       bool `gorm:"default:false"`
+	RevokedAt     time.Time
+	RevokedReason string
+}
+
+type RefreshToken struct {
+	TokenHash string `gorm:"primaryKey"`
+	FamilyID  string `gorm:"not null; index"`
+	Used      bool   `gorm:"default:false"`
+	ClaimID   string
+	CreatedAt time.Time
+}
+
+type TokenEvent struct {
+	gorm.Model
+	FamilyID  string `gorm:"index"`
+	Event     string `gorm:"not null"`
+	UserID    string
+	ClientID  string
+	IPAddress string
+}
@@ -8,10 +8,22 @@ import (
 
 type User struct {
 	gorm.Model
-	Email          string `gorm:"not null; uniqueIndex" json:"email" binding:"required"`
-	Password       string `gorm:"not null" json:"password" binding:"required"`
-	Name           string `gorm:"not null" json:"name" binding:"required"`
-	Validated      bool   `gorm:"default:false"`
-	ValidationCode string
-	CodeExpiration time.Time
+	Email           string `gorm:"not null; uniqueIndex" json:"email" binding:"required"`
+	Password        string `gorm:"not null" json:"password" binding:"required"`
+	Name            string `gorm:"not null" json:"name" binding:"required"`
+	Validated       bool   `gorm:"default:false"`
+	ValidationCode  string
+	PendingEmail    string
+	CodeExpiration  time.Time
+	ResetCode       string
+	ResetExpiration time.Time
+	LoginCode       string
+	LoginExpiration time.Time
+	TOTPSecret      string
+	TOTPEnabled     bool `gorm:"default:false"`
+	TOTPLastStep    int64
+	Admin           bool `gorm:"default:false"`
+	Disabled        bool `gorm:"default:false"`
+	ResetRequired   bool `gorm:"default:false"`
+	Locale          string
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"server/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateOIDCHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	router.GET("/.well-known/openid-configuration", discoveryHandler(provider))
+	router.GET("/userinfo", userInfoHandler(provider))
+	router.POST("/userinfo", userInfoHandler(provider))
+}
+
+func discoveryHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		host := services.GetHost()
+		signingKey := provider.GetKeySet().GetSigningKey()
+
+		config := gin.H{
+			"issuer":                                        host,
+			"authorization_endpoint":                        fmt.Sprintf("%s/oauth/authorize", host),
+			"token_endpoint":                                fmt.Sprintf("%s/oauth/token", host),
+			"userinfo_endpoint":                             fmt.Sprintf("%s/userinfo", host),
+			"jwks_uri":                                      fmt.Sprintf("%s/.well-known/jwks.json", host),
+			"revocation_endpoint":                           fmt.Sprintf("%s/oauth/revoke", host),
+			"introspection_endpoint":                        fmt.Sprintf("%s/oauth/introspect", host),
+			"end_session_endpoint":                          fmt.Sprintf("%s/logout", host),
+			"scopes_supported":                              services.SupportedScopes,
+			"response_types_supported":                      []string{"code"},
+			"grant_types_supported":                         []string{"authorization_code", "refresh_token"},
+			"subject_types_supported":                       []string{"pairwise"},
+			"id_token_signing_alg_values_supported":         []string{signingKey.Method.Alg()},
+			"token_endpoint_auth_methods_supported":         []string{"client_secret_post", "none"},
+			"revocation_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post"},
+			"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+			"code_challenge_methods_supported":              []string{"S256"},
+			"claims_supported":                              []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "email", "email_verified"},
+		}
+
+		if services.DynamicRegistrationEnabled() {
+			config["registration_endpoint"] = fmt.Sprintf("%s/oauth/register", host)
+		}
+
+		context.Header("Cache-Control", "public, max-age=300")
+		context.JSON(http.StatusOK, config)
+	}
+}
+
+func userInfoHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		srv := provider.GetOauthServer()
+		userRepo := provider.GetUserRepo()
+
+		token, err := srv.ValidationBearerToken(context.Request)
+		if err != nil {
+			bearerError(context, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+
+		if !services.HasScope(token.GetScope(), "openid") {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "the openid scope is required")
+			return
+		}
+
+		user, userErr := userRepo.GetUser(token.GetUserID())
+		if userErr != nil {
+			bearerError(context, http.StatusUnauthorized, "invalid_token", userErr.Error())
+			return
+		}
+
+		context.Header("Cache-Control", "no-store")
+		context.JSON(http.StatusOK, services.UserClaims(user, userRepo.Subject(user, token.GetClientID()), token.GetScope()))
+	}
+}
+
+func bearerError(context *gin.Context, status int, code string, description string) {
+	context.Header(
+		"WWW-Authenticate",
+		fmt.Sprintf(`Bearer error="%s", error_description="%s"`, code, description),
+	)
+	context.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthModels "github.com/go-oauth2/oauth2/v4/models"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func CreateTokenInfo(scope string) *oauthModels.Token {
+	tokenInfo := oauthModels.NewToken()
+	tokenInfo.SetClientID("222222")
+	tokenInfo.SetUserID(testUser)
+	tokenInfo.SetScope(scope)
+	tokenInfo.SetAccessCreateAt(now)
+	tokenInfo.SetAccessExpiresIn(time.Hour)
+	return tokenInfo
+}
+
+// UserSubject is the sub claim clientID gets for the test user.
+func UserSubject(db *gorm.DB, clientID string) string {
+	userRepo := services.CreateUserRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+
+	user, _ := userRepo.GetUser(testUser)
+
+	return userRepo.Subject(user, clientID)
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/.well-known/openid-configuration", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var config map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &config)
+
+	host := services.GetHost()
+
+	assert.Equal(t, host, config["issuer"])
+	assert.Equal(t, host+"/oauth/authorize", config["authorization_endpoint"])
+	assert.Equal(t, host+"/oauth/token", config["token_endpoint"])
+	assert.Equal(t, host+"/userinfo", config["userinfo_endpoint"])
+	assert.Equal(t, host+"/.well-known/jwks.json", config["jwks_uri"])
+	assert.Equal(t, host+"/logout", config["end_session_endpoint"])
+	assert.Equal(t, []interface{}{"openid", "profile", "email"}, config["scopes_supported"])
+	assert.Equal(t, []interface{}{"RS256"}, config["id_token_signing_alg_values_supported"])
+	assert.Equal(t, []interface{}{"pairwise"}, config["subject_types_supported"])
+}
+
+func TestSubjectIsPairwise(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	userRepo := services.CreateUserRepo(db, &mocks.MockClock{Time: now}, CreateFamilyRepo(db))
+
+	subject := UserSubject(db, testClientID)
+
+	assert.NotEqual(t, testUser, subject)
+	assert.Equal(t, subject, UserSubject(db, testClientID))
+	assert.NotEqual(t, subject, UserSubject(db, "other-client"))
+
+	user, err := userRepo.GetUserBySubject(subject, testClientID)
+
+	assert.Nil(t, err)
+	assert.Equal(t, testUser, user.Email)
+
+	_, err = userRepo.GetUserBySubject(subject, "other-client")
+
+	assert.Error(t, err)
+}
+
+func TestUserInfo(t *testing.T) {
+	db := Setup()
+
+	oauthServer := &mocks.MockOauthServer{TokenInfo: CreateTokenInfo("openid profile email")}
+
+	router := SetupRouter(db, oauthServer)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var claims map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &claims)
+
+	assert.Equal(t, map[string]interface{}{
+		"sub":            UserSubject(db, "222222"),
+		"name":           testName,
+		"email":          testUser,
+		"email_verified": true,
+	}, claims)
+}
+
+func TestUserInfoLimitedByScope(t *testing.T) {
+	db := Setup()
+
+	oauthServer := &mocks.MockOauthServer{TokenInfo: CreateTokenInfo("openid")}
+
+	router := SetupRouter(db, oauthServer)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/userinfo", nil)
+
+	router.ServeHTTP(w, req)
+
+	var claims map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &claims)
+
+	assert.Equal(t, map[string]interface{}{"sub": UserSubject(db, "222222")}, claims)
+}
+
+func TestUserInfoInvalidToken(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/userinfo", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+func TestUserInfoRequiresOpenIDScope(t *testing.T) {
+	db := Setup()
+
+	oauthServer := &mocks.MockOauthServer{TokenInfo: CreateTokenInfo("all")}
+
+	router := SetupRouter(db, oauthServer)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/userinfo", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+}
+
+func TestAuthorizeGenerateStoresNonce(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	mockClock := &mocks.MockClock{Time: now}
+	nonceRepo := services.CreateNonceRepo(db, mockClock)
+
+	generator := services.CreateOIDCAuthorizeGenerate(nonceRepo)
+
+	req, _ := http.NewRequest("GET", "/oauth/authorize", nil)
+	req.Form = url.Values{"nonce": {"nonce-123"}}
+
+	code, err := generator.Token(context.Background(), &oauth2.GenerateBasic{
+		Client:    &oauthModels.Client{ID: "222222"},
+		UserID:    testUser,
+		CreateAt:  now,
+		TokenInfo: CreateTokenInfo("openid"),
+		Request:   req,
+	})
+
+	assert.Nil(t, err)
+	assert.NotEmpty(t, code)
+
+	assert.Equal(t, "nonce-123", nonceRepo.TakeNonce(code))
+	assert.Equal(t, "", nonceRepo.TakeNonce(code))
+}
+
+func TestExpiredNonceIsIgnored(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	nonceRepo := services.CreateNonceRepo(db, &mocks.MockClock{Time: now})
+	nonceRepo.SaveNonce("code", "nonce-123")
+
+	laterRepo := services.CreateNonceRepo(db, &mocks.MockClock{Time: now.Add(time.Hour)})
+
+	assert.Equal(t, "", laterRepo.TakeNonce("code"))
+}
+
+func TestCreateIDToken(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	signing, _ := services.GenerateSigningKey()
+	keySet := services.CreateKeySet(signing, nil, mockClock)
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	idToken, err := services.CreateIDToken(
+		keySet,
+		"http://localhost:9096",
+		CreateTokenInfo("openid email"),
+		user,
+		"subject-123",
+		"nonce-123",
+		time.Now(),
+	)
+
+	assert.Nil(t, err)
+
+	token, parseErr := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		key, _ := keySet.GetPublicKey(token.Header["kid"].(string))
+		return key.Public, nil
+	})
+
+	assert.Nil(t, parseErr)
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	assert.Equal(t, "http://localhost:9096", claims["iss"])
+	assert.Equal(t, "222222", claims["aud"])
+	assert.Equal(t, "subject-123", claims["sub"])
+	assert.Equal(t, "nonce-123", claims["nonce"])
+	assert.Equal(t, testUser, claims["email"])
+	assert.Equal(t, true, claims["email_verified"])
+	assert.Nil(t, claims["name"])
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"server/models"
+	"server/users"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2Params = users.HashParams{
+	Algorithm:     users.Argon2id,
+	Argon2Time:    1,
+	Argon2Memory:  1024,
+	Argon2Threads: 1,
+}
+
+func TestArgon2idHash(t *testing.T) {
+	users.Configure(testArgon2Params)
+	defer users.Configure(TestHashParams)
+
+	hash, err := users.HashPassword(testPassword)
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.True(t, users.CheckPasswordHash(testPassword, hash))
+	assert.False(t, users.CheckPasswordHash("wrong-password", hash))
+	assert.False(t, users.NeedsRehash(hash))
+
+	stronger := testArgon2Params
+	stronger.Argon2Time = 2
+	users.Configure(stronger)
+
+	assert.True(t, users.NeedsRehash(hash))
+	assert.True(t, users.CheckPasswordHash(testPassword, hash))
+
+	assert.False(t, users.CheckPasswordHash(testPassword, "$argon2id$v=19$m=1024$bad"))
+}
+
+func TestLoginRehashesOutdatedPassword(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	outdated, _ := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost+1)
+	db.Exec("update users set password = ? where email = ?", string(outdated), testUser)
+
+	w := PostLogin(router, testUser, testPassword)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	cost, _ := bcrypt.Cost([]byte(user.Password))
+
+	assert.Equal(t, bcrypt.MinCost, cost)
+	assert.True(t, users.CheckPasswordHash(testPassword, user.Password))
+}
+
+func TestLoginUpgradesToArgon2id(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	users.Configure(testArgon2Params)
+	defer users.Configure(TestHashParams)
+
+	PostLogin(router, testUser, testPassword)
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"))
+
+	w := PostLogin(router, testUser, testPassword)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	// a failed login leaves the hash alone
+	users.Configure(TestHashParams)
+
+	w = PostLogin(router, testUser, "wrong-password")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"))
+}
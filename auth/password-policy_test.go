@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"server/mocks"
+	"server/services"
+	"server/users"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func BreachedList(t *testing.T, passwords ...string) *services.BreachedPasswords {
+	breached, err := services.ReadBreachedPasswords(strings.NewReader(strings.Join(passwords, "\n")))
+
+	assert.Nil(t, err)
+
+	return breached
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	sum := sha1.Sum([]byte("hunter22"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	breached := BreachedList(t, "password123", hash+":12345", "")
+
+	assert.Equal(t, 2, breached.Len())
+	assert.True(t, breached.Contains("password123"))
+	assert.True(t, breached.Contains("hunter22"))
+	assert.False(t, breached.Contains("correct horse battery staple"))
+
+	var empty *services.BreachedPasswords
+
+	assert.False(t, empty.Contains("password123"))
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := services.PasswordPolicy{
+		MinLength:  10,
+		MaxLength:  72,
+		MinClasses: 3,
+		Breached:   BreachedList(t, "Password123!"),
+	}
+
+	assert.EqualError(t, policy.Check("Short1!", "user@example.com", "Jane"), "Password must be a minimum of 10 characters")
+	assert.Error(t, policy.Check("alllowercaseletters", "user@example.com", "Jane"))
+	assert.Equal(t, services.ErrPasswordPersonal, policy.Check("Janet-Smith-99", "user@example.com", "Janet Smith"))
+	assert.Equal(t, services.ErrPasswordPersonal, policy.Check("My-Username-1", "username@example.com", "Jane"))
+	assert.Equal(t, services.ErrPasswordBreached, policy.Check("Password123!", "user@example.com", "Jane"))
+	assert.EqualError(t, policy.Check(strings.Repeat("Aa1!", 19), "user@example.com", "Jane"), "Password must be a maximum of 72 bytes. Accented letters and emoji count as more than one.")
+	assert.EqualError(t, policy.Check(strings.Repeat("é", 37)+"A1", "user@example.com", "Jane"), "Password must be a maximum of 72 bytes. Accented letters and emoji count as more than one.")
+	assert.Nil(t, policy.Check("Tidy-Otter-42", "user@example.com", "Jane"))
+}
+
+func TestPasswordMaxLengthFollowsHashAlgorithm(t *testing.T) {
+	t.Setenv("PASSWORD_MAX_LENGTH", "")
+
+	passphrase := strings.Repeat("correct horse battery staple ", 4)
+
+	bcryptPolicy := services.LoadPasswordPolicy(users.Bcrypt)
+
+	assert.Equal(t, 72, bcryptPolicy.MaxLength)
+	assert.Error(t, bcryptPolicy.Check(passphrase, "user@example.com", "Jane"))
+
+	argon2Policy := services.LoadPasswordPolicy(users.Argon2id)
+
+	assert.Equal(t, 0, argon2Policy.MaxLength)
+	assert.Nil(t, argon2Policy.Check(passphrase, "user@example.com", "Jane"))
+
+	t.Setenv("PASSWORD_MAX_LENGTH", "100")
+
+	assert.Equal(t, 100, services.LoadPasswordPolicy(users.Argon2id).MaxLength)
+	assert.Equal(t, 72, services.LoadPasswordPolicy(users.Bcrypt).MaxLength)
+}
+
+func TestSignupRejectsBreachedPassword(t *testing.T) {
+	db := Setup()
+
+	db.Exec("delete from users")
+
+	router := SetupRouter(db, services.PasswordPolicy{
+		MinLength: 8,
+		Breached:  BreachedList(t, "password123"),
+	})
+
+	defer Teardown(db)
+
+	w := PostForm(router, "/signup", url.Values{
+		"email":    {testUser},
+		"password": {"password123"},
+		"name":     {testName},
+	}, nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "appeared in a data breach")
+}
+
+func TestPasswordPolicyAppliesToResets(t *testing.T) {
+	db := Setup()
+
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupRouter(db, mockEmail, &mocks.MockClock{Time: now}, services.PasswordPolicy{MinLength: 8})
+
+	defer Teardown(db)
+
+	PostForm(router, "/forgot-password", url.Values{"email": {testUser}}, nil)
+
+	w := PostForm(router, "/reset-password", url.Values{
+		"email":    {testUser},
+		"code":     {mockEmail.ResetCode},
+		"password": {"my-test-user-password"},
+	}, nil)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), services.ErrPasswordPersonal.Error())
+}
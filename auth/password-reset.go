@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"server/services"
+	"server/users"
+
+	"github.com/gin-gonic/gin"
+)
+
+const resetSentMessage = "If an account exists for that email, a password reset link has been sent. Please check your email."
+
+func CreatePasswordResetHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.GET("/forgot-password", csrf, forgotPasswordGetHandler())
+	router.POST("/forgot-password", csrf, forgotPasswordPostHandler(provider))
+	router.GET("/reset-password", csrf, resetPasswordGetHandler(provider))
+	router.POST("/reset-password", csrf, resetPasswordPostHandler(provider))
+}
+
+func forgotPasswordGetHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		renderHTML(context, http.StatusOK, "forgot-password.tmpl", gin.H{
+			"error": nil,
+			"email": nil,
+		})
+	}
+}
+
+func forgotPasswordPostHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		codeGen := provider.GetCodeGenerator()
+		emailService := provider.GetEmailService()
+
+		email := context.Request.FormValue("email")
+
+		if email == "" {
+			renderHTML(context, http.StatusBadRequest, "forgot-password.tmpl", gin.H{
+				"error": "Invalid field: email",
+			})
+			return
+		}
+
+		user, _ := userRepo.GetUser(email)
+
+		// The response is the same whether or not the account exists so the
+		// form can't be used to discover registered emails.
+		if user != nil && user.Validated {
+			code := codeGen.GenCode()
+
+			userRepo.UpdateResetCode(user, code)
+
+			emailService.SendPasswordResetLink(email, code, user.Locale)
+		}
+
+		renderHTML(context, http.StatusAccepted, "forgot-password.tmpl", gin.H{
+			"message": resetSentMessage,
+			"email":   email,
+		})
+	}
+}
+
+func resetPasswordGetHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+
+		email := context.Request.URL.Query().Get("email")
+		code := context.Request.URL.Query().Get("code")
+
+		user, _ := userRepo.GetUser(email)
+
+		if user == nil || !userRepo.ValidResetCode(user, code) {
+			renderHTML(context, http.StatusBadRequest, "reset-password.tmpl", gin.H{
+				"error":   "This password reset link is invalid or has expired",
+				"expired": true,
+			})
+			return
+		}
+
+		renderHTML(context, http.StatusOK, "reset-password.tmpl", gin.H{
+			"email": email,
+			"code":  code,
+			"form":  true,
+		})
+	}
+}
+
+func resetPasswordPostHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		passwordPolicy := provider.GetPasswordPolicy()
+
+		request := context.Request
+
+		if err := request.ParseForm(); err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		email := request.Form.Get("email")
+		code := request.Form.Get("code")
+		password := request.Form.Get("password")
+
+		user, _ := userRepo.GetUser(email)
+
+		if user == nil || !userRepo.ValidResetCode(user, code) {
+			renderHTML(context, http.StatusBadRequest, "reset-password.tmpl", gin.H{
+				"error":   "This password reset link is invalid or has expired",
+				"expired": true,
+			})
+			return
+		}
+
+		if policyErr := passwordPolicy.Check(password, user.Email, user.Name); policyErr != nil {
+			renderHTML(context, http.StatusBadRequest, "reset-password.tmpl", gin.H{
+				"error": policyErr.Error(),
+				"email": email,
+				"code":  code,
+				"form":  true,
+			})
+			return
+		}
+
+		hash, pwdErr := users.HashPassword(password)
+
+		if pwdErr != nil {
+			renderHTML(context, http.StatusInternalServerError, "reset-password.tmpl", gin.H{
+				"error": "There was an error resetting your password",
+				"email": email,
+				"code":  code,
+				"form":  true,
+			})
+			return
+		}
+
+		userRepo.ResetPassword(user, hash, context.ClientIP())
+		recordAudit(context, provider, services.AuditPasswordReset, user.Email, "", "")
+
+		renderHTML(context, http.StatusOK, "reset-password.tmpl", gin.H{
+			"message": "Your password has been reset. You can now log in with your new password.",
+		})
+	}
+}
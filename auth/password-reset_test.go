@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"server/users"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var resetCode = "reset-123"
+
+func SetupResetCode(db *gorm.DB) *mocks.MockClock {
+	mockClock := &mocks.MockClock{Time: now}
+
+	expiry := mockClock.AddTime(now, 1, 0, 0)
+
+	db.Exec(
+		"update users set reset_code = ?, reset_expiration = ?",
+		services.HashToken(resetCode),
+		expiry,
+	)
+
+	return mockClock
+}
+
+func TestForgotPasswordSendsLink(t *testing.T) {
+	db := Setup()
+
+	mockClock := mocks.MockClock{Time: now}
+	mockEmail := mocks.MockEmailService{}
+	codeGen := mocks.MockCodeGenerator{Code: resetCode}
+
+	router := SetupRouter(db, &mockClock, &mockEmail, &codeGen)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Add("email", testUser)
+
+	req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), resetSentMessage)
+	assert.Equal(t, testUser, mockEmail.ResetEmail)
+	assert.Equal(t, resetCode, mockEmail.ResetCode)
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.Equal(t, services.HashToken(resetCode), user.ResetCode)
+	assert.True(t, user.ResetExpiration.Equal(mockClock.AddTime(now, 1, 0, 0)))
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	db := Setup()
+
+	mockEmail := mocks.MockEmailService{}
+
+	router := SetupRouter(db, &mockEmail)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Add("email", "other-user")
+
+	req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), resetSentMessage)
+	assert.Equal(t, "", mockEmail.ResetEmail)
+}
+
+func TestResetPasswordGet(t *testing.T) {
+	db := Setup()
+
+	mockClock := SetupResetCode(db)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	url := fmt.Sprintf("/reset-password?email=%s&code=%s", testUser, resetCode)
+
+	req, _ := http.NewRequest("GET", url, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Please enter a new password")
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	db := Setup()
+
+	SetupResetCode(db)
+
+	mockClock := &mocks.MockClock{Time: now.Add(time.Hour * 2)}
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	url := fmt.Sprintf("/reset-password?email=%s&code=%s", testUser, resetCode)
+
+	req, _ := http.NewRequest("GET", url, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), "This password reset link is invalid or has expired")
+	assert.Contains(t, w.Body.String(), "Request a new link")
+}
+
+func TestResetPasswordSuccess(t *testing.T) {
+	db := Setup()
+
+	mockClock := SetupResetCode(db)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Add("email", testUser)
+	form.Add("code", resetCode)
+	form.Add("password", "new-password")
+
+	req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), "Your password has been reset.")
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, users.CheckPasswordHash("new-password", user.Password))
+	assert.Equal(t, "", user.ResetCode)
+
+	reuse := httptest.NewRecorder()
+
+	form.Set("password", "another-password")
+
+	reuseReq, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(form.Encode()))
+	reuseReq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	SignRequest(router, reuse, reuseReq)
+	router.ServeHTTP(reuse, reuseReq)
+
+	assert.Contains(t, reuse.Body.String(), "This password reset link is invalid or has expired")
+}
+
+func TestResetPasswordRevokesRefreshTokens(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+	mockClock := SetupResetCode(db)
+
+	router := SetupRouter(db, srv, mockClock)
+
+	defer Teardown(db)
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	w := PostForm(router, "/reset-password", url.Values{
+		"email":    {testUser},
+		"code":     {resetCode},
+		"password": {"new-password"},
+	}, nil)
+
+	assert.Contains(t, w.Body.String(), "Your password has been reset.")
+
+	refreshed, _ := RefreshTokens(router, tokens["refresh_token"].(string))
+
+	assert.NotEqual(t, http.StatusOK, refreshed.Code)
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.True(t, family.Revoked)
+	assert.Equal(t, services.PasswordReset, family.RevokedReason)
+}
+
+func TestResetPasswordInvalidCode(t *testing.T) {
+	db := Setup()
+
+	mockClock := SetupResetCode(db)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Add("email", testUser)
+	form.Add("code", "other")
+	form.Add("password", "new-password")
+
+	req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), "This password reset link is invalid or has expired")
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, users.CheckPasswordHash(testPassword, user.Password))
+}
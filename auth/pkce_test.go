@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"server/mocks"
+	"server/services"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var publicClientID = "public-client"
+var testCodeVerifier = "dBjftJeZ4CVP-mJ92K9x1pvu1bJ7q-rtT7xP0Wjcd8a"
+
+func SetupPublicClient(db *gorm.DB) {
+	mockClock := &mocks.MockClock{Time: now}
+
+	clientStore := services.CreateClientStore(db, mockClock, CreateFamilyRepo(db))
+	clientStore.EnsureClient(&services.Client{
+		ID:           publicClientID,
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       services.SupportedScopes,
+		Public:       true,
+	}, "")
+
+	consentRepo := services.CreateConsentRepo(db, mockClock, CreateFamilyRepo(db))
+	consentRepo.GrantConsent(testUser, publicClientID, "openid")
+}
+
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func AuthorizePublicClient(router *gin.Engine, challenge string, method string) (int, *url.URL) {
+	login := PostLogin(router, testUser, testPassword)
+
+	query := url.Values{
+		"client_id":     {publicClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {testRedirectURI},
+		"scope":         {"openid"},
+		"state":         {"state-123"},
+	}
+
+	if challenge != "" {
+		query.Set("code_challenge", challenge)
+	}
+	if method != "" {
+		query.Set("code_challenge_method", method)
+	}
+
+	w := GetWithCookies(router, "/oauth/authorize?"+query.Encode(), login.Result().Cookies())
+
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	return w.Code, location
+}
+
+func ExchangePublicCode(router *gin.Engine, code string, verifier string) (int, map[string]interface{}) {
+	w := PostForm(router, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"client_id":     {publicClientID},
+		"code_verifier": {verifier},
+	}, nil)
+
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+
+	return w.Code, data
+}
+
+func TestPublicClientRequiresPKCE(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	SetupPublicClient(db)
+
+	status, _ := AuthorizePublicClient(router, "", "")
+
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestPlainPKCEIsRejected(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	SetupPublicClient(db)
+
+	status, _ := AuthorizePublicClient(router, testCodeVerifier, "plain")
+
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = AuthorizePublicClient(router, testCodeVerifier, "")
+
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestPublicClientExchangesCodeWithVerifier(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	SetupPublicClient(db)
+
+	status, location := AuthorizePublicClient(router, S256Challenge(testCodeVerifier), "S256")
+
+	assert.Equal(t, http.StatusFound, status)
+
+	code := location.Query().Get("code")
+
+	assert.NotEmpty(t, code)
+
+	status, data := ExchangePublicCode(router, code, testCodeVerifier)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, data["access_token"])
+}
+
+func TestPublicClientRejectsWrongVerifier(t *testing.T) {
+	db := Setup()
+
+	router := SetupConsentRouter(db)
+
+	defer Teardown(db)
+
+	SetupPublicClient(db)
+
+	_, location := AuthorizePublicClient(router, S256Challenge(testCodeVerifier), "S256")
+
+	_, data := ExchangePublicCode(router, location.Query().Get("code"), "wrong-verifier-wrong-verifier-wrong-verifier")
+
+	assert.Equal(t, "invalid_grant", data["error"])
+}
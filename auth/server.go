@@ -7,11 +7,14 @@ import (
 	"html/template"
 	"io/fs"
 	"log"
+	"mailer"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
 	"server/database"
 	"server/services"
+	"server/users"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -34,7 +37,16 @@ func setupRouter(
 
 	router := gin.Default()
 
-	templ := template.Must(template.New("").ParseFS(
+	// context.ClientIP only reads X-Forwarded-For from these, otherwise
+	// clients could pick the IP the login lockout counts against
+	if err := router.SetTrustedProxies(services.TrustedProxies()); err != nil {
+		log.Println("invalid TRUSTED_PROXIES, ignoring forwarded headers:", err)
+		router.SetTrustedProxies(nil)
+	}
+
+	templ := template.Must(template.New("").Funcs(template.FuncMap{
+		"upstreamProviders": serviceProvider.GetUpstreamService().GetProviders,
+	}).ParseFS(
 		templateFiles, "templates/*.tmpl",
 	))
 
@@ -45,7 +57,7 @@ func setupRouter(
 	router.StaticFile("/hpt-logo.svg", "./static/hpt-logo.svg")
 
 	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowAllOrigins = true
+	corsConfig.AllowOriginFunc = services.AllowOrigins(services.CORSOrigins())
 	corsConfig.AllowHeaders = []string{"Authorization"}
 
 	router.Use(cors.New(corsConfig))
@@ -57,6 +69,18 @@ func setupRouter(
 	CreateLoginHandler(router, serviceProvider)
 	CreateSignupHandler(router, serviceProvider)
 	CreateValidateEmailHandler(router, serviceProvider)
+	CreatePasswordResetHandler(router, serviceProvider)
+	CreateTwoFactorHandler(router, serviceProvider)
+	CreateLoginLinkHandler(router, serviceProvider)
+	CreateFederatedLoginHandler(router, serviceProvider)
+	CreateJWKSHandler(router, serviceProvider)
+	CreateOIDCHandler(router, serviceProvider)
+	CreateConsentHandler(router, serviceProvider)
+	CreateClientHandler(router, serviceProvider)
+	CreateUserAdminHandler(router, serviceProvider)
+	CreateAuditHandler(router, serviceProvider)
+	CreateLogoutHandler(router, serviceProvider)
+	CreateAccountHandler(router, serviceProvider)
 
 	router.GET("/auth", authHandler(session))
 
@@ -110,6 +134,14 @@ func setupRouter(
 		}
 	})
 
+	router.POST("/oauth/revoke", func(context *gin.Context) {
+		srv.HandleRevocationRequest(context.Writer, context.Request)
+	})
+
+	router.POST("/oauth/introspect", func(context *gin.Context) {
+		srv.HandleIntrospectionRequest(context.Writer, context.Request)
+	})
+
 	router.GET("/validate", func(context *gin.Context) {
 		token, err := srv.ValidationBearerToken(context.Request)
 
@@ -118,6 +150,12 @@ func setupRouter(
 			return
 		}
 
+		// third-party clients get scoped claims from /userinfo instead
+		if token.GetClientID() != services.FirstPartyClientID() {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "use /userinfo to read the user's claims")
+			return
+		}
+
 		email := token.GetUserID()
 		user, userErr := userRepo.GetUser(email)
 
@@ -139,6 +177,12 @@ func setupRouter(
 			return
 		}
 
+		// third-party clients get scoped claims from /userinfo instead
+		if token.GetClientID() != services.FirstPartyClientID() {
+			bearerError(context, http.StatusForbidden, "insufficient_scope", "use /userinfo to read the user's claims")
+			return
+		}
+
 		email := token.GetUserID()
 		user, userErr := userRepo.GetUser(email)
 
@@ -150,6 +194,7 @@ func setupRouter(
 		context.JSON(http.StatusOK, gin.H{
 			"expires_in": int64(time.Until(token.GetAccessCreateAt().Add(token.GetAccessExpiresIn())).Seconds()),
 			"client_id":  token.GetClientID(),
+			"scope":      token.GetScope(),
 			"id":         email,
 			"name":       user.Name,
 			"email":      email,
@@ -162,20 +207,76 @@ func setupRouter(
 func main() {
 	database.ConnectDb()
 
+	hashParams := users.LoadHashParams()
+	users.Configure(hashParams)
+
 	rand.Seed(time.Now().UnixNano())
 
-	oauthServer := services.CreateOauthServer(&services.SessionApi{}, database.DSN)
+	clock := &services.Clock{}
+
+	keySet, keysErr := services.LoadKeySet(clock)
+
+	if keysErr != nil {
+		log.Fatal("Failed to load signing keys. \n", keysErr)
+	}
+
+	sessionConfig, sessionErr := services.LoadSessionConfig()
+
+	if sessionErr != nil {
+		log.Fatal("Failed to load session config. \n", sessionErr)
+	}
+
+	sessionStore := services.CreateSessionStore(database.DB.Db, clock, time.Minute)
+	defer sessionStore.Close()
+
+	sessionApi := services.CreateSessionApi(sessionStore, sessionConfig)
+
+	oauthServer := services.CreateOauthServer(
+		sessionApi,
+		database.DSN,
+		database.DB.Db,
+		clock,
+		keySet,
+	)
+
+	mailConfig, mailErr := mailer.LoadConfig()
+
+	if mailErr != nil {
+		log.Fatal("Failed to load mail config. \n", mailErr)
+	}
+
+	mailTransport := mailer.NewTransport(mailConfig)
 
 	serviceProvider := services.CreateServiceProvider(
-		&services.SessionApi{},
+		sessionApi,
 		database.DB.Db,
 		oauthServer,
-		&services.EmailService{},
+		services.CreateEmailService(mailTransport, mailConfig.From),
+		&services.BackendService{},
+		services.CreateUpstreamService(services.LoadUpstreamProviders(), clock),
 		&services.CodeGenerator{},
-		&services.Clock{},
+		clock,
+		keySet,
+		services.LoadPasswordPolicy(hashParams.Algorithm),
 	)
 
+	userRepo := serviceProvider.GetUserRepo()
+	userRepo.PromoteAdmins(services.AdminEmails())
+
+	auditRepo := serviceProvider.GetAuditRepo()
+	auditDone := make(chan struct{})
+	defer close(auditDone)
+
+	go auditRepo.PruneEvery(time.Hour, services.LoadAuditRetention(), auditDone)
+
 	router := setupRouter(&serviceProvider)
+
+	// only in development, when mail isn't being delivered, see
+	// mailer.MemoryTransport. Anyone could read sign in links from it.
+	if sink, ok := mailTransport.(*mailer.MemoryTransport); ok && os.Getenv("ENVIRONMENT") == "DEV" {
+		log.Println("Mail is kept in memory and served at /dev/mail")
+		router.Any("/dev/mail", gin.WrapH(sink))
+	}
 	router.Run(fmt.Sprintf(":%d", 9096))
 	log.Printf("Server is running at %d port.\n", 9096)
 }
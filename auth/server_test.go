@@ -17,6 +17,7 @@ import (
 	"github.com/go-session/session"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -27,12 +28,34 @@ var testPassword = "test-password"
 var validationCode = "abc123"
 var now = time.Date(2023, 4, 1, 10, 0, 0, 0, time.Local)
 
+var TestHashParams = users.HashParams{
+	Algorithm:  users.Bcrypt,
+	BcryptCost: bcrypt.MinCost,
+}
+
 func Setup() *gorm.DB {
 	godotenv.Load(".env")
 
+	// the lowest cost keeps the suite fast
+	users.Configure(TestHashParams)
+
 	db, _ := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 
-	db.AutoMigrate(&models.User{})
+	db.AutoMigrate(
+		&models.User{},
+		&models.AuthorizationNonce{},
+		&models.TokenFamily{},
+		&models.RefreshToken{},
+		&models.TokenEvent{},
+		&models.LoginAttempt{},
+		&models.RecoveryCode{},
+		&models.Session{},
+		&models.ConsentGrant{},
+		&models.OauthClient{},
+		&models.LinkedIdentity{},
+		&models.AuditEvent{},
+		&models.PairwiseSubject{},
+	)
 
 	password, _ := users.HashPassword(testPassword)
 
@@ -47,9 +70,29 @@ func Setup() *gorm.DB {
 	return db
 }
 
+// CreateFamilyRepo builds the token family repo the way the service provider
+// does, for tests that set up repos of their own.
+func CreateFamilyRepo(db *gorm.DB) services.TokenFamilyRepository {
+	clock := &mocks.MockClock{Time: now}
+
+	return services.CreateTokenFamilyRepo(db, clock, services.CreateAuditRepo(db, clock))
+}
+
 func Teardown(db *gorm.DB) {
 	sql := `
 		delete from users;
+		delete from authorization_nonces;
+		delete from token_families;
+		delete from refresh_tokens;
+		delete from token_events;
+		delete from login_attempts;
+		delete from recovery_codes;
+		delete from sessions;
+		delete from consent_grants;
+		delete from oauth2_clients;
+		delete from linked_identities;
+		delete from audit_events;
+		delete from pairwise_subjects;
 	`
 	db.Exec(sql)
 }
@@ -61,8 +104,15 @@ func SetupRouter(
 
 	mockSession := (services.SessionApiType)(nil)
 	email := &mocks.MockEmailService{}
+	backend := &mocks.MockBackendService{}
+	upstream := &mocks.MockUpstreamService{}
 	codeGen := &mocks.MockCodeGenerator{Code: "default"}
 	clock := &mocks.MockClock{}
+	oauthServer := services.OauthServerType(&mocks.MockOauthServer{})
+	passwordPolicy := services.PasswordPolicy{MinLength: 8}
+
+	signingKey, _ := services.GenerateSigningKey()
+	keySet := services.KeySetType(services.CreateKeySet(signingKey, nil, clock))
 
 	if args != nil {
 		for _, arg := range args {
@@ -72,6 +122,12 @@ func SetupRouter(
 			if v, ok := arg.(services.EmailServiceType); ok {
 				email = v.(*mocks.MockEmailService)
 			}
+			if v, ok := arg.(services.BackendServiceType); ok {
+				backend = v.(*mocks.MockBackendService)
+			}
+			if v, ok := arg.(services.UpstreamServiceType); ok {
+				upstream = v.(*mocks.MockUpstreamService)
+			}
 			if v, ok := arg.(services.CodeGeneratorType); ok {
 				codeGen = v.(*mocks.MockCodeGenerator)
 			}
@@ -79,6 +135,18 @@ func SetupRouter(
 			if v, ok := arg.(services.ClockType); ok {
 				clock = v.(*mocks.MockClock)
 			}
+
+			if v, ok := arg.(services.KeySetType); ok {
+				keySet = v
+			}
+
+			if v, ok := arg.(services.OauthServerType); ok {
+				oauthServer = v
+			}
+
+			if v, ok := arg.(services.PasswordPolicy); ok {
+				passwordPolicy = v
+			}
 		}
 	}
 
@@ -92,10 +160,14 @@ func SetupRouter(
 	serviceProvider := services.CreateServiceProvider(
 		sessionArg,
 		db,
-		&mocks.MockOauthServer{},
+		oauthServer,
 		email,
+		backend,
+		upstream,
 		codeGen,
 		clock,
+		keySet,
+		passwordPolicy,
 	)
 
 	router := setupRouter(&serviceProvider)
@@ -134,6 +206,7 @@ func TestPostLogin(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, "/auth", w.Header().Get("Location"))
@@ -155,10 +228,11 @@ func TestPostLoginUserNotFound(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "Login")
-	assert.Contains(t, w.Body.String(), "User not found")
+	assert.Contains(t, w.Body.String(), invalidCredentialsMessage)
 }
 
 func TestPostLoginUserIncorrectPassword(t *testing.T) {
@@ -176,10 +250,11 @@ func TestPostLoginUserIncorrectPassword(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "Login")
-	assert.Contains(t, w.Body.String(), "Incorrect password")
+	assert.Contains(t, w.Body.String(), invalidCredentialsMessage)
 }
 
 func TestPostLoginUserNotValidated(t *testing.T) {
@@ -204,6 +279,7 @@ func TestPostLoginUserNotValidated(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "Login")
@@ -232,10 +308,11 @@ func TestPostLoginPendingUserExpired(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "Login")
-	assert.Contains(t, w.Body.String(), "User not found")
+	assert.Contains(t, w.Body.String(), invalidCredentialsMessage)
 
 	var user *models.User
 	notFoundErr := db.Where("email = ?", testUser).First(&user).Error
@@ -329,7 +406,7 @@ func SetupUnValidatedUser(
 		"update users set validated = ?, code_expiration = ?, validation_code = ?",
 		false,
 		expiry,
-		validationCode,
+		services.HashToken(validationCode),
 	)
 
 	return db, mockClock
@@ -359,6 +436,92 @@ func TestEmailValidated(t *testing.T) {
 	db.Where("email = ?", testUser).First(&user)
 
 	assert.True(t, user.Validated)
+	assert.Empty(t, user.ValidationCode)
+}
+
+func TestEmailValidationCodeReused(t *testing.T) {
+	db := Setup()
+
+	db, mockClock := SetupUnValidatedUser(db)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	url := fmt.Sprintf("/validate-email?code=%s&email=%s", validationCode, testUser)
+
+	first := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, nil)
+	router.ServeHTTP(first, req)
+
+	assert.Contains(t, first.Body.String(), "Your account is now active.")
+
+	second := httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", url, nil)
+	router.ServeHTTP(second, req)
+
+	assert.Equal(t, http.StatusBadRequest, second.Code)
+	assert.Contains(t, second.Body.String(), "Invalid code")
+}
+
+func TestEmailValidationCodeExpired(t *testing.T) {
+	db := Setup()
+
+	db, _ = SetupUnValidatedUser(db)
+
+	mockClock := &mocks.MockClock{Time: now.Add(time.Hour * 25)}
+
+	router := SetupRouter(db, mockClock)
+
+	w := httptest.NewRecorder()
+
+	defer Teardown(db)
+
+	url := fmt.Sprintf("/validate-email?code=%s&email=%s", validationCode, testUser)
+
+	req, _ := http.NewRequest("GET", url, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Verification link expired")
+	assert.Contains(t, w.Body.String(), "Send new code")
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.False(t, user.Validated)
+}
+
+func TestPostValidateResendExpired(t *testing.T) {
+	db := Setup()
+
+	db, _ = SetupUnValidatedUser(db)
+
+	mockClock := &mocks.MockClock{Time: now.Add(time.Hour * 25)}
+	mockCodeGen := mocks.MockCodeGenerator{Code: "new-code"}
+	mockEmail := mocks.MockEmailService{}
+
+	router := SetupRouter(db, mockClock, &mockCodeGen, &mockEmail)
+
+	w := httptest.NewRecorder()
+
+	defer Teardown(db)
+
+	url := fmt.Sprintf("/validate-email?email=%s", testUser)
+
+	req, _ := http.NewRequest("POST", url, nil)
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), "Code has been sent.")
+	assert.Equal(t, "new-code", mockEmail.ValidationCode)
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, user.CodeExpiration.Equal(mockClock.AddTime(mockClock.Time, 24, 0, 0)))
 }
 
 func TestValidateEmailNoCode(t *testing.T) {
@@ -463,6 +626,7 @@ func TestPostValidateResend(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", url, nil)
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.NotContains(t, w.Body.String(), "Resend code")
@@ -471,7 +635,7 @@ func TestPostValidateResend(t *testing.T) {
 	var user *models.User
 	db.Where("email = ?", testUser).First(&user)
 
-	assert.Equal(t, user.ValidationCode, "new-code")
+	assert.Equal(t, user.ValidationCode, services.HashToken("new-code"))
 }
 
 func TestPostValidateNoEmail(t *testing.T) {
@@ -489,6 +653,7 @@ func TestPostValidateNoEmail(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", url, nil)
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, "/signup", w.Header().Get("Location"))
@@ -509,6 +674,7 @@ func TestPostValidateUserNotFound(t *testing.T) {
 
 	req, _ := http.NewRequest("POST", url, nil)
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "User other not found")
@@ -540,6 +706,7 @@ func TestSignupSuccess(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, mockEmail.VerificationEmail, testUser)
@@ -549,7 +716,7 @@ func TestSignupSuccess(t *testing.T) {
 	db.Where("email = ?", testUser).First(&user)
 
 	assert.True(t, user.CodeExpiration.Equal(expiry))
-	assert.Equal(t, user.ValidationCode, "testing-code")
+	assert.Equal(t, user.ValidationCode, services.HashToken("testing-code"))
 	assert.Equal(t, user.Name, testName)
 
 	assert.Equal(t, fmt.Sprintf("/validate-email?email=%s", testUser), w.Header().Get("Location"))
@@ -572,6 +739,7 @@ func TestSignupAlreadyExists(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), fmt.Sprintf("Email %s already exists", testUser))
@@ -596,6 +764,7 @@ func TestSignupMissingField(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "Invalid field: name")
@@ -620,6 +789,7 @@ func TestSignupInvalidPassword(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/signup", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
+	SignRequest(router, w, req)
 	router.ServeHTTP(w, req)
 
 	assert.Contains(t, w.Body.String(), "Password must be a minimum of 8 characters")
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"server/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrEmailTaken = errors.New("email already in use")
+
+// EmailInUse checks both current addresses and ones waiting to be confirmed.
+func (repo *UserRepository) EmailInUse(email string) bool {
+	var count int64
+	repo.db.Model(&models.User{}).Where("email = ? or pending_email = ?", email, email).Count(&count)
+
+	return count > 0
+}
+
+// GetUserByPendingEmail finds the user confirming a change to email.
+func (repo *UserRepository) GetUserByPendingEmail(email string) (*models.User, error) {
+	var user *models.User
+	if err := repo.db.Where("pending_email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RequestEmailChange keeps the current address until the new one is
+// confirmed with the code sent to it.
+func (repo *UserRepository) RequestEmailChange(user *models.User, email string, code string) {
+	user.PendingEmail = email
+	user.ValidationCode = HashToken(code)
+	user.CodeExpiration = getExpiry(repo.clock)
+	repo.db.Save(&user)
+}
+
+// ConfirmEmailChange moves the account and its consents to the pending
+// address. Tokens were issued with the old address as their subject, so
+// they're revoked.
+func (repo *UserRepository) ConfirmEmailChange(user *models.User, ip string) error {
+	previous := user.Email
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&models.User{}).Where("email = ?", user.PendingEmail).Count(&count)
+
+		if count > 0 {
+			return ErrEmailTaken
+		}
+
+		user.Email = user.PendingEmail
+		user.PendingEmail = ""
+		user.ValidationCode = ""
+
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ConsentGrant{}).Where("user_id = ?", previous).Update("user_id", user.Email).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	repo.families.RevokeUser(previous, EmailChanged, ip)
+
+	return nil
+}
+
+// ChangePassword saves a new password hash, drops any pending reset code and
+// revokes the refresh tokens issued before the change.
+func (repo *UserRepository) ChangePassword(user *models.User, hash string, ip string) {
+	user.Password = hash
+	user.ResetCode = ""
+	user.ResetExpiration = time.Time{}
+	user.ResetRequired = false
+	repo.db.Save(&user)
+
+	repo.families.RevokeUser(user.Email, PasswordChanged, ip)
+}
+
+// DeleteUser removes the account along with its recovery codes, consents,
+// linked identities and login attempts. Token families are revoked rather than deleted so their
+// events remain.
+func (repo *UserRepository) DeleteUser(user *models.User, ip string) error {
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.Email).Delete(&models.ConsentGrant{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.LinkedIdentity{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.PairwiseSubject{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("key = ?", accountKey(user.Email)).Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", user.Email).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(&user).Error
+	})
+
+	if err != nil {
+		return err
+	}
+
+	repo.families.RevokeUser(user.Email, AccountDeleted, ip)
+
+	return nil
+}
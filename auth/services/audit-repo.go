@@ -0,0 +1,143 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"server/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditSignup          = "signup"
+	AuditEmailValidated  = "email_validated"
+	AuditLoginSucceeded  = "login_succeeded"
+	AuditLoginFailed     = "login_failed"
+	AuditAccountLocked   = "account_locked"
+	AuditTokenIssued     = "token_issued"
+	AuditTokenRefreshed  = "token_refreshed"
+	AuditTokenRevoked    = "token_revoked"
+	AuditPasswordChanged = "password_changed"
+	AuditPasswordReset   = "password_reset"
+)
+
+const defaultAuditRetention = time.Hour * 24 * 90
+
+// LoadAuditRetention reads AUDIT_RETENTION as a Go duration, e.g. "2160h".
+func LoadAuditRetention() time.Duration {
+	return durationEnv("AUDIT_RETENTION", defaultAuditRetention)
+}
+
+type AuditFilter struct {
+	Type     string
+	UserID   string
+	ClientID string
+	Since    time.Time
+	Until    time.Time
+}
+
+type AuditRepository struct {
+	db    *gorm.DB
+	clock ClockType
+}
+
+func CreateAuditRepo(db *gorm.DB, clock ClockType) AuditRepository {
+	return AuditRepository{db, clock}
+}
+
+// Record stamps the event with the current time. Failures are logged rather
+// than returned so auditing never blocks the action being audited.
+func (repo *AuditRepository) Record(event models.AuditEvent) {
+	event.CreatedAt = repo.clock.GetCurrentTime()
+
+	if err := repo.db.Create(&event).Error; err != nil {
+		log.Printf("failed to record %s audit event for %s: %s", event.Type, event.UserID, err.Error())
+	}
+}
+
+// RecordRequest records an event with the IP and user agent of the request
+// that caused it.
+func (repo *AuditRepository) RecordRequest(r *http.Request, eventType string, userID string, clientID string, detail string) {
+	repo.Record(models.AuditEvent{
+		Type:      eventType,
+		UserID:    userID,
+		ClientID:  clientID,
+		IPAddress: ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+	})
+}
+
+// Query pages through matching events, newest first, returning the page and
+// the total number of matches.
+func (repo *AuditRepository) Query(filter AuditFilter, offset int, limit int) ([]models.AuditEvent, int64) {
+	var events []models.AuditEvent
+	var total int64
+
+	db := repo.filter(filter)
+
+	db.Count(&total)
+	db.Order("created_at desc, id desc").Offset(offset).Limit(limit).Find(&events)
+
+	return events, total
+}
+
+// Each calls fn with matching events, oldest first, loading them in batches
+// so large exports don't have to fit in memory.
+func (repo *AuditRepository) Each(filter AuditFilter, fn func(event *models.AuditEvent) error) error {
+	var events []models.AuditEvent
+
+	return repo.filter(filter).Order("id").FindInBatches(&events, 500, func(tx *gorm.DB, batch int) error {
+		for i := range events {
+			if err := fn(&events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// DeleteExpired removes events older than retention.
+func (repo *AuditRepository) DeleteExpired(retention time.Duration) int64 {
+	cutoff := repo.clock.GetCurrentTime().Add(-retention)
+
+	return repo.db.Where("created_at < ?", cutoff).Delete(&models.AuditEvent{}).RowsAffected
+}
+
+// PruneEvery deletes expired events on an interval until done is closed.
+func (repo *AuditRepository) PruneEvery(interval time.Duration, retention time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			repo.DeleteExpired(retention)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (repo *AuditRepository) filter(filter AuditFilter) *gorm.DB {
+	db := repo.db.Model(&models.AuditEvent{})
+
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.UserID != "" {
+		db = db.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ClientID != "" {
+		db = db.Where("client_id = ?", filter.ClientID)
+	}
+	if !filter.Since.IsZero() {
+		db = db.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		db = db.Where("created_at < ?", filter.Until)
+	}
+
+	return db
+}
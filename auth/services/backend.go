@@ -0,0 +1,61 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+type BackendServiceType interface {
+	DeleteAccount(email string) error
+	ChangeEmail(email string, newEmail string) error
+}
+
+// BackendService keeps the backend's profiles in step with accounts. It
+// authenticates with the first-party client credentials, which the backend
+// also knows as AUTH_CLIENT_ID and AUTH_CLIENT_SECRET.
+type BackendService struct{}
+
+var backendClient = &http.Client{Timeout: time.Second * 10}
+
+func (backendService *BackendService) DeleteAccount(email string) error {
+	return backendService.send(http.MethodDelete, email, nil)
+}
+
+func (backendService *BackendService) ChangeEmail(email string, newEmail string) error {
+	return backendService.send(http.MethodPut, email, map[string]string{"email": newEmail})
+}
+
+func (backendService *BackendService) send(method string, email string, body interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/accounts/%s", GetBackendURL(), url.PathEscape(email))
+
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(encoded))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"))
+
+	res, err := backendClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("backend responded to %s %s with %d", method, endpoint, res.StatusCode)
+	}
+
+	return nil
+}
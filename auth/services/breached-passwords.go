@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BreachedPasswords is a set of known breached passwords. Only the first 8
+// bytes of each SHA-1 hash are kept, sorted, so a list of millions of
+// passwords fits in a few tens of megabytes with a negligible chance of a
+// false match.
+type BreachedPasswords struct {
+	hashes []uint64
+}
+
+// LoadBreachedPasswords reads the list at path. See ReadBreachedPasswords for
+// the format.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ReadBreachedPasswords(file)
+}
+
+// ReadBreachedPasswords reads one entry per line, either a plain password or
+// a hex SHA-1 hash optionally followed by ":count", as in the Have I Been
+// Pwned downloads.
+func ReadBreachedPasswords(reader io.Reader) (*BreachedPasswords, error) {
+	set := &BreachedPasswords{}
+
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if line == "" {
+			continue
+		}
+
+		if hash, ok := parseSHA1Prefix(line); ok {
+			set.hashes = append(set.hashes, hash)
+		} else {
+			set.hashes = append(set.hashes, passwordHashPrefix(line))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(set.hashes, func(i, j int) bool { return set.hashes[i] < set.hashes[j] })
+
+	return set, nil
+}
+
+func (set *BreachedPasswords) Contains(password string) bool {
+	if set == nil || len(set.hashes) == 0 {
+		return false
+	}
+
+	hash := passwordHashPrefix(password)
+	i := sort.Search(len(set.hashes), func(i int) bool { return set.hashes[i] >= hash })
+
+	return i < len(set.hashes) && set.hashes[i] == hash
+}
+
+func (set *BreachedPasswords) Len() int {
+	if set == nil {
+		return 0
+	}
+	return len(set.hashes)
+}
+
+func passwordHashPrefix(password string) uint64 {
+	sum := sha1.Sum([]byte(password))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func parseSHA1Prefix(line string) (uint64, bool) {
+	hash, _, _ := strings.Cut(line, ":")
+
+	if len(hash) != sha1.Size*2 {
+		return 0, false
+	}
+
+	decoded, err := hex.DecodeString(hash)
+	if err != nil {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint64(decoded[:8]), true
+}
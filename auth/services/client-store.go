@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"server/models"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/manage"
+	"gorm.io/gorm"
+)
+
+var SupportedGrantTypes = []string{"authorization_code", "refresh_token"}
+
+var (
+	ErrInvalidRedirectURIs   = errors.New("invalid_redirect_uri")
+	ErrInvalidClientMetadata = errors.New("invalid_client_metadata")
+)
+
+// Client is the registered client stored in the data column. It implements
+// oauth2.ClientInfo and oauth2.ClientPasswordVerifier, so go-oauth2 checks
+// secrets against the stored hash.
+type Client struct {
+	ID           string    `json:"id"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	Disabled     bool      `json:"disabled"`
+	Dynamic      bool      `json:"dynamic"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	PostLogoutRedirectURIs []string `json:"post_logout_redirect_uris,omitempty"`
+}
+
+func (client *Client) GetID() string {
+	return client.ID
+}
+
+func (client *Client) GetSecret() string {
+	return client.SecretHash
+}
+
+// GetDomain returns the first redirect URI, which go-oauth2 uses when an
+// authorize request leaves redirect_uri out.
+func (client *Client) GetDomain() string {
+	if len(client.RedirectURIs) == 0 {
+		return ""
+	}
+	return client.RedirectURIs[0]
+}
+
+func (client *Client) IsPublic() bool {
+	return client.Public
+}
+
+func (client *Client) GetUserID() string {
+	return ""
+}
+
+func (client *Client) VerifyPassword(secret string) bool {
+	if client.Disabled {
+		return false
+	}
+
+	if client.Public {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(client.SecretHash)) == 1
+}
+
+// AllowsRedirectURI requires an exact match. An empty redirect URI is only
+// allowed when a single one is registered.
+func (client *Client) AllowsRedirectURI(redirectURI string) bool {
+	if redirectURI == "" {
+		return len(client.RedirectURIs) == 1
+	}
+
+	for _, uri := range client.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsPostLogoutRedirectURI requires an exact match against the URIs
+// registered for RP-initiated logout.
+func (client *Client) AllowsPostLogoutRedirectURI(redirectURI string) bool {
+	for _, uri := range client.PostLogoutRedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (client *Client) AllowsGrant(grant string) bool {
+	if client.Disabled {
+		return false
+	}
+
+	return HasScope(strings.Join(client.GrantTypes, " "), grant)
+}
+
+func (client *Client) AllowsScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !HasScope(strings.Join(client.Scopes, " "), s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyClientSecret checks a secret for any oauth2.ClientInfo, including
+// the unhashed clients used by the in-memory store.
+func VerifyClientSecret(client oauth2.ClientInfo, secret string) bool {
+	if verifier, ok := client.(oauth2.ClientPasswordVerifier); ok {
+		return verifier.VerifyPassword(secret)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(client.GetSecret()), []byte(secret)) == 1
+}
+
+// ValidRedirectURI checks the redirect URI of an authorize request. Clients
+// without registered redirect URIs fall back to go-oauth2's domain check.
+func ValidRedirectURI(client oauth2.ClientInfo, redirectURI string) bool {
+	if registered, ok := client.(*Client); ok {
+		return registered.AllowsRedirectURI(redirectURI)
+	}
+
+	return redirectURI == "" || manage.DefaultValidateURI(client.GetDomain(), redirectURI) == nil
+}
+
+// ValidateClientMetadata checks the redirect URIs, grant types and scopes of
+// a client before it's saved.
+func ValidateClientMetadata(client *Client) error {
+	if len(client.RedirectURIs) == 0 {
+		return ErrInvalidRedirectURIs
+	}
+
+	for _, uri := range client.RedirectURIs {
+		if !absoluteURI(uri) {
+			return ErrInvalidRedirectURIs
+		}
+	}
+
+	for _, uri := range client.PostLogoutRedirectURIs {
+		if !absoluteURI(uri) {
+			return ErrInvalidRedirectURIs
+		}
+	}
+
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = SupportedGrantTypes
+	}
+
+	for _, grant := range client.GrantTypes {
+		if !HasScope(strings.Join(SupportedGrantTypes, " "), grant) {
+			return ErrInvalidClientMetadata
+		}
+	}
+
+	if len(client.Scopes) == 0 {
+		client.Scopes = SupportedScopes
+	}
+
+	for _, scope := range client.Scopes {
+		if !HasScope(strings.Join(SupportedScopes, " "), scope) {
+			return ErrInvalidClientMetadata
+		}
+	}
+
+	return nil
+}
+
+func uriOrigin(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return ""
+	}
+
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+func absoluteURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+
+	return err == nil && parsed.Scheme != "" && parsed.Host != "" && parsed.Fragment == ""
+}
+
+// ClientStore replaces the go-oauth2-pg client store on the same table. The
+// pg store decodes data into a plain models.Client and would drop the
+// redirect URIs, grant types and scopes.
+type ClientStore struct {
+	db       *gorm.DB
+	clock    ClockType
+	families TokenFamilyRepository
+}
+
+func CreateClientStore(db *gorm.DB, clock ClockType, families TokenFamilyRepository) ClientStore {
+	return ClientStore{db, clock, families}
+}
+
+func (store *ClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	return store.GetClient(id)
+}
+
+func (store *ClientStore) GetClient(id string) (*Client, error) {
+	var row models.OauthClient
+	if err := store.db.Where("id = ?", id).First(&row).Error; err != nil {
+		return nil, err
+	}
+
+	return decodeClient(row)
+}
+
+func (store *ClientStore) GetClients() []Client {
+	var rows []models.OauthClient
+	store.db.Order("id").Find(&rows)
+
+	clients := []Client{}
+
+	for _, row := range rows {
+		if client, err := decodeClient(row); err == nil {
+			clients = append(clients, *client)
+		}
+	}
+
+	return clients
+}
+
+// CreateClient stores a new client and returns its secret, which is only
+// ever available here. Public clients get no secret.
+func (store *ClientStore) CreateClient(client *Client) (string, error) {
+	if client.ID == "" {
+		client.ID = RandomToken(16)
+	}
+
+	client.CreatedAt = store.clock.GetCurrentTime()
+
+	secret := ""
+	client.SecretHash = ""
+
+	if !client.Public {
+		secret = RandomToken(32)
+		client.SecretHash = HashToken(secret)
+	}
+
+	if err := store.db.Create(encodeClient(client)).Error; err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// EnsureClient creates or updates a client with a known secret, for the
+// first-party client configured through the environment.
+func (store *ClientStore) EnsureClient(client *Client, secret string) error {
+	if existing, err := store.GetClient(client.ID); err == nil {
+		client.CreatedAt = existing.CreatedAt
+	} else {
+		client.CreatedAt = store.clock.GetCurrentTime()
+	}
+
+	client.SecretHash = ""
+
+	if !client.Public {
+		client.SecretHash = HashToken(secret)
+	}
+
+	return store.db.Save(encodeClient(client)).Error
+}
+
+func (store *ClientStore) RotateSecret(client *Client) (string, error) {
+	if client.Public {
+		return "", ErrInvalidClientMetadata
+	}
+
+	secret := RandomToken(32)
+	client.SecretHash = HashToken(secret)
+
+	return secret, store.SaveClient(client)
+}
+
+// DisableClient stops the client from getting new tokens and revokes the
+// refresh tokens it already holds.
+func (store *ClientStore) DisableClient(client *Client, ip string) error {
+	client.Disabled = true
+
+	if err := store.SaveClient(client); err != nil {
+		return err
+	}
+
+	store.families.RevokeAllForClient(client.ID, ClientDisabled, ip)
+
+	return nil
+}
+
+func (store *ClientStore) SaveClient(client *Client) error {
+	return store.db.Save(encodeClient(client)).Error
+}
+
+func encodeClient(client *Client) *models.OauthClient {
+	data, _ := json.Marshal(client)
+
+	return &models.OauthClient{
+		ID:     client.ID,
+		Secret: client.SecretHash,
+		Domain: client.GetDomain(),
+		Data:   data,
+	}
+}
+
+func decodeClient(row models.OauthClient) (*Client, error) {
+	var client Client
+	if err := json.Unmarshal(row.Data, &client); err != nil {
+		return nil, err
+	}
+
+	client.ID = row.ID
+	client.SecretHash = row.Secret
+
+	return &client, nil
+}
+
+// DynamicRegistrationEnabled turns on RFC 7591 registration at
+// /oauth/register. When CLIENT_REGISTRATION_TOKEN is set it has to be sent
+// as a bearer token.
+func DynamicRegistrationEnabled() bool {
+	return os.Getenv("DYNAMIC_CLIENT_REGISTRATION") == "true"
+}
+
+// FirstPartyClientID is CLIENT_ID, the client of our own frontend.
+func FirstPartyClientID() string {
+	return os.Getenv("CLIENT_ID")
+}
+
+// FirstPartyRedirectURIs reads CLIENT_REDIRECT_URIS as a comma separated
+// list, defaulting to the NextAuth callback of NEXTAUTH_URL.
+func FirstPartyRedirectURIs() []string {
+	value := os.Getenv("CLIENT_REDIRECT_URIS")
+
+	if value == "" {
+		return []string{os.Getenv("NEXTAUTH_URL") + "/api/auth/callback/auth"}
+	}
+
+	return splitURIs(value)
+}
+
+// FirstPartyPostLogoutRedirectURIs reads CLIENT_POST_LOGOUT_REDIRECT_URIS the
+// same way, defaulting to NEXTAUTH_URL itself.
+func FirstPartyPostLogoutRedirectURIs() []string {
+	value := os.Getenv("CLIENT_POST_LOGOUT_REDIRECT_URIS")
+
+	if value == "" {
+		return []string{os.Getenv("NEXTAUTH_URL")}
+	}
+
+	return splitURIs(value)
+}
+
+// CORSOrigins reads CORS_ORIGINS, the comma separated origins allowed to
+// call the API from a browser, defaulting to the origins of the first-party
+// client's URIs. Registered clients are left out, since anyone can register
+// one while registration is open.
+func CORSOrigins() []string {
+	uris := splitURIs(os.Getenv("CORS_ORIGINS"))
+
+	if len(uris) == 0 {
+		uris = append(FirstPartyRedirectURIs(), FirstPartyPostLogoutRedirectURIs()...)
+	}
+
+	origins := []string{}
+
+	for _, uri := range uris {
+		if origin := uriOrigin(uri); origin != "" {
+			origins = append(origins, strings.ToLower(origin))
+		}
+	}
+
+	return origins
+}
+
+// AllowOrigins returns a CORS origin check for a fixed set of origins.
+func AllowOrigins(origins []string) func(origin string) bool {
+	allowed := map[string]bool{}
+
+	for _, origin := range origins {
+		allowed[strings.ToLower(origin)] = true
+	}
+
+	return func(origin string) bool {
+		return allowed[strings.ToLower(origin)]
+	}
+}
+
+func splitURIs(value string) []string {
+	var uris []string
+
+	for _, uri := range strings.Split(value, ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+
+	return uris
+}
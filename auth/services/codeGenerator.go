@@ -1,10 +1,10 @@
 package services
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
-	"math/rand"
+	"encoding/hex"
 )
 
 type CodeGeneratorType interface {
@@ -13,7 +13,21 @@ type CodeGeneratorType interface {
 
 type CodeGenerator struct{}
 
+// GenCode returns a random code for emailed links, which are bearer
+// credentials, so it comes from crypto/rand.
 func (gen *CodeGenerator) GenCode() string {
-	s256 := sha256.Sum256([]byte(fmt.Sprint(rand.Int())))
-	return base64.URLEncoding.EncodeToString(s256[:])
+	return RandomToken(32)
+}
+
+func RandomToken(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// HashToken is how codes and tokens are stored, so a leaked database doesn't
+// leak usable credentials.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"server/models"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const ConsentRevoked = "consent_revoked"
+
+type ConsentRepository struct {
+	db       *gorm.DB
+	clock    ClockType
+	families TokenFamilyRepository
+}
+
+func CreateConsentRepo(db *gorm.DB, clock ClockType, families TokenFamilyRepository) ConsentRepository {
+	return ConsentRepository{db, clock, families}
+}
+
+func (repo *ConsentRepository) GetConsents(userID string) []models.ConsentGrant {
+	var grants []models.ConsentGrant
+	repo.db.Where("user_id = ?", userID).Order("client_id").Find(&grants)
+	return grants
+}
+
+func (repo *ConsentRepository) GetConsent(userID string, clientID string) (*models.ConsentGrant, error) {
+	var grant *models.ConsentGrant
+	if err := repo.db.Where("user_id = ? and client_id = ?", userID, clientID).First(&grant).Error; err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// HasConsent is true when the user has already allowed the client every
+// scope in scope.
+func (repo *ConsentRepository) HasConsent(userID string, clientID string, scope string) bool {
+	grant, err := repo.GetConsent(userID, clientID)
+	if err != nil {
+		return false
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if !HasScope(grant.Scope, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GrantConsent adds scope to whatever the user already allowed the client.
+func (repo *ConsentRepository) GrantConsent(userID string, clientID string, scope string) {
+	now := repo.clock.GetCurrentTime()
+
+	grant, err := repo.GetConsent(userID, clientID)
+	if err != nil {
+		repo.db.Create(&models.ConsentGrant{
+			UserID:    userID,
+			ClientID:  clientID,
+			Scope:     scope,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		return
+	}
+
+	grant.Scope = NormalizeScope(grant.Scope + " " + scope)
+	grant.UpdatedAt = now
+	repo.db.Save(grant)
+}
+
+// RevokeConsent removes the grant and revokes the token families already
+// issued to the client for the user.
+func (repo *ConsentRepository) RevokeConsent(userID string, clientID string, ip string) bool {
+	result := repo.db.Where("user_id = ? and client_id = ?", userID, clientID).Delete(&models.ConsentGrant{})
+
+	if result.RowsAffected == 0 {
+		return false
+	}
+
+	repo.families.RevokeClient(userID, clientID, ConsentRevoked, ip)
+
+	return true
+}
@@ -1,116 +1,106 @@
 package services
 
 import (
-	"crypto/tls"
 	"fmt"
-	"net"
+	"mailer"
 	"net/mail"
-	"net/smtp"
-	"os"
+	"net/url"
+	"server/emails"
+	"time"
 )
 
+// EmailArgs names the template to send and its data. Locale picks the
+// translation, see emails.Templates.Render.
 type EmailArgs struct {
-	To      string
-	Subject string
-	Body    string
+	To       string
+	Locale   string
+	Template string
+	Data     map[string]string
 }
 
 type EmailServiceType interface {
 	SendEmail(args EmailArgs) error
-	SendVerificationLink(email string, code string) error
+	SendVerificationLink(email string, code string, locale string) error
+	SendPasswordResetLink(email string, code string, locale string) error
+	SendLockoutNotice(email string, locale string) error
+	SendLoginLink(email string, code string, locale string) error
 }
 
-type EmailService struct{}
-
-func (emailService *EmailService) SendEmail(args EmailArgs) error {
-	from := mail.Address{Name: "HomeTrainers.net", Address: "support@hometrainers.net"}
-	to := mail.Address{Name: "", Address: args.To}
-	subj := args.Subject
-	body := args.Body
-
-	headers := make(map[string]string)
-	headers["From"] = from.String()
-	headers["To"] = to.String()
-	headers["Subject"] = subj
-
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + body
-
-	servername := "smtp.zoho.com:465"
-
-	host, _, _ := net.SplitHostPort(servername)
-
-	auth := smtp.PlainAuth("", "support@hometrainers.net", os.Getenv("MAIL_PASSWORD"), host)
+type EmailService struct {
+	transport mailer.Transport
+	from      mail.Address
+}
 
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
-	}
+func CreateEmailService(transport mailer.Transport, from mail.Address) *EmailService {
+	return &EmailService{transport, from}
+}
 
-	conn, err := tls.Dial("tcp", servername, tlsconfig)
+func (emailService *EmailService) SendEmail(args EmailArgs) error {
+	to, err := mail.ParseAddress(args.To)
 	if err != nil {
 		return err
 	}
 
-	c, err := smtp.NewClient(conn, host)
+	content, err := emails.Templates.Render(args.Template, args.Locale, args.Data)
 	if err != nil {
 		return err
 	}
 
-	if err = c.Auth(auth); err != nil {
-		return err
+	message := mailer.Message{
+		From:    emailService.from,
+		To:      *to,
+		Date:    time.Now(),
+		Content: content,
 	}
 
-	if err = c.Mail(from.Address); err != nil {
-		return err
-	}
-
-	if err = c.Rcpt(to.Address); err != nil {
-		return err
-	}
-
-	w, err := c.Data()
+	data, err := message.Bytes()
 	if err != nil {
 		return err
 	}
 
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		return err
-	}
-
-	err = w.Close()
-	if err != nil {
-		return err
-	}
+	return emailService.transport.Send(emailService.from.Address, []string{to.Address}, data)
+}
 
-	c.Quit()
+func (emailService *EmailService) SendVerificationLink(email string, code string, locale string) error {
+	link := fmt.Sprintf("%s/validate-email?code=%s&email=%s", GetHost(), url.QueryEscape(code), url.QueryEscape(email))
 
-	return nil
+	return emailService.SendEmail(EmailArgs{
+		To:       email,
+		Locale:   locale,
+		Template: "verification",
+		Data:     map[string]string{"Link": link},
+	})
 }
 
-func (emailService *EmailService) SendVerificationLink(email string, code string) error {
-	var host string
+func (emailService *EmailService) SendPasswordResetLink(email string, code string, locale string) error {
+	link := fmt.Sprintf("%s/reset-password?code=%s&email=%s", GetHost(), url.QueryEscape(code), url.QueryEscape(email))
 
-	if os.Getenv("ENVIRONMENT") == "PROD" {
-		host = "https://auth.hometrainers.net"
-	} else {
-		host = "http://localhost:9096"
-	}
+	return emailService.SendEmail(EmailArgs{
+		To:       email,
+		Locale:   locale,
+		Template: "password-reset",
+		Data:     map[string]string{"Link": link},
+	})
+}
 
-	link := fmt.Sprintf("%s/validate-email?code=%s&email=%s", host, code, email)
-	body := fmt.Sprintf("Please visit %s to validate your email address.", link)
+func (emailService *EmailService) SendLockoutNotice(email string, locale string) error {
+	link := fmt.Sprintf("%s/forgot-password", GetHost())
 
-	emailArgs := EmailArgs{
-		To:      email,
-		Subject: "Verify email",
-		Body:    body,
-	}
+	return emailService.SendEmail(EmailArgs{
+		To:       email,
+		Locale:   locale,
+		Template: "lockout",
+		Data:     map[string]string{"Link": link},
+	})
+}
 
-	err := emailService.SendEmail(emailArgs)
+func (emailService *EmailService) SendLoginLink(email string, code string, locale string) error {
+	link := fmt.Sprintf("%s/login/link/confirm?code=%s&email=%s", GetHost(), url.QueryEscape(code), url.QueryEscape(email))
 
-	return err
+	return emailService.SendEmail(EmailArgs{
+		To:       email,
+		Locale:   locale,
+		Template: "login-link",
+		Data:     map[string]string{"Link": link},
+	})
 }
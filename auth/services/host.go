@@ -0,0 +1,23 @@
+package services
+
+import "os"
+
+func GetHost() string {
+	if host := os.Getenv("AUTH_SERVER_URL"); host != "" {
+		return host
+	}
+
+	if os.Getenv("ENVIRONMENT") == "PROD" {
+		return "https://auth.hometrainers.net"
+	}
+
+	return "http://localhost:9096"
+}
+
+func GetBackendURL() string {
+	if url := os.Getenv("BACKEND_URL"); url != "" {
+		return url
+	}
+
+	return "http://localhost:8080"
+}
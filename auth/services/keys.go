@@ -0,0 +1,338 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	ExpiresAt time.Time
+}
+
+type KeySetType interface {
+	GetSigningKey() *SigningKey
+	GetPublicKey(kid string) (*SigningKey, bool)
+	GetJWKS() JWKS
+}
+
+// Retired keys stay published until ExpiresAt so tokens signed before a
+// rotation can still be verified.
+type KeySet struct {
+	signing *SigningKey
+	retired []*SigningKey
+	clock   ClockType
+}
+
+func CreateKeySet(signing *SigningKey, retired []*SigningKey, clock ClockType) *KeySet {
+	return &KeySet{signing, retired, clock}
+}
+
+func (keys *KeySet) GetSigningKey() *SigningKey {
+	return keys.signing
+}
+
+func (keys *KeySet) GetPublicKey(kid string) (*SigningKey, bool) {
+	for _, key := range keys.publishedKeys() {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (keys *KeySet) GetJWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range keys.publishedKeys() {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+
+	return jwks
+}
+
+func (keys *KeySet) publishedKeys() []*SigningKey {
+	published := []*SigningKey{keys.signing}
+	now := keys.clock.GetCurrentTime()
+
+	for _, key := range keys.retired {
+		if key.ExpiresAt.IsZero() || now.Before(key.ExpiresAt) {
+			published = append(published, key)
+		}
+	}
+
+	return published
+}
+
+func (key *SigningKey) JWK() JWK {
+	jwk := JWK{
+		Use: "sig",
+		Alg: key.Method.Alg(),
+		Kid: key.ID,
+	}
+
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(pub.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encodeSegment(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(pub.Y.FillBytes(make([]byte, size)))
+	}
+
+	return jwk
+}
+
+// PublicKey decodes a published key, used to check ID tokens from upstream
+// identity providers.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, nErr := decodeSegment(jwk.N)
+		e, eErr := decodeSegment(jwk.E)
+		if nErr != nil || eErr != nil {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, xErr := decodeSegment(jwk.X)
+		y, yErr := decodeSegment(jwk.Y)
+		if xErr != nil || yErr != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// RFC 7638 thumbprint, used as the kid when none is configured.
+func (key *SigningKey) Thumbprint() string {
+	jwk := key.JWK()
+
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+
+	encoded, _ := json.Marshal(members)
+	sum := sha256.Sum256(encoded)
+
+	return encodeSegment(sum[:])
+}
+
+// LoadKeySet reads the signing key from JWT_SIGNING_KEY and the retired
+// keys from JWT_RETIRED_KEYS. Retired keys are PEM blocks that may carry a
+// Key-Id header and an Expires header (RFC 3339) after which they are no
+// longer published. Outside of production an ephemeral key is generated
+// when no signing key is configured.
+func LoadKeySet(clock ClockType) (*KeySet, error) {
+	signingPEM := os.Getenv("JWT_SIGNING_KEY")
+
+	var signing *SigningKey
+	var err error
+
+	if signingPEM == "" {
+		if os.Getenv("ENVIRONMENT") == "PROD" {
+			return nil, errors.New("JWT_SIGNING_KEY is required")
+		}
+
+		log.Println("JWT_SIGNING_KEY not set, generating an ephemeral signing key")
+
+		signing, err = GenerateSigningKey()
+	} else {
+		block, _ := pem.Decode([]byte(signingPEM))
+		if block == nil {
+			return nil, errors.New("JWT_SIGNING_KEY is not a PEM encoded key")
+		}
+		signing, err = parseKeyBlock(block)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if kid := os.Getenv("JWT_SIGNING_KEY_ID"); kid != "" {
+		signing.ID = kid
+	}
+
+	retired := []*SigningKey{}
+	rest := []byte(os.Getenv("JWT_RETIRED_KEYS"))
+
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		key, keyErr := parseKeyBlock(block)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+
+		if expires, ok := block.Headers["Expires"]; ok {
+			key.ExpiresAt, keyErr = time.Parse(time.RFC3339, expires)
+			if keyErr != nil {
+				return nil, fmt.Errorf("invalid Expires header for retired key: %w", keyErr)
+			}
+		}
+
+		retired = append(retired, key)
+	}
+
+	return CreateKeySet(signing, retired, clock), nil
+}
+
+func GenerateSigningKey() (*SigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(private, &private.PublicKey, "")
+}
+
+func parseKeyBlock(block *pem.Block) (*SigningKey, error) {
+	var private crypto.Signer
+	var public crypto.PublicKey
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		private, public = key, &key.PublicKey
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		private, public = key, &key.PublicKey
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		private, public = signer, signer.Public()
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		public = key
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+
+	return newSigningKey(private, public, block.Headers["Key-Id"])
+}
+
+func newSigningKey(private crypto.Signer, public crypto.PublicKey, kid string) (*SigningKey, error) {
+	key := &SigningKey{
+		Private: private,
+		Public:  public,
+	}
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		default:
+			return nil, errors.New("unsupported elliptic curve")
+		}
+	default:
+		return nil, errors.New("unsupported key type, expected RSA or EC")
+	}
+
+	key.ID = kid
+	if key.ID == "" {
+		key.ID = key.Thumbprint()
+	}
+
+	return key, nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(value)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"server/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrUpstreamEmailUnverified = errors.New("upstream email is not verified")
+
+// LinkUpstreamIdentity returns the user an upstream identity belongs to. The
+// first time an identity is seen it's linked by verified email, creating the
+// user if there isn't one yet.
+func (repo *UserRepository) LinkUpstreamIdentity(provider string, identity *UpstreamIdentity) (*models.User, error) {
+	var linked models.LinkedIdentity
+
+	err := repo.db.Where("provider = ? and subject = ?", provider, identity.Subject).First(&linked).Error
+
+	if err == nil {
+		var user *models.User
+		if err := repo.db.First(&user, linked.UserID).Error; err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, ErrUpstreamEmailUnverified
+	}
+
+	var user *models.User
+
+	err = repo.db.Transaction(func(tx *gorm.DB) error {
+		findErr := tx.Where("email = ?", identity.Email).First(&user).Error
+
+		if errors.Is(findErr, gorm.ErrRecordNotFound) {
+			name := identity.Name
+			if name == "" {
+				name = identity.Email
+			}
+
+			// An empty password never matches a hash, so the account can
+			// only sign in upstream until a password is set with a reset.
+			user = &models.User{
+				Email:     identity.Email,
+				Name:      name,
+				Validated: true,
+			}
+
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		} else if findErr != nil {
+			return findErr
+		} else if !user.Validated {
+			// Whoever signed up without verifying may not own the email, so
+			// their password is dropped rather than trusted.
+			user.Validated = true
+			user.Password = ""
+			user.ValidationCode = ""
+
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.LinkedIdentity{
+			Provider: provider,
+			Subject:  identity.Subject,
+			UserID:   user.ID,
+		}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"server/models"
+	"strings"
+	"time"
+)
+
+const (
+	accountLockThreshold = 5
+	ipLockThreshold      = 20
+	baseLockout          = time.Minute
+	maxLockout           = time.Hour
+	failureWindow        = time.Hour * 24
+)
+
+// LoginLockedUntil returns when the account or IP may try again, or the zero
+// time when neither is locked. Unknown emails are tracked the same way as
+// real accounts so lockouts don't reveal which emails are registered.
+func (repo *UserRepository) LoginLockedUntil(email string, ip string) time.Time {
+	now := repo.clock.GetCurrentTime()
+	until := time.Time{}
+
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		var attempt models.LoginAttempt
+		if err := repo.db.Where("key = ?", key).First(&attempt).Error; err != nil {
+			continue
+		}
+		if attempt.LockedUntil.After(now) && attempt.LockedUntil.After(until) {
+			until = attempt.LockedUntil
+		}
+	}
+
+	return until
+}
+
+// RecordFailedLogin returns true when this failure locked the account for
+// the first time since its last successful login.
+func (repo *UserRepository) RecordFailedLogin(email string, ip string) bool {
+	repo.recordFailure(ipKey(ip), ipLockThreshold)
+
+	return repo.recordFailure(accountKey(email), accountLockThreshold) == accountLockThreshold
+}
+
+func (repo *UserRepository) ClearFailedLogins(email string) {
+	repo.db.Where("key = ?", accountKey(email)).Delete(&models.LoginAttempt{})
+}
+
+func (repo *UserRepository) recordFailure(key string, threshold int) int {
+	now := repo.clock.GetCurrentTime()
+
+	attempt := models.LoginAttempt{Key: key}
+	repo.db.Where("key = ?", key).First(&attempt)
+
+	if now.Sub(attempt.LastFailure) > failureWindow {
+		attempt.Failures = 0
+	}
+
+	attempt.Failures++
+	attempt.LastFailure = now
+
+	if attempt.Failures >= threshold {
+		attempt.LockedUntil = now.Add(lockoutDuration(attempt.Failures - threshold))
+	}
+
+	repo.db.Save(&attempt)
+
+	return attempt.Failures
+}
+
+// lockoutDuration doubles for every failure past the threshold.
+func lockoutDuration(extraFailures int) time.Duration {
+	lockout := baseLockout
+	for i := 0; i < extraFailures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+
+	if lockout > maxLockout {
+		return maxLockout
+	}
+
+	return lockout
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"crypto/subtle"
+	"server/models"
+	"time"
+)
+
+// UpdateLoginCode stores the code for an emailed sign in link. The link
+// stands in for the password, so it only lasts fifteen minutes.
+func (repo *UserRepository) UpdateLoginCode(user *models.User, code string) {
+	user.LoginCode = HashToken(code)
+	user.LoginExpiration = repo.clock.AddTime(repo.clock.GetCurrentTime(), 0, 15, 0)
+	repo.db.Save(&user)
+}
+
+func (repo *UserRepository) ValidLoginCode(user *models.User, code string) bool {
+	if code == "" || user.LoginCode == "" {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashToken(code)), []byte(user.LoginCode)) != 1 {
+		return false
+	}
+
+	return repo.clock.GetCurrentTime().Before(user.LoginExpiration)
+}
+
+// UseLoginCode clears the code as it's checked so each link signs in once,
+// even when it's followed twice at the same time.
+func (repo *UserRepository) UseLoginCode(user *models.User, code string) bool {
+	if !repo.ValidLoginCode(user, code) {
+		return false
+	}
+
+	result := repo.db.Model(&models.User{}).
+		Where("id = ? and login_code = ?", user.ID, user.LoginCode).
+		Updates(map[string]interface{}{
+			"login_code":       "",
+			"login_expiration": time.Time{},
+		})
+
+	user.LoginCode = ""
+
+	return result.RowsAffected == 1
+}
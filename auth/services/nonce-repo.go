@@ -0,0 +1,46 @@
+package services
+
+import (
+	"server/models"
+
+	"gorm.io/gorm"
+)
+
+// NonceRepository remembers the OIDC nonce sent with an authorize request
+// until the matching code is exchanged for tokens.
+type NonceRepository struct {
+	db    *gorm.DB
+	clock ClockType
+}
+
+func CreateNonceRepo(db *gorm.DB, clock ClockType) NonceRepository {
+	return NonceRepository{db, clock}
+}
+
+func (repo *NonceRepository) SaveNonce(code string, nonce string) {
+	now := repo.clock.GetCurrentTime()
+
+	repo.db.Where("expires_at < ?", now).Delete(&models.AuthorizationNonce{})
+
+	repo.db.Create(&models.AuthorizationNonce{
+		Code:      code,
+		Nonce:     nonce,
+		ExpiresAt: repo.clock.AddTime(now, 0, 10, 0),
+	})
+}
+
+func (repo *NonceRepository) TakeNonce(code string) string {
+	var nonce models.AuthorizationNonce
+
+	if err := repo.db.Where("code = ?", code).First(&nonce).Error; err != nil {
+		return ""
+	}
+
+	repo.db.Delete(&nonce)
+
+	if nonce.ExpiresAt.Before(repo.clock.GetCurrentTime()) {
+		return ""
+	}
+
+	return nonce.Nonce
+}
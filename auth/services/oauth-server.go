@@ -2,50 +2,248 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"os"
 	"server/database"
+	"server/models"
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/errors"
-	"github.com/go-oauth2/oauth2/v4/generates"
 	"github.com/go-oauth2/oauth2/v4/manage"
-	"github.com/go-oauth2/oauth2/v4/models"
 	"github.com/go-oauth2/oauth2/v4/server"
-	"github.com/golang-jwt/jwt"
 	"github.com/jackc/pgx/v4"
 	pg "github.com/vgarvardt/go-oauth2-pg/v4"
 	"github.com/vgarvardt/go-pg-adapter/pgx4adapter"
+	"gorm.io/gorm"
+)
+
+const (
+	ConsentUserKey       = "ConsentUserID"
+	ConsentExpiresKey    = "ConsentExpires"
+	AuthenticatedUserKey = "AuthenticatedUserID"
 )
 
 type OauthServerType interface {
 	HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request) error
 	HandleTokenRequest(w http.ResponseWriter, r *http.Request) error
 	ValidationBearerToken(r *http.Request) (oauth2.TokenInfo, error)
+	HandleRevocationRequest(w http.ResponseWriter, r *http.Request) error
+	HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) error
 }
 
 type OauthServer struct {
-	server *server.Server
+	server    *server.Server
+	keys      KeySetType
+	userRepo  UserRepository
+	nonceRepo NonceRepository
+	families  TokenFamilyRepository
+	audit     AuditRepository
+	clock     ClockType
 }
 
+// HandleAuthorizeRequest checks the redirect URI against the client before
+// go-oauth2 handles the request, since its own check only knows one domain.
 func (oauth *OauthServer) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request) error {
+	client, err := oauth.server.Manager.GetClient(r.Context(), r.FormValue("client_id"))
+	if err != nil {
+		return oauth.tokenError(w, errors.ErrInvalidClient)
+	}
+
+	if !ValidRedirectURI(client, r.FormValue("redirect_uri")) {
+		return oauth.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	if err := checkPKCE(client, r); err != nil {
+		return oauth.tokenError(w, err)
+	}
+
 	return oauth.server.HandleAuthorizeRequest(w, r)
 }
 
+// HandleTokenRequest follows server.HandleTokenRequest and adds an id_token
+// when an authorization code granted with the openid scope is exchanged.
 func (oauth *OauthServer) HandleTokenRequest(w http.ResponseWriter, r *http.Request) error {
-	return oauth.server.HandleTokenRequest(w, r)
+	ctx := r.Context()
+
+	gt, tgr, err := oauth.server.ValidationTokenRequest(r)
+	if err != nil {
+		return oauth.tokenError(w, err)
+	}
+
+	var family *models.TokenFamily
+	var claim string
+
+	if gt == oauth2.Refreshing {
+		if err = oauth.checkRefreshClient(r, tgr); err != nil {
+			return oauth.tokenError(w, err)
+		}
+
+		if family, claim, err = oauth.claimRefreshToken(r, tgr.Refresh); err != nil {
+			return oauth.tokenError(w, err)
+		}
+	}
+
+	ti, err := oauth.server.GetAccessToken(ctx, gt, tgr)
+	if err != nil {
+		if family != nil {
+			oauth.families.ReleaseRefreshToken(tgr.Refresh, claim)
+		}
+		return oauth.tokenError(w, err)
+	}
+
+	if ti.GetRefresh() != "" {
+		if family != nil {
+			oauth.families.Rotate(family, ti, ClientIP(r))
+		} else {
+			oauth.families.StartFamily(ti, ClientIP(r))
+		}
+	}
+
+	event := AuditTokenIssued
+	if gt == oauth2.Refreshing {
+		event = AuditTokenRefreshed
+	}
+
+	oauth.audit.RecordRequest(r, event, ti.GetUserID(), ti.GetClientID(), gt.String())
+
+	data := oauth.server.GetTokenData(ti)
+
+	if gt == oauth2.AuthorizationCode && HasScope(ti.GetScope(), "openid") {
+		nonce := oauth.nonceRepo.TakeNonce(tgr.Code)
+
+		user, userErr := oauth.userRepo.GetUser(ti.GetUserID())
+		if userErr != nil {
+			return oauth.tokenError(w, userErr)
+		}
+
+		idToken, idErr := CreateIDToken(
+			oauth.keys,
+			GetHost(),
+			ti,
+			user,
+			oauth.userRepo.Subject(user, ti.GetClientID()),
+			nonce,
+			oauth.clock.GetCurrentTime(),
+		)
+		if idErr != nil {
+			return oauth.tokenError(w, idErr)
+		}
+
+		data["id_token"] = idToken
+	}
+
+	return writeTokenResponse(w, data, nil, http.StatusOK)
+}
+
+// claimRefreshToken rejects refresh tokens from revoked families. A token
+// that was already rotated revokes its family, since either the client or
+// an attacker is holding a stolen copy.
+func (oauth *OauthServer) claimRefreshToken(r *http.Request, refresh string) (*models.TokenFamily, string, error) {
+	family, claim, err := oauth.families.ClaimRefreshToken(refresh)
+	if err == gorm.ErrRecordNotFound {
+		// issued before families were tracked
+		return nil, "", nil
+	}
+
+	if err != nil {
+		log.Println("claiming refresh token failed:", err.Error())
+		return nil, "", errors.ErrServerError
+	}
+
+	if family.Revoked {
+		return nil, "", errors.ErrInvalidGrant
+	}
+
+	if claim == "" {
+		log.Printf("refresh token reuse detected for %s, revoking token family %s", family.UserID, family.ID)
+		oauth.families.RevokeFamily(family, TokenReused, ClientIP(r))
+		return nil, "", errors.ErrInvalidGrant
+	}
+
+	return family, claim, nil
+}
+
+// checkPKCE only accepts S256 challenges, since go-oauth2 falls back to
+// plain when no method is sent. Public clients can't keep a secret, so they
+// always need one.
+func checkPKCE(client oauth2.ClientInfo, r *http.Request) error {
+	challenge := r.FormValue("code_challenge")
+
+	if challenge == "" {
+		if client.IsPublic() {
+			return errors.ErrCodeChallengeRquired
+		}
+		return nil
+	}
+
+	if oauth2.CodeChallengeMethod(r.FormValue("code_challenge_method")) != oauth2.CodeChallengeS256 {
+		return errors.ErrUnsupportedCodeChallengeMethod
+	}
+
+	return nil
+}
+
+// checkRefreshClient authenticates the client on refresh, which go-oauth2
+// skips, and makes sure the refresh token was issued to it.
+func (oauth *OauthServer) checkRefreshClient(r *http.Request, tgr *oauth2.TokenGenerateRequest) error {
+	client, err := oauth.server.Manager.GetClient(r.Context(), tgr.ClientID)
+	if err != nil || !VerifyClientSecret(client, tgr.ClientSecret) {
+		return errors.ErrInvalidClient
+	}
+
+	// tokens missing from the store are left to claimRefreshToken, which
+	// detects replayed tokens
+	ti, err := oauth.server.Manager.LoadRefreshToken(r.Context(), tgr.Refresh)
+	if err == nil && ti.GetClientID() != client.GetID() {
+		return errors.ErrInvalidGrant
+	}
+
+	return nil
+}
+
+func (oauth *OauthServer) tokenError(w http.ResponseWriter, err error) error {
+	data, statusCode, header := oauth.server.GetErrorData(err)
+	return writeTokenResponse(w, data, header, statusCode)
+}
+
+func writeTokenResponse(w http.ResponseWriter, data map[string]interface{}, header http.Header, statusCode int) error {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	for key := range header {
+		w.Header().Set(key, header.Get(key))
+	}
+
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(data)
 }
 
 func (oauth *OauthServer) ValidationBearerToken(r *http.Request) (oauth2.TokenInfo, error) {
-	return oauth.server.ValidationBearerToken(r)
+	ti, err := oauth.server.ValidationBearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if oauth.families.IsRevoked(ti.GetRefresh()) {
+		return nil, errors.ErrInvalidAccessToken
+	}
+
+	return ti, nil
 }
 
-func CreateOauthServer(session SessionApiType, dsn string) OauthServerType {
-	idvar := os.Getenv("CLIENT_ID")
+func CreateOauthServer(
+	session SessionApiType,
+	dsn string,
+	db *gorm.DB,
+	clock ClockType,
+	keys KeySetType,
+) OauthServerType {
+	idvar := FirstPartyClientID()
 	secretvar := os.Getenv("CLIENT_SECRET")
-	domainvar := os.Getenv("NEXTAUTH_URL")
 
 	var pgxConn *pgx.Conn
 
@@ -55,30 +253,86 @@ func CreateOauthServer(session SessionApiType, dsn string) OauthServerType {
 		pgxConn, _ = pgx.Connect(context.Background(), dsn)
 	}
 
-	manager := manage.NewDefaultManager()
-
 	adapter := pgx4adapter.NewConn(pgxConn)
 
 	tokenStore, _ := pg.NewTokenStore(adapter, pg.WithTokenStoreGCInterval(time.Minute))
 	defer tokenStore.Close()
 
-	clientStore, _ := pg.NewClientStore(adapter)
+	families := CreateTokenFamilyRepo(db, clock, CreateAuditRepo(db, clock))
+	clientStore := CreateClientStore(db, clock, families)
+
+	if idvar != "" {
+		clientErr := clientStore.EnsureClient(&Client{
+			ID:           idvar,
+			Name:         "HomeTrainers.net",
+			RedirectURIs: FirstPartyRedirectURIs(),
+			GrantTypes:   SupportedGrantTypes,
+			Scopes:       SupportedScopes,
+
+			PostLogoutRedirectURIs: FirstPartyPostLogoutRedirectURIs(),
+		}, secretvar)
+
+		if clientErr != nil {
+			log.Println("Failed to save client", idvar, clientErr)
+		}
+	}
+
+	return CreateOauthServerFromStores(session, tokenStore, &clientStore, db, clock, keys)
+}
+
+func CreateOauthServerFromStores(
+	session SessionApiType,
+	tokenStore oauth2.TokenStore,
+	clientStore oauth2.ClientStore,
+	db *gorm.DB,
+	clock ClockType,
+	keys KeySetType,
+) *OauthServer {
+	manager := manage.NewDefaultManager()
+
+	accessExp, refreshExp := LoadTokenLifetimes()
 
-	clientStore.Create(&models.Client{
-		ID:     idvar,
-		Secret: secretvar,
-		Domain: domainvar,
+	manager.SetAuthorizeCodeTokenCfg(&manage.Config{
+		AccessTokenExp:    accessExp,
+		RefreshTokenExp:   refreshExp,
+		IsGenerateRefresh: true,
+	})
+
+	manager.SetRefreshTokenCfg(&manage.RefreshingConfig{
+		AccessTokenExp:     accessExp,
+		RefreshTokenExp:    refreshExp,
+		IsGenerateRefresh:  true,
+		IsResetRefreshTime: true,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
 	})
 
 	manager.MapTokenStorage(tokenStore)
 
 	manager.MapClientStorage(clientStore)
 
-	manager.MapAccessGenerate(generates.NewJWTAccessGenerate("", []byte("00000000"), jwt.SigningMethodHS512))
+	// redirect URIs are matched exactly in HandleAuthorizeRequest, and the
+	// code exchange still requires the URI the code was issued for
+	manager.SetValidateURIHandler(func(baseURI string, redirectURI string) error {
+		return nil
+	})
+
+	audit := CreateAuditRepo(db, clock)
+	families := CreateTokenFamilyRepo(db, clock, audit)
+	userRepo := CreateUserRepo(db, clock, families)
+	nonceRepo := CreateNonceRepo(db, clock)
+
+	manager.MapAuthorizeGenerate(CreateOIDCAuthorizeGenerate(nonceRepo))
+	manager.MapAccessGenerate(CreateJWTAccessGenerate(keys, GetHost(), userRepo))
 
 	srv := server.NewServer(server.NewConfig(), manager)
 
-	srv.SetUserAuthorizationHandler(userAuthorizeHandler(session))
+	consents := CreateConsentRepo(db, clock, families)
+
+	srv.SetUserAuthorizationHandler(userAuthorizeHandler(session, consents, clock, FirstPartyClientID()))
+	srv.SetAuthorizeScopeHandler(authorizeScopeHandler)
+	srv.SetClientAuthorizedHandler(clientAuthorizedHandler(clientStore))
+	srv.SetClientScopeHandler(clientScopeHandler(clientStore))
 
 	srv.SetInternalErrorHandler(func(err error) (re *errors.Response) {
 		log.Println("Internal Error:", err.Error())
@@ -91,11 +345,25 @@ func CreateOauthServer(session SessionApiType, dsn string) OauthServerType {
 
 	srv.SetClientInfoHandler(server.ClientFormHandler)
 
-	return &OauthServer{srv}
+	return &OauthServer{
+		server:    srv,
+		keys:      keys,
+		userRepo:  userRepo,
+		nonceRepo: nonceRepo,
+		families:  families,
+		audit:     audit,
+		clock:     clock,
+	}
 }
 
+// userAuthorizeHandler sends users to the consent page the first time a
+// client asks for a scope they haven't allowed yet. Our own frontend,
+// firstPartyClient, never needs consent.
 func userAuthorizeHandler(
 	session SessionApiType,
+	consents ConsentRepository,
+	clock ClockType,
+	firstPartyClient string,
 ) func(w http.ResponseWriter, r *http.Request) (userID string, err error) {
 	return func(w http.ResponseWriter, r *http.Request) (userID string, err error) {
 		store, err := session.Start(r.Context(), w, r)
@@ -119,10 +387,75 @@ func userAuthorizeHandler(
 			return
 		}
 
+		store.Delete("LoggedInUserID")
+
+		clientID := r.FormValue("client_id")
+
+		if clientID != firstPartyClient && !consents.HasConsent(uid.(string), clientID, NormalizeScope(r.FormValue("scope"))) {
+			store.Set("ReturnUri", r.Form)
+			store.Set(ConsentUserKey, uid.(string))
+			store.Set(ConsentExpiresKey, clock.AddTime(clock.GetCurrentTime(), 0, 10, 0).Format(time.RFC3339))
+			store.Save()
+
+			w.Header().Set("Location", "/consent")
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+
 		userID = uid.(string)
 
-		store.Delete("LoggedInUserID")
+		// LoggedInUserID only lasts for one authorization, this remembers
+		// who the session belongs to for logout
+		store.Set(AuthenticatedUserKey, userID)
 		store.Save()
 		return
 	}
 }
+
+// clientAuthorizedHandler enforces the grant types of registered clients.
+func clientAuthorizedHandler(
+	clientStore oauth2.ClientStore,
+) func(clientID string, grant oauth2.GrantType) (bool, error) {
+	return func(clientID string, grant oauth2.GrantType) (bool, error) {
+		info, err := clientStore.GetByID(context.Background(), clientID)
+		if err != nil || info == nil {
+			return false, errors.ErrInvalidClient
+		}
+
+		if client, ok := info.(*Client); ok {
+			return client.AllowsGrant(grant.String()), nil
+		}
+
+		return true, nil
+	}
+}
+
+// clientScopeHandler enforces the scopes of registered clients.
+func clientScopeHandler(
+	clientStore oauth2.ClientStore,
+) func(tgr *oauth2.TokenGenerateRequest) (bool, error) {
+	return func(tgr *oauth2.TokenGenerateRequest) (bool, error) {
+		info, err := clientStore.GetByID(context.Background(), tgr.ClientID)
+		if err != nil || info == nil {
+			return false, errors.ErrInvalidClient
+		}
+
+		if client, ok := info.(*Client); ok {
+			return client.AllowsScope(tgr.Scope), nil
+		}
+
+		return true, nil
+	}
+}
+
+// authorizeScopeHandler limits the issued scope to the ones we support.
+func authorizeScopeHandler(w http.ResponseWriter, r *http.Request) (scope string, err error) {
+	requested := r.FormValue("scope")
+	scope = NormalizeScope(requested)
+
+	if requested != "" && scope == "" {
+		err = errors.ErrInvalidScope
+	}
+
+	return
+}
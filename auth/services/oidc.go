@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"server/models"
+	"strings"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/generates"
+	"github.com/golang-jwt/jwt"
+)
+
+var ErrInvalidIDTokenHint = errors.New("invalid id_token_hint")
+
+var SupportedScopes = []string{"openid", "profile", "email"}
+
+var ScopeDescriptions = map[string]string{
+	"openid":  "Sign you in with your HomeTrainers.net account",
+	"profile": "See your name",
+	"email":   "See your email address",
+}
+
+// OIDCAuthorizeGenerate issues authorization codes like the default
+// generator and records the request's nonce against the code.
+type OIDCAuthorizeGenerate struct {
+	base      oauth2.AuthorizeGenerate
+	nonceRepo NonceRepository
+}
+
+func CreateOIDCAuthorizeGenerate(nonceRepo NonceRepository) *OIDCAuthorizeGenerate {
+	return &OIDCAuthorizeGenerate{generates.NewAuthorizeGenerate(), nonceRepo}
+}
+
+func (gen *OIDCAuthorizeGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic) (string, error) {
+	code, err := gen.base.Token(ctx, data)
+	if err != nil {
+		return "", err
+	}
+
+	if data.Request != nil {
+		if nonce := data.Request.FormValue("nonce"); nonce != "" {
+			gen.nonceRepo.SaveNonce(code, nonce)
+		}
+	}
+
+	return code, nil
+}
+
+func HasScope(scope string, name string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeScope drops unsupported and repeated scopes, keeping the order
+// they were requested in.
+func NormalizeScope(scope string) string {
+	var granted []string
+
+	for _, s := range strings.Fields(scope) {
+		if HasScope(strings.Join(SupportedScopes, " "), s) && !HasScope(strings.Join(granted, " "), s) {
+			granted = append(granted, s)
+		}
+	}
+
+	return strings.Join(granted, " ")
+}
+
+// ClaimsScope is the scope that decides which user claims clientID sees. Our
+// own frontend doesn't ask for scopes, since it never needs consent, and
+// sees them all.
+func ClaimsScope(clientID string, scope string) string {
+	if clientID == FirstPartyClientID() {
+		return strings.Join(SupportedScopes, " ")
+	}
+
+	return scope
+}
+
+// UserClaims returns the standard claims the granted scope allows. subject
+// comes from UserRepository.Subject.
+func UserClaims(user *models.User, subject string, scope string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": subject,
+	}
+
+	if HasScope(scope, "profile") {
+		claims["name"] = user.Name
+	}
+
+	if HasScope(scope, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.Validated
+	}
+
+	return claims
+}
+
+func CreateIDToken(
+	keys KeySetType,
+	issuer string,
+	ti oauth2.TokenInfo,
+	user *models.User,
+	subject string,
+	nonce string,
+	now time.Time,
+) (string, error) {
+	claims := jwt.MapClaims{
+		"iss": issuer,
+		"aud": ti.GetClientID(),
+		"iat": now.Unix(),
+		"exp": now.Add(ti.GetAccessExpiresIn()).Unix(),
+	}
+
+	for k, v := range UserClaims(user, subject, ti.GetScope()) {
+		claims[k] = v
+	}
+
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return SignClaims(keys, claims)
+}
+
+// ParseIDTokenHint verifies an id_token_hint we issued and returns its
+// claims. The expiry isn't checked, since hints are often sent long after
+// the ID token was issued.
+func ParseIDTokenHint(keys KeySetType, issuer string, hint string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+
+	_, err := parser.ParseWithClaims(hint, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		key, ok := keys.GetPublicKey(kid)
+		if !ok || token.Method.Alg() != key.Method.Alg() {
+			return nil, ErrInvalidIDTokenHint
+		}
+
+		return key.Public, nil
+	})
+
+	if err != nil || !claims.VerifyIssuer(issuer, true) {
+		return nil, ErrInvalidIDTokenHint
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, ErrInvalidIDTokenHint
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"server/users"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultPasswordMinLength  = 8
+	defaultPasswordMinClasses = 1
+
+	// bcrypt ignores anything past 72 bytes
+	bcryptMaxLength = 72
+)
+
+var (
+	ErrPasswordBreached = errors.New("This password has appeared in a data breach. Please choose a different password")
+	ErrPasswordPersonal = errors.New("Password must not contain your email or name")
+)
+
+// PasswordPolicy is checked whenever a password is set. Character classes
+// are lowercase, uppercase, digits and everything else. MaxLength is in
+// bytes, with 0 for no maximum.
+type PasswordPolicy struct {
+	MinLength  int
+	MaxLength  int
+	MinClasses int
+	Breached   *BreachedPasswords
+}
+
+// LoadPasswordPolicy reads PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH,
+// PASSWORD_MIN_CLASSES and BREACHED_PASSWORDS_FILE, the path of a breached
+// password list. The server still starts without the list, but logs that it
+// couldn't be loaded. The maximum only defaults to bcrypt's limit when new
+// passwords are hashed with bcrypt.
+func LoadPasswordPolicy(hashAlgorithm string) PasswordPolicy {
+	maxLength := 0
+	if hashAlgorithm == users.Bcrypt {
+		maxLength = bcryptMaxLength
+	}
+
+	policy := PasswordPolicy{
+		MinLength:  intEnv("PASSWORD_MIN_LENGTH", defaultPasswordMinLength),
+		MaxLength:  intEnv("PASSWORD_MAX_LENGTH", maxLength),
+		MinClasses: intEnv("PASSWORD_MIN_CLASSES", defaultPasswordMinClasses),
+	}
+
+	if hashAlgorithm == users.Bcrypt && (policy.MaxLength == 0 || policy.MaxLength > bcryptMaxLength) {
+		log.Printf("PASSWORD_MAX_LENGTH can't exceed %d with bcrypt", bcryptMaxLength)
+		policy.MaxLength = bcryptMaxLength
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := LoadBreachedPasswords(path)
+		if err != nil {
+			log.Printf("failed to load breached passwords from %s: %s", path, err.Error())
+		} else {
+			log.Printf("loaded %d breached passwords", breached.Len())
+			policy.Breached = breached
+		}
+	}
+
+	return policy
+}
+
+// Check returns an error describing the first rule the password breaks. The
+// messages are meant to be shown to the user.
+func (policy PasswordPolicy) Check(password string, email string, name string) error {
+	if len([]rune(password)) < policy.MinLength {
+		return fmt.Errorf("Password must be a minimum of %d characters", policy.MinLength)
+	}
+
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		return fmt.Errorf("Password must be a maximum of %d bytes. Accented letters and emoji count as more than one.", policy.MaxLength)
+	}
+
+	if passwordClasses(password) < policy.MinClasses {
+		return fmt.Errorf("Password must contain at least %d of lowercase letters, uppercase letters, numbers and symbols", policy.MinClasses)
+	}
+
+	if containsPersonalInfo(password, email, name) {
+		return ErrPasswordPersonal
+	}
+
+	if policy.Breached.Contains(password) {
+		return ErrPasswordBreached
+	}
+
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+// containsPersonalInfo checks for the email, its local part and each part
+// of the name. Short values are skipped since they'd match too many
+// passwords.
+func containsPersonalInfo(password string, email string, name string) bool {
+	password = strings.ToLower(password)
+
+	local, _, _ := strings.Cut(email, "@")
+
+	for _, value := range append([]string{email, local}, strings.Fields(name)...) {
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		if len(value) >= 4 && strings.Contains(password, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func intEnv(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		log.Printf("invalid %s %q, using %d", name, value, fallback)
+		return fallback
+	}
+
+	return parsed
+}
@@ -6,18 +6,34 @@ type ServiceProviderType interface {
 	GetClock() ClockType
 	GetSession() SessionApiType
 	GetUserRepo() UserRepository
+	GetConsentRepo() ConsentRepository
+	GetClientStore() ClientStore
+	GetTokenFamilyRepo() TokenFamilyRepository
+	GetAuditRepo() AuditRepository
 	GetOauthServer() OauthServerType
 	GetEmailService() EmailServiceType
+	GetBackendService() BackendServiceType
+	GetUpstreamService() UpstreamServiceType
 	GetCodeGenerator() CodeGeneratorType
+	GetKeySet() KeySetType
+	GetPasswordPolicy() PasswordPolicy
 }
 
 type ServiceProvider struct {
-	userRepo      UserRepository
-	session       SessionApiType
-	oauthServer   OauthServerType
-	emailService  EmailServiceType
-	codeGenerator CodeGeneratorType
-	clock         ClockType
+	userRepo        UserRepository
+	consentRepo     ConsentRepository
+	clientStore     ClientStore
+	families        TokenFamilyRepository
+	audit           AuditRepository
+	session         SessionApiType
+	oauthServer     OauthServerType
+	emailService    EmailServiceType
+	backendService  BackendServiceType
+	upstreamService UpstreamServiceType
+	codeGenerator   CodeGeneratorType
+	clock           ClockType
+	keySet          KeySetType
+	passwordPolicy  PasswordPolicy
 }
 
 func (provider *ServiceProvider) GetSession() SessionApiType {
@@ -26,36 +42,72 @@ func (provider *ServiceProvider) GetSession() SessionApiType {
 func (provider *ServiceProvider) GetUserRepo() UserRepository {
 	return provider.userRepo
 }
+func (provider *ServiceProvider) GetConsentRepo() ConsentRepository {
+	return provider.consentRepo
+}
+func (provider *ServiceProvider) GetClientStore() ClientStore {
+	return provider.clientStore
+}
+func (provider *ServiceProvider) GetTokenFamilyRepo() TokenFamilyRepository {
+	return provider.families
+}
+func (provider *ServiceProvider) GetAuditRepo() AuditRepository {
+	return provider.audit
+}
 func (provider *ServiceProvider) GetOauthServer() OauthServerType {
 	return provider.oauthServer
 }
 func (provider *ServiceProvider) GetEmailService() EmailServiceType {
 	return provider.emailService
 }
+func (provider *ServiceProvider) GetBackendService() BackendServiceType {
+	return provider.backendService
+}
+func (provider *ServiceProvider) GetUpstreamService() UpstreamServiceType {
+	return provider.upstreamService
+}
 func (provider *ServiceProvider) GetCodeGenerator() CodeGeneratorType {
 	return provider.codeGenerator
 }
 func (provider *ServiceProvider) GetClock() ClockType {
 	return provider.clock
 }
+func (provider *ServiceProvider) GetKeySet() KeySetType {
+	return provider.keySet
+}
+func (provider *ServiceProvider) GetPasswordPolicy() PasswordPolicy {
+	return provider.passwordPolicy
+}
 
 func CreateServiceProvider(
 	session SessionApiType,
 	db *gorm.DB,
 	oauthServer OauthServerType,
 	emailService EmailServiceType,
+	backendService BackendServiceType,
+	upstreamService UpstreamServiceType,
 	codeGenerator CodeGeneratorType,
 	clock ClockType,
+	keySet KeySetType,
+	passwordPolicy PasswordPolicy,
 ) ServiceProvider {
+	audit := CreateAuditRepo(db, clock)
+	families := CreateTokenFamilyRepo(db, clock, audit)
+
 	return ServiceProvider{
-		session:       session,
-		oauthServer:   oauthServer,
-		emailService:  emailService,
-		codeGenerator: codeGenerator,
-		clock:         clock,
-		userRepo: UserRepository{
-			db:    db,
-			clock: clock,
-		},
+		session:         session,
+		oauthServer:     oauthServer,
+		emailService:    emailService,
+		backendService:  backendService,
+		upstreamService: upstreamService,
+		codeGenerator:   codeGenerator,
+		clock:           clock,
+		keySet:          keySet,
+		passwordPolicy:  passwordPolicy,
+		userRepo:        CreateUserRepo(db, clock, families),
+		consentRepo:     CreateConsentRepo(db, clock, families),
+		clientStore:     CreateClientStore(db, clock, families),
+		families:        families,
+		audit:           audit,
 	}
 }
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net/url"
+	"server/models"
+	"sync"
+	"time"
+
+	"github.com/go-session/session"
+	"gorm.io/gorm"
+)
+
+func init() {
+	// values stored in sessions are gob encoded, so any non-basic types have
+	// to be registered
+	gob.Register(url.Values{})
+}
+
+// SessionStore is a go-session ManagerStore backed by the sessions table.
+// Rows are only written once a session is saved, so anonymous visits don't
+// create rows, and expired rows are removed on an interval.
+type SessionStore struct {
+	db     *gorm.DB
+	clock  ClockType
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+func CreateSessionStore(db *gorm.DB, clock ClockType, gcInterval time.Duration) *SessionStore {
+	store := &SessionStore{
+		db:    db,
+		clock: clock,
+		done:  make(chan struct{}),
+	}
+
+	if gcInterval > 0 {
+		store.ticker = time.NewTicker(gcInterval)
+		go store.gc()
+	}
+
+	return store
+}
+
+func (store *SessionStore) Check(ctx context.Context, sid string) (bool, error) {
+	var count int64
+	err := store.db.Model(&models.Session{}).
+		Where("id = ? and expires_at > ?", sid, store.clock.GetCurrentTime()).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+func (store *SessionStore) Create(ctx context.Context, sid string, expired int64) (session.Store, error) {
+	return store.newSession(ctx, sid, expired, map[string]interface{}{}), nil
+}
+
+func (store *SessionStore) Update(ctx context.Context, sid string, expired int64) (session.Store, error) {
+	values, err := store.load(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	store.db.Model(&models.Session{}).
+		Where("id = ?", sid).
+		Update("expires_at", store.expiresAt(expired))
+
+	return store.newSession(ctx, sid, expired, values), nil
+}
+
+func (store *SessionStore) Delete(ctx context.Context, sid string) error {
+	return store.db.Where("id = ?", sid).Delete(&models.Session{}).Error
+}
+
+func (store *SessionStore) Refresh(ctx context.Context, oldsid string, sid string, expired int64) (session.Store, error) {
+	values, err := store.load(oldsid)
+	if err != nil {
+		values = map[string]interface{}{}
+	}
+
+	store.Delete(ctx, oldsid)
+
+	refreshed := store.newSession(ctx, sid, expired, values)
+
+	return refreshed, refreshed.Save()
+}
+
+func (store *SessionStore) Close() error {
+	if store.ticker != nil {
+		store.ticker.Stop()
+		close(store.done)
+	}
+	return nil
+}
+
+func (store *SessionStore) DeleteExpired() {
+	store.db.Where("expires_at <= ?", store.clock.GetCurrentTime()).Delete(&models.Session{})
+}
+
+func (store *SessionStore) gc() {
+	for {
+		select {
+		case <-store.ticker.C:
+			store.DeleteExpired()
+		case <-store.done:
+			return
+		}
+	}
+}
+
+func (store *SessionStore) load(sid string) (map[string]interface{}, error) {
+	var row models.Session
+	if err := store.db.Where("id = ?", sid).First(&row).Error; err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+
+	if len(row.Data) > 0 {
+		if err := gob.NewDecoder(bytes.NewReader(row.Data)).Decode(&values); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func (store *SessionStore) expiresAt(expired int64) time.Time {
+	return store.clock.GetCurrentTime().Add(time.Duration(expired) * time.Second)
+}
+
+func (store *SessionStore) newSession(
+	ctx context.Context,
+	sid string,
+	expired int64,
+	values map[string]interface{},
+) *dbSession {
+	return &dbSession{
+		ctx:     ctx,
+		sid:     sid,
+		expired: expired,
+		values:  values,
+		store:   store,
+	}
+}
+
+type dbSession struct {
+	sync.RWMutex
+	ctx     context.Context
+	sid     string
+	expired int64
+	values  map[string]interface{}
+	store   *SessionStore
+}
+
+func (s *dbSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *dbSession) SessionID() string {
+	return s.sid
+}
+
+func (s *dbSession) Set(key string, value interface{}) {
+	s.Lock()
+	s.values[key] = value
+	s.Unlock()
+}
+
+func (s *dbSession) Get(key string) (interface{}, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *dbSession) Delete(key string) interface{} {
+	s.Lock()
+	defer s.Unlock()
+	value := s.values[key]
+	delete(s.values, key)
+	return value
+}
+
+func (s *dbSession) Save() error {
+	s.RLock()
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(s.values)
+	s.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return s.store.db.Save(&models.Session{
+		ID:        s.sid,
+		Data:      buf.Bytes(),
+		UserID:    s.userID(),
+		ExpiresAt: s.store.expiresAt(s.expired),
+	}).Error
+}
+
+// userID is the user the session belongs to, kept next to the encoded values
+// so that a user's sessions can be found when the account goes away.
+func (s *dbSession) userID() string {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, key := range []string{"LoggedInUserID", AuthenticatedUserKey, ConsentUserKey} {
+		if uid, ok := s.values[key].(string); ok && uid != "" {
+			return uid
+		}
+	}
+	return ""
+}
+
+func (s *dbSession) Flush() error {
+	s.Lock()
+	s.values = map[string]interface{}{}
+	s.Unlock()
+	return s.Save()
+}
@@ -2,7 +2,16 @@ package services
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/go-session/session"
 )
@@ -13,14 +22,169 @@ type SessionApiType interface {
 		writer http.ResponseWriter,
 		request *http.Request,
 	) (session.Store, error)
+	Sign(value string) string
+	SetCookie(writer http.ResponseWriter, cookie *http.Cookie)
 }
 
-type SessionApi struct{}
+type SessionConfig struct {
+	CookieName string
+	Secret     []byte
+	TTL        time.Duration
+	CookieTTL  time.Duration
+	Secure     bool
+	SameSite   string
+}
+
+// SessionApi starts sessions through its own manager. The zero value falls
+// back to go-session's global in-memory manager.
+type SessionApi struct {
+	manager *session.Manager
+	config  SessionConfig
+}
+
+func CreateSessionApi(store session.ManagerStore, config SessionConfig) *SessionApi {
+	manager := session.NewManager(
+		session.SetStore(store),
+		session.SetCookieName(config.CookieName),
+		session.SetSign(config.Secret),
+		session.SetExpired(int64(config.TTL/time.Second)),
+		session.SetCookieLifeTime(int(config.CookieTTL/time.Second)),
+		session.SetSecure(config.Secure),
+		session.SetEnableSIDInURLQuery(false),
+	)
+
+	return &SessionApi{manager, config}
+}
 
 func (s *SessionApi) Start(
 	context context.Context,
 	writer http.ResponseWriter,
 	request *http.Request,
 ) (session.Store, error) {
-	return session.Start(context, writer, request)
+	if s.manager == nil {
+		return session.Start(context, writer, request)
+	}
+
+	store, err := s.manager.Start(context, writer, request)
+
+	// a tampered or stale signature starts a fresh session instead of failing
+	if errors.Is(err, session.ErrInvalidSessionID) {
+		removeCookie(request, s.config.CookieName)
+		store, err = s.manager.Start(context, writer, request)
+	}
+
+	applyCookieAttributes(writer.Header(), s.config)
+
+	return store, err
+}
+
+// Sign authenticates value with the session secret, for cookies that have to
+// be trusted without a session behind them. The zero value signs with a key
+// that only lasts as long as the process.
+func (s *SessionApi) Sign(value string) string {
+	secret := s.config.Secret
+	if len(secret) == 0 {
+		secret = ephemeralSecret
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SetCookie sets a cookie with the same Secure and SameSite attributes as the
+// session cookie.
+func (s *SessionApi) SetCookie(writer http.ResponseWriter, cookie *http.Cookie) {
+	http.SetCookie(writer, cookie)
+
+	config := s.config
+	config.CookieName = cookie.Name
+	applyCookieAttributes(writer.Header(), config)
+}
+
+var ephemeralSecret = func() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}()
+
+// LoadSessionConfig reads SESSION_SECRET, SESSION_TTL, SESSION_COOKIE_TTL,
+// SESSION_COOKIE_SECURE and SESSION_SAMESITE. Outside of production a random
+// secret is generated when none is configured.
+func LoadSessionConfig() (SessionConfig, error) {
+	prod := os.Getenv("ENVIRONMENT") == "PROD"
+
+	config := SessionConfig{
+		CookieName: "hpt_auth_session",
+		TTL:        durationEnv("SESSION_TTL", time.Hour*2),
+		CookieTTL:  durationEnv("SESSION_COOKIE_TTL", time.Hour*24*7),
+		Secure:     prod,
+		SameSite:   "Lax",
+	}
+
+	if secure := os.Getenv("SESSION_COOKIE_SECURE"); secure != "" {
+		config.Secure = secure == "true"
+	}
+
+	switch sameSite := os.Getenv("SESSION_SAMESITE"); sameSite {
+	case "":
+	case "Lax", "Strict", "None":
+		config.SameSite = sameSite
+	default:
+		return config, errors.New("SESSION_SAMESITE must be Lax, Strict or None")
+	}
+
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		config.Secret = []byte(secret)
+	} else if prod {
+		return config, errors.New("SESSION_SECRET is required")
+	} else {
+		log.Println("SESSION_SECRET not set, generating an ephemeral session secret")
+		config.Secret = make([]byte, 32)
+		rand.Read(config.Secret)
+	}
+
+	return config, nil
+}
+
+// go-session can't set SameSite and only marks cookies Secure when the
+// request itself arrived over TLS, which isn't the case behind Cloud Run's
+// proxy, so both attributes are applied to the Set-Cookie header here.
+func applyCookieAttributes(header http.Header, config SessionConfig) {
+	cookies := header["Set-Cookie"]
+
+	for i, cookie := range cookies {
+		if !strings.HasPrefix(cookie, config.CookieName+"=") {
+			continue
+		}
+
+		attributes := []string{}
+		for _, attribute := range strings.Split(cookie, "; ") {
+			if attribute == "Secure" || strings.HasPrefix(attribute, "SameSite=") {
+				continue
+			}
+			attributes = append(attributes, attribute)
+		}
+
+		if config.Secure {
+			attributes = append(attributes, "Secure")
+		}
+		if config.SameSite != "" {
+			attributes = append(attributes, "SameSite="+config.SameSite)
+		}
+
+		cookies[i] = strings.Join(attributes, "; ")
+	}
+}
+
+func removeCookie(request *http.Request, name string) {
+	cookies := request.Cookies()
+
+	request.Header.Del("Cookie")
+
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			request.AddCookie(cookie)
+		}
+	}
 }
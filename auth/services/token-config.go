@@ -0,0 +1,120 @@
+package services
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultAccessTokenExp = time.Hour * 2
+const defaultRefreshTokenExp = time.Hour * 24 * 3
+
+// LoadTokenLifetimes reads ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL as Go
+// durations, e.g. "15m" or "720h".
+func LoadTokenLifetimes() (time.Duration, time.Duration) {
+	return durationEnv("ACCESS_TOKEN_TTL", defaultAccessTokenExp),
+		durationEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenExp)
+}
+
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("invalid %s %q, using %s", name, value, fallback)
+		return fallback
+	}
+
+	return duration
+}
+
+// TrustedProxies reads TRUSTED_PROXIES, a comma separated list of the
+// addresses or CIDR ranges of the proxies in front of the server, e.g. the
+// load balancer. Forwarded headers from anyone else are ignored.
+func TrustedProxies() []string {
+	proxies := []string{}
+
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// ClientIP is the address of the client, read from X-Forwarded-For only when
+// the request came through a trusted proxy. The header is walked from the
+// right, skipping trusted hops, since only those entries were added by
+// proxies rather than the client.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	trusted := trustedNetworks()
+
+	if !isTrusted(trusted, host) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		if !isTrusted(trusted, hop) {
+			return hop
+		}
+	}
+
+	return host
+}
+
+func trustedNetworks() []*net.IPNet {
+	networks := []*net.IPNet{}
+
+	for _, proxy := range TrustedProxies() {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			networks = append(networks, network)
+		} else {
+			log.Printf("ignoring invalid trusted proxy %q", proxy)
+		}
+	}
+
+	return networks
+}
+
+func isTrusted(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
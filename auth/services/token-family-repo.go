@@ -0,0 +1,190 @@
+package services
+
+import (
+	"server/models"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	TokenIssued  = "refresh_token_issued"
+	TokenRotated = "refresh_token_rotated"
+	TokenReused  = "refresh_token_reuse_detected"
+	TokenRevoked = "token_family_revoked"
+)
+
+const (
+	ClientDisabled  = "client_disabled"
+	UserLoggedOut   = "user_logged_out"
+	PasswordChanged = "password_changed"
+	PasswordReset   = "password_reset"
+	EmailChanged    = "email_changed"
+	AccountDeleted  = "account_deleted"
+	UserDisabled    = "user_disabled"
+	ResetRequired   = "password_reset_required"
+)
+
+type TokenFamilyRepository struct {
+	db    *gorm.DB
+	clock ClockType
+	audit AuditRepository
+}
+
+func CreateTokenFamilyRepo(db *gorm.DB, clock ClockType, audit AuditRepository) TokenFamilyRepository {
+	return TokenFamilyRepository{db, clock, audit}
+}
+
+func (repo *TokenFamilyRepository) StartFamily(ti oauth2.TokenInfo, ip string) {
+	family := models.TokenFamily{
+		ID:        RandomToken(16),
+		UserID:    ti.GetUserID(),
+		ClientID:  ti.GetClientID(),
+		CreatedAt: repo.clock.GetCurrentTime(),
+	}
+
+	repo.db.Create(&family)
+	repo.addToken(family.ID, ti.GetRefresh())
+	repo.addEvent(&family, TokenIssued, ip)
+}
+
+// ClaimRefreshToken marks the token as used. claim is empty when it had
+// already been used, which means it was replayed.
+func (repo *TokenFamilyRepository) ClaimRefreshToken(refresh string) (*models.TokenFamily, string, error) {
+	family, err := repo.findFamily(refresh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	claim := RandomToken(16)
+
+	result := repo.db.Model(&models.RefreshToken{}).
+		Where("token_hash = ? and used = ?", HashToken(refresh), false).
+		Updates(map[string]interface{}{"used": true, "claim_id": claim})
+
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return family, "", nil
+	}
+
+	return family, claim, nil
+}
+
+// ReleaseRefreshToken undoes ClaimRefreshToken when the exchange failed, so
+// the client's retry isn't mistaken for reuse. Only the claim that was made
+// is undone, never one made by another request since.
+func (repo *TokenFamilyRepository) ReleaseRefreshToken(refresh string, claim string) {
+	repo.db.Model(&models.RefreshToken{}).
+		Where("token_hash = ? and used = ? and claim_id = ?", HashToken(refresh), true, claim).
+		Updates(map[string]interface{}{"used": false, "claim_id": ""})
+}
+
+func (repo *TokenFamilyRepository) Rotate(family *models.TokenFamily, ti oauth2.TokenInfo, ip string) {
+	repo.addToken(family.ID, ti.GetRefresh())
+	repo.addEvent(family, TokenRotated, ip)
+}
+
+func (repo *TokenFamilyRepository) RevokeFamily(family *models.TokenFamily, reason string, ip string) {
+	family.Revoked = true
+	family.RevokedAt = repo.clock.GetCurrentTime()
+	family.RevokedReason = reason
+
+	repo.db.Save(family)
+	repo.addEvent(family, reason, ip)
+
+	repo.audit.Record(models.AuditEvent{
+		Type:      AuditTokenRevoked,
+		UserID:    family.UserID,
+		ClientID:  family.ClientID,
+		IPAddress: ip,
+		Detail:    reason,
+	})
+}
+
+func (repo *TokenFamilyRepository) RevokeByRefresh(refresh string, reason string, ip string) {
+	if family, err := repo.findFamily(refresh); err == nil && !family.Revoked {
+		repo.RevokeFamily(family, reason, ip)
+	}
+}
+
+func (repo *TokenFamilyRepository) RevokeClient(userID string, clientID string, reason string, ip string) {
+	var families []models.TokenFamily
+	repo.db.Where("user_id = ? and client_id = ? and revoked = ?", userID, clientID, false).Find(&families)
+
+	for i := range families {
+		repo.RevokeFamily(&families[i], reason, ip)
+	}
+}
+
+func (repo *TokenFamilyRepository) RevokeUser(userID string, reason string, ip string) {
+	var families []models.TokenFamily
+	repo.db.Where("user_id = ? and revoked = ?", userID, false).Find(&families)
+
+	for i := range families {
+		repo.RevokeFamily(&families[i], reason, ip)
+	}
+}
+
+func (repo *TokenFamilyRepository) RevokeAllForClient(clientID string, reason string, ip string) {
+	var families []models.TokenFamily
+	repo.db.Where("client_id = ? and revoked = ?", clientID, false).Find(&families)
+
+	for i := range families {
+		repo.RevokeFamily(&families[i], reason, ip)
+	}
+}
+
+func (repo *TokenFamilyRepository) IsRevoked(refresh string) bool {
+	if refresh == "" {
+		return false
+	}
+
+	family, err := repo.findFamily(refresh)
+
+	return err == nil && family.Revoked
+}
+
+func (repo *TokenFamilyRepository) GetEvents(familyID string) []models.TokenEvent {
+	var events []models.TokenEvent
+	repo.db.Where("family_id = ?", familyID).Order("id").Find(&events)
+	return events
+}
+
+func (repo *TokenFamilyRepository) findFamily(refresh string) (*models.TokenFamily, error) {
+	var token models.RefreshToken
+	if err := repo.db.Where("token_hash = ?", HashToken(refresh)).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	var family *models.TokenFamily
+	if err := repo.db.Where("id = ?", token.FamilyID).First(&family).Error; err != nil {
+		return nil, err
+	}
+
+	return family, nil
+}
+
+func (repo *TokenFamilyRepository) addToken(familyID string, refresh string) {
+	if refresh == "" {
+		return
+	}
+
+	repo.db.Create(&models.RefreshToken{
+		TokenHash: HashToken(refresh),
+		FamilyID:  familyID,
+		CreatedAt: repo.clock.GetCurrentTime(),
+	})
+}
+
+func (repo *TokenFamilyRepository) addEvent(family *models.TokenFamily, event string, ip string) {
+	repo.db.Create(&models.TokenEvent{
+		FamilyID:  family.ID,
+		Event:     event,
+		UserID:    family.UserID,
+		ClientID:  family.ClientID,
+		IPAddress: ip,
+	})
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/server"
+)
+
+// HandleRevocationRequest implements RFC 7009. Unknown tokens and tokens
+// issued to another client are ignored and still answered with a 200.
+func (oauth *OauthServer) HandleRevocationRequest(w http.ResponseWriter, r *http.Request) error {
+	client, err := oauth.authenticateClient(r)
+	if err != nil {
+		return oauth.tokenError(w, err)
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return oauth.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	ti, _ := oauth.loadToken(r, token, r.PostForm.Get("token_type_hint"))
+
+	if ti != nil && ti.GetClientID() == client.GetID() {
+		ctx := r.Context()
+
+		if access := ti.GetAccess(); access != "" {
+			oauth.server.Manager.RemoveAccessToken(ctx, access)
+		}
+		if refresh := ti.GetRefresh(); refresh != "" {
+			oauth.server.Manager.RemoveRefreshToken(ctx, refresh)
+			oauth.families.RevokeByRefresh(refresh, TokenRevoked, ClientIP(r))
+		} else {
+			// revoking a refresh token records its family's revocation
+			oauth.audit.RecordRequest(r, AuditTokenRevoked, ti.GetUserID(), ti.GetClientID(), TokenRevoked)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// HandleIntrospectionRequest implements RFC 7662 for any authenticated
+// confidential client, so resource servers can check tokens issued to the
+// frontend.
+func (oauth *OauthServer) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) error {
+	client, err := oauth.authenticateClient(r)
+	if err != nil {
+		return oauth.tokenError(w, err)
+	}
+
+	if client.IsPublic() {
+		return oauth.tokenError(w, errors.ErrUnauthorizedClient)
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		return oauth.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	data := map[string]interface{}{"active": false}
+
+	ti, isRefresh := oauth.loadToken(r, token, r.PostForm.Get("token_type_hint"))
+
+	if ti != nil && !oauth.families.IsRevoked(ti.GetRefresh()) {
+		if user, userErr := oauth.userRepo.GetUser(ti.GetUserID()); userErr == nil {
+			clientID := ti.GetClientID()
+
+			data = introspectionData(ti, isRefresh)
+
+			// the same user claims the client's own tokens carry
+			for k, v := range UserClaims(user, oauth.userRepo.Subject(user, clientID), ClaimsScope(clientID, ti.GetScope())) {
+				data[k] = v
+			}
+
+			if user.Admin && ti.GetClientID() == FirstPartyClientID() {
+				data["roles"] = []string{AdminRole}
+			}
+		}
+	}
+
+	return writeTokenResponse(w, data, nil, http.StatusOK)
+}
+
+func introspectionData(ti oauth2.TokenInfo, isRefresh bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"active":    true,
+		"client_id": ti.GetClientID(),
+		"aud":       ti.GetClientID(),
+		"iss":       GetHost(),
+	}
+
+	if scope := ti.GetScope(); scope != "" {
+		data["scope"] = scope
+	}
+
+	if isRefresh {
+		data["token_type"] = "refresh_token"
+		data["iat"] = ti.GetRefreshCreateAt().Unix()
+		if expiresIn := ti.GetRefreshExpiresIn(); expiresIn != 0 {
+			data["exp"] = ti.GetRefreshCreateAt().Add(expiresIn).Unix()
+		}
+	} else {
+		data["token_type"] = "Bearer"
+		data["iat"] = ti.GetAccessCreateAt().Unix()
+		data["exp"] = ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix()
+	}
+
+	return data
+}
+
+// loadToken looks the token up as the hinted type first and falls back to
+// the other type, as both RFCs require.
+func (oauth *OauthServer) loadToken(r *http.Request, token string, hint string) (oauth2.TokenInfo, bool) {
+	ctx := r.Context()
+	manager := oauth.server.Manager
+
+	if hint == "refresh_token" {
+		if ti, err := manager.LoadRefreshToken(ctx, token); err == nil {
+			return ti, true
+		}
+		if ti, err := manager.LoadAccessToken(ctx, token); err == nil {
+			return ti, false
+		}
+		return nil, false
+	}
+
+	if ti, err := manager.LoadAccessToken(ctx, token); err == nil {
+		return ti, false
+	}
+	if ti, err := manager.LoadRefreshToken(ctx, token); err == nil {
+		return ti, true
+	}
+	return nil, false
+}
+
+func (oauth *OauthServer) authenticateClient(r *http.Request) (oauth2.ClientInfo, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.ErrInvalidRequest
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, errors.ErrInvalidRequest
+	}
+
+	clientID, clientSecret, err := server.ClientBasicHandler(r)
+	if err != nil {
+		clientID, clientSecret, err = server.ClientFormHandler(r)
+	}
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+
+	client, err := oauth.server.Manager.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errors.ErrInvalidClient
+	}
+
+	if !VerifyClientSecret(client, clientSecret) {
+		return nil, errors.ErrInvalidClient
+	}
+
+	return client, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"crypto/rand"
+	"math/big"
+	"server/models"
+	"strings"
+)
+
+const recoveryCodeCount = 10
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// EnableTOTP turns on two-factor authentication with a confirmed secret and
+// returns a fresh set of recovery codes. Only their hashes are stored.
+func (repo *UserRepository) EnableTOTP(user *models.User, secret string, step int64) []string {
+	user.TOTPSecret = secret
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	repo.db.Save(&user)
+
+	repo.db.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{})
+
+	codes := []string{}
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := generateRecoveryCode()
+		codes = append(codes, code)
+
+		repo.db.Create(&models.RecoveryCode{
+			UserID:   user.ID,
+			CodeHash: HashToken(code),
+		})
+	}
+
+	return codes
+}
+
+func (repo *UserRepository) VerifyTOTP(user *models.User, code string) bool {
+	if !user.TOTPEnabled {
+		return false
+	}
+
+	step, ok := ValidateTOTP(user.TOTPSecret, code, repo.clock.GetCurrentTime(), user.TOTPLastStep)
+	if !ok {
+		return false
+	}
+
+	user.TOTPLastStep = step
+	repo.db.Save(&user)
+
+	return true
+}
+
+func (repo *UserRepository) UseRecoveryCode(user *models.User, code string) bool {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+
+	result := repo.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? and code_hash = ? and used = ?", user.ID, HashToken(code), false).
+		Update("used", true)
+
+	return result.RowsAffected == 1
+}
+
+func generateRecoveryCode() string {
+	code := make([]byte, 10)
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, max)
+		code[i] = recoveryCodeAlphabet[n.Int64()]
+	}
+
+	return string(code)
+}
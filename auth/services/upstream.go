@@ -0,0 +1,350 @@
+package services
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrUnknownUpstreamKey   = errors.New("unknown upstream signing key")
+	ErrInvalidUpstreamToken = errors.New("invalid upstream id token")
+)
+
+// UpstreamProvider is an OIDC provider users can sign in with instead of a
+// password, e.g. Google.
+type UpstreamProvider struct {
+	ID           string
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// UpstreamIdentity holds the claims we use from an upstream ID token.
+type UpstreamIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type UpstreamServiceType interface {
+	GetProviders() []UpstreamProvider
+	GetProvider(id string) (UpstreamProvider, bool)
+	AuthCodeURL(provider UpstreamProvider, redirectURI string, state string, nonce string, verifier string) (string, error)
+	Exchange(provider UpstreamProvider, redirectURI string, code string, verifier string, nonce string) (*UpstreamIdentity, error)
+}
+
+// LoadUpstreamProviders reads UPSTREAM_PROVIDERS as a comma separated list of
+// ids. Each is configured with UPSTREAM_<ID>_ISSUER, UPSTREAM_<ID>_CLIENT_ID,
+// UPSTREAM_<ID>_CLIENT_SECRET and optionally UPSTREAM_<ID>_NAME.
+func LoadUpstreamProviders() []UpstreamProvider {
+	var providers []UpstreamProvider
+
+	for _, id := range strings.Split(os.Getenv("UPSTREAM_PROVIDERS"), ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+
+		if id == "" {
+			continue
+		}
+
+		prefix := fmt.Sprintf("UPSTREAM_%s_", strings.ToUpper(id))
+
+		provider := UpstreamProvider{
+			ID:           id,
+			Name:         os.Getenv(prefix + "NAME"),
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		}
+
+		if provider.Issuer == "" || provider.ClientID == "" {
+			continue
+		}
+
+		if provider.Name == "" {
+			provider.Name = strings.ToUpper(id[:1]) + id[1:]
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type upstreamMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	keys                  map[string]crypto.PublicKey
+	fetchedAt             time.Time
+}
+
+// UpstreamService talks to upstream providers using their discovery
+// documents, which are cached for an hour along with their signing keys.
+type UpstreamService struct {
+	providers []UpstreamProvider
+	clock     ClockType
+	client    *http.Client
+	mu        sync.Mutex
+	metadata  map[string]*upstreamMetadata
+}
+
+func CreateUpstreamService(providers []UpstreamProvider, clock ClockType) *UpstreamService {
+	return &UpstreamService{
+		providers: providers,
+		clock:     clock,
+		client:    &http.Client{Timeout: time.Second * 10},
+		metadata:  map[string]*upstreamMetadata{},
+	}
+}
+
+func (service *UpstreamService) GetProviders() []UpstreamProvider {
+	return service.providers
+}
+
+func (service *UpstreamService) GetProvider(id string) (UpstreamProvider, bool) {
+	for _, provider := range service.providers {
+		if provider.ID == id {
+			return provider, true
+		}
+	}
+	return UpstreamProvider{}, false
+}
+
+func (service *UpstreamService) AuthCodeURL(
+	provider UpstreamProvider,
+	redirectURI string,
+	state string,
+	nonce string,
+	verifier string,
+) (string, error) {
+	metadata, err := service.getMetadata(provider)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallengeS256(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	return metadata.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and verifies the ID token that
+// comes back with it.
+func (service *UpstreamService) Exchange(
+	provider UpstreamProvider,
+	redirectURI string,
+	code string,
+	verifier string,
+	nonce string,
+) (*UpstreamIdentity, error) {
+	metadata, err := service.getMetadata(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	res, err := service.client.PostForm(metadata.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s token endpoint responded with %d", provider.ID, res.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+
+	return service.verifyIDToken(provider, metadata, tokens.IDToken, nonce)
+}
+
+func (service *UpstreamService) verifyIDToken(
+	provider UpstreamProvider,
+	metadata *upstreamMetadata,
+	idToken string,
+	nonce string,
+) (*UpstreamIdentity, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384"},
+
+		// checked below against our clock, and required rather than
+		// only checked when present
+		SkipClaimsValidation: true,
+	}
+
+	_, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return service.getKey(provider, kid)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(metadata.Issuer, true) || !claims.VerifyAudience(provider.ClientID, true) {
+		return nil, ErrInvalidUpstreamToken
+	}
+
+	now := service.clock.GetCurrentTime().Unix()
+
+	if !claims.VerifyExpiresAt(now, true) || !claims.VerifyIssuedAt(now, true) || !claims.VerifyNotBefore(now, false) {
+		return nil, ErrInvalidUpstreamToken
+	}
+
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, ErrInvalidUpstreamToken
+	}
+
+	identity := &UpstreamIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+
+	// some providers send email_verified as a string
+	identity.EmailVerified = fmt.Sprint(claims["email_verified"]) == "true"
+
+	if identity.Subject == "" {
+		return nil, ErrInvalidUpstreamToken
+	}
+
+	return identity, nil
+}
+
+func (service *UpstreamService) getMetadata(provider UpstreamProvider) (*upstreamMetadata, error) {
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	now := service.clock.GetCurrentTime()
+
+	if metadata, ok := service.metadata[provider.ID]; ok && now.Before(metadata.fetchedAt.Add(time.Hour)) {
+		return metadata, nil
+	}
+
+	metadata := &upstreamMetadata{}
+
+	if err := service.getJSON(provider.Issuer+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("%s discovery document has issuer %s", provider.ID, metadata.Issuer)
+	}
+
+	if err := service.loadKeys(metadata); err != nil {
+		return nil, err
+	}
+
+	metadata.fetchedAt = now
+	service.metadata[provider.ID] = metadata
+
+	return metadata, nil
+}
+
+// getKey refetches the provider's keys once when the kid isn't known, since
+// providers rotate keys more often than the cache expires.
+func (service *UpstreamService) getKey(provider UpstreamProvider, kid string) (crypto.PublicKey, error) {
+	metadata, err := service.getMetadata(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if key, ok := metadata.keys[kid]; ok {
+		return key, nil
+	}
+
+	if err := service.loadKeys(metadata); err != nil {
+		return nil, err
+	}
+
+	if key, ok := metadata.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownUpstreamKey
+}
+
+func (service *UpstreamService) loadKeys(metadata *upstreamMetadata) error {
+	var jwks JWKS
+
+	if err := service.getJSON(metadata.JWKSURI, &jwks); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	metadata.keys = keys
+
+	return nil
+}
+
+func (service *UpstreamService) getJSON(endpoint string, value interface{}) error {
+	res, err := service.client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", endpoint, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(value)
+}
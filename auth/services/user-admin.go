@@ -0,0 +1,56 @@
+package services
+
+import (
+	"server/models"
+	"strings"
+)
+
+// SearchUsers pages through users whose email or name contains query,
+// newest first, returning the page and the total number of matches.
+func (repo *UserRepository) SearchUsers(query string, offset int, limit int) ([]models.User, int64) {
+	var users []models.User
+	var total int64
+
+	db := repo.db.Model(&models.User{})
+
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := "%" + strings.ToLower(query) + "%"
+		db = db.Where("lower(email) like ? or lower(name) like ?", pattern, pattern)
+	}
+
+	db.Count(&total)
+	db.Order("id desc").Offset(offset).Limit(limit).Find(&users)
+
+	return users, total
+}
+
+// GetUserByID looks up a user without purging expired pending accounts, so
+// admins can still see and validate them.
+func (repo *UserRepository) GetUserByID(id uint) (*models.User, error) {
+	var user *models.User
+	if err := repo.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetDisabled blocks or restores sign in. Disabling also revokes the user's
+// refresh tokens.
+func (repo *UserRepository) SetDisabled(user *models.User, disabled bool, ip string) {
+	user.Disabled = disabled
+	repo.db.Save(&user)
+
+	if disabled {
+		repo.families.RevokeUser(user.Email, UserDisabled, ip)
+	}
+}
+
+// ForcePasswordReset stops password sign in until the password is reset
+// with code, and revokes the user's refresh tokens. The code is stored the
+// same way as a self-service reset code.
+func (repo *UserRepository) ForcePasswordReset(user *models.User, code string, ip string) {
+	user.ResetRequired = true
+	repo.UpdateResetCode(user, code)
+
+	repo.families.RevokeUser(user.Email, ResetRequired, ip)
+}
@@ -1,20 +1,74 @@
 package services
 
 import (
+	"crypto/subtle"
 	"errors"
+	"os"
 	"server/models"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidCode = errors.New("invalid validation code")
+	ErrCodeExpired = errors.New("validation code expired")
 )
 
 type UserRepository struct {
-	db    *gorm.DB
-	clock ClockType
+	db       *gorm.DB
+	clock    ClockType
+	families TokenFamilyRepository
+}
+
+func CreateUserRepo(db *gorm.DB, clock ClockType, families TokenFamilyRepository) UserRepository {
+	return UserRepository{db, clock, families}
 }
 
-func CreateUserRepo(db *gorm.DB, clock ClockType) UserRepository {
-	return UserRepository{db, clock}
+// Subject is the user's sub claim for clientID. Our own frontend and backend
+// identify users by email, other clients each get their own opaque identifier
+// and only see the email with the email scope.
+func (repo *UserRepository) Subject(user *models.User, clientID string) string {
+	if clientID == FirstPartyClientID() {
+		return user.Email
+	}
+
+	var pairwise models.PairwiseSubject
+	if repo.db.Where("user_id = ? and client_id = ?", user.ID, clientID).First(&pairwise).Error == nil {
+		return pairwise.Subject
+	}
+
+	// only the first of two concurrent requests gets to create it
+	repo.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.PairwiseSubject{
+		Subject:   RandomToken(16),
+		UserID:    user.ID,
+		ClientID:  clientID,
+		CreatedAt: repo.clock.GetCurrentTime(),
+	})
+
+	repo.db.Where("user_id = ? and client_id = ?", user.ID, clientID).First(&pairwise)
+
+	return pairwise.Subject
+}
+
+// GetUserBySubject reverses Subject for a sub claim issued to clientID.
+func (repo *UserRepository) GetUserBySubject(subject string, clientID string) (*models.User, error) {
+	if clientID == FirstPartyClientID() {
+		return repo.GetUser(subject)
+	}
+
+	var pairwise models.PairwiseSubject
+	if err := repo.db.Where("subject = ? and client_id = ?", subject, clientID).First(&pairwise).Error; err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if err := repo.db.First(&user, pairwise.UserID).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 func (repo *UserRepository) GetUser(email string) (*models.User, error) {
@@ -30,21 +84,109 @@ func (repo *UserRepository) GetUser(email string) (*models.User, error) {
 	return user, nil
 }
 
-func (repo *UserRepository) CreateUser(user models.User) {
+// GetPendingUser looks up a user without purging pending accounts whose
+// validation code has expired, so a new code can still be requested.
+func (repo *UserRepository) GetPendingUser(email string) (*models.User, error) {
+	var user *models.User
+	if err := repo.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (repo *UserRepository) CreateUser(user models.User, code string) {
+	user.ValidationCode = HashToken(code)
 	user.CodeExpiration = getExpiry(repo.clock)
 	repo.db.Create(&user)
 }
 
 func (repo *UserRepository) ActivateUser(user *models.User) {
 	user.Validated = true
+	user.ValidationCode = ""
 	repo.db.Save(&user)
 }
 
-func (repo *UserRepository) UpdateCode(user *models.User) {
+func (repo *UserRepository) UpdateCode(user *models.User, code string) {
+	user.ValidationCode = HashToken(code)
 	user.CodeExpiration = getExpiry(repo.clock)
 	repo.db.Save(&user)
 }
 
+func (repo *UserRepository) ValidateCode(user *models.User, code string) error {
+	if code == "" || user.ValidationCode == "" {
+		return ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashToken(code)), []byte(user.ValidationCode)) != 1 {
+		return ErrInvalidCode
+	}
+
+	if !repo.clock.GetCurrentTime().Before(user.CodeExpiration) {
+		return ErrCodeExpired
+	}
+
+	return nil
+}
+
+func (repo *UserRepository) UpdateResetCode(user *models.User, code string) {
+	user.ResetCode = HashToken(code)
+	user.ResetExpiration = repo.clock.AddTime(repo.clock.GetCurrentTime(), 1, 0, 0)
+	repo.db.Save(&user)
+}
+
+func (repo *UserRepository) ValidResetCode(user *models.User, code string) bool {
+	if code == "" || user.ResetCode == "" {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashToken(code)), []byte(user.ResetCode)) != 1 {
+		return false
+	}
+
+	return repo.clock.GetCurrentTime().Before(user.ResetExpiration)
+}
+
+// ResetPassword saves the new password hash, uses up the reset code and
+// revokes the refresh tokens issued before the reset.
+func (repo *UserRepository) ResetPassword(user *models.User, hash string, ip string) {
+	user.Password = hash
+	user.ResetCode = ""
+	user.ResetExpiration = time.Time{}
+	user.ResetRequired = false
+	repo.db.Save(&user)
+
+	repo.families.RevokeUser(user.Email, PasswordReset, ip)
+}
+
+// UpdatePasswordHash replaces the hash of an unchanged password, e.g. when
+// the hash parameters have changed. Unlike ChangePassword, tokens are kept.
+func (repo *UserRepository) UpdatePasswordHash(user *models.User, hash string) {
+	user.Password = hash
+	repo.db.Model(&user).Update("password", hash)
+}
+
+// AdminEmails reads ADMIN_EMAILS as a comma separated list.
+func AdminEmails() []string {
+	var emails []string
+
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	return emails
+}
+
+// PromoteAdmins gives the users with the given emails admin access.
+func (repo *UserRepository) PromoteAdmins(emails []string) {
+	if len(emails) == 0 {
+		return
+	}
+
+	repo.db.Model(&models.User{}).Where("email in ?", emails).Update("admin", true)
+}
+
 func getExpiry(clock ClockType) time.Time {
 	return clock.AddTime(clock.GetCurrentTime(), 24, 0, 0)
 }
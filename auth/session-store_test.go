@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStorePersistsValues(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	ctx := context.Background()
+	mockClock := &mocks.MockClock{Time: now}
+
+	store := services.CreateSessionStore(db, mockClock, 0)
+
+	created, _ := store.Create(ctx, "session-1", 60)
+
+	exists, _ := store.Check(ctx, "session-1")
+	assert.False(t, exists)
+
+	form := url.Values{"client_id": {"222222"}}
+
+	created.Set("LoggedInUserID", testUser)
+	created.Set("ReturnUri", form)
+	assert.Nil(t, created.Save())
+
+	// a new store instance stands in for another server instance
+	other := services.CreateSessionStore(db, mockClock, 0)
+
+	exists, _ = other.Check(ctx, "session-1")
+	assert.True(t, exists)
+
+	loaded, err := other.Update(ctx, "session-1", 60)
+	assert.Nil(t, err)
+
+	user, _ := loaded.Get("LoggedInUserID")
+	returnUri, _ := loaded.Get("ReturnUri")
+
+	assert.Equal(t, testUser, user)
+	assert.Equal(t, form, returnUri.(url.Values))
+
+	refreshed, _ := other.Refresh(ctx, "session-1", "session-2", 60)
+	refreshedUser, _ := refreshed.Get("LoggedInUserID")
+
+	assert.Equal(t, testUser, refreshedUser)
+
+	exists, _ = other.Check(ctx, "session-1")
+	assert.False(t, exists)
+}
+
+func TestSessionStoreExpiry(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	ctx := context.Background()
+	mockClock := &mocks.MockClock{Time: now}
+
+	store := services.CreateSessionStore(db, mockClock, 0)
+
+	created, _ := store.Create(ctx, "session-1", 60)
+	created.Save()
+
+	mockClock.Time = now.Add(time.Minute * 2)
+
+	exists, _ := store.Check(ctx, "session-1")
+	assert.False(t, exists)
+
+	store.DeleteExpired()
+
+	var count int64
+	db.Model(&models.Session{}).Count(&count)
+
+	assert.Equal(t, int64(0), count)
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	db := Setup()
+
+	defer Teardown(db)
+
+	store := services.CreateSessionStore(db, &services.Clock{}, 0)
+
+	sessionApi := services.CreateSessionApi(store, services.SessionConfig{
+		CookieName: "test_session",
+		Secret:     []byte("secret"),
+		TTL:        time.Hour,
+		CookieTTL:  time.Hour,
+		Secure:     true,
+		SameSite:   "Lax",
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+
+	started, err := sessionApi.Start(context.Background(), w, req)
+	assert.Nil(t, err)
+
+	started.Set("LoggedInUserID", testUser)
+	started.Save()
+
+	setCookie := w.Header().Get("Set-Cookie")
+
+	assert.True(t, strings.HasPrefix(setCookie, "test_session="))
+	assert.Contains(t, setCookie, "HttpOnly")
+	assert.Contains(t, setCookie, "Secure")
+	assert.Contains(t, setCookie, "SameSite=Lax")
+
+	cookie := w.Result().Cookies()[0]
+
+	next, _ := http.NewRequest("GET", "/auth", nil)
+	next.AddCookie(cookie)
+
+	resumed, _ := sessionApi.Start(context.Background(), httptest.NewRecorder(), next)
+	user, _ := resumed.Get("LoggedInUserID")
+
+	assert.Equal(t, testUser, user)
+
+	forged, _ := http.NewRequest("GET", "/auth", nil)
+	forged.AddCookie(&http.Cookie{Name: "test_session", Value: strings.Split(cookie.Value, ".")[0] + ".forged"})
+
+	fresh, forgedErr := sessionApi.Start(context.Background(), httptest.NewRecorder(), forged)
+
+	assert.Nil(t, forgedErr)
+	assert.NotEqual(t, resumed.SessionID(), fresh.SessionID())
+}
+
+func TestLoadSessionConfig(t *testing.T) {
+	t.Setenv("ENVIRONMENT", "PROD")
+	t.Setenv("SESSION_SECRET", "")
+
+	_, err := services.LoadSessionConfig()
+	assert.NotNil(t, err)
+
+	t.Setenv("SESSION_SECRET", "secret")
+	t.Setenv("SESSION_TTL", "30m")
+
+	config, err := services.LoadSessionConfig()
+
+	assert.Nil(t, err)
+	assert.True(t, config.Secure)
+	assert.Equal(t, "Lax", config.SameSite)
+	assert.Equal(t, time.Minute*30, config.TTL)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"server/emails"
 	"server/models"
 	"server/services"
 	"server/users"
@@ -14,8 +15,10 @@ func CreateSignupHandler(
 	router *gin.Engine,
 	provider services.ServiceProviderType,
 ) {
-	router.POST("/signup", signUpHandler(provider))
-	router.GET("/signup", signUpHandler(provider))
+	csrf := csrfProtect(provider)
+
+	router.POST("/signup", csrf, signUpHandler(provider))
+	router.GET("/signup", csrf, signUpHandler(provider))
 }
 
 func signUpHandler(
@@ -25,6 +28,7 @@ func signUpHandler(
 		userRepo := provider.GetUserRepo()
 		emailService := provider.GetEmailService()
 		codeGenerator := provider.GetCodeGenerator()
+		passwordPolicy := provider.GetPasswordPolicy()
 
 		request := context.Request
 
@@ -43,7 +47,7 @@ func signUpHandler(
 			user, _ := userRepo.GetUser(email)
 
 			if user != nil {
-				context.HTML(http.StatusBadRequest, "signup.tmpl", gin.H{
+				renderHTML(context, http.StatusBadRequest, "signup.tmpl", gin.H{
 					"error":    fmt.Sprintf("Email %s already exists", email),
 					"email":    email,
 					"password": password,
@@ -60,7 +64,7 @@ func signUpHandler(
 
 			for field, val := range values {
 				if val == "" {
-					context.HTML(http.StatusBadRequest, "signup.tmpl", gin.H{
+					renderHTML(context, http.StatusBadRequest, "signup.tmpl", gin.H{
 						"error":    fmt.Sprintf("Invalid field: %s", field),
 						"email":    email,
 						"password": password,
@@ -70,9 +74,9 @@ func signUpHandler(
 				}
 			}
 
-			if len(password) < 8 {
-				context.HTML(http.StatusBadRequest, "signup.tmpl", gin.H{
-					"error":    "Password must be a minimum of 8 characters",
+			if policyErr := passwordPolicy.Check(password, email, name); policyErr != nil {
+				renderHTML(context, http.StatusBadRequest, "signup.tmpl", gin.H{
+					"error":    policyErr.Error(),
 					"email":    email,
 					"password": password,
 					"name":     name,
@@ -84,7 +88,7 @@ func signUpHandler(
 			hash, pwdErr := users.HashPassword(password)
 
 			if pwdErr != nil {
-				context.HTML(http.StatusInternalServerError, "signup.tmpl", gin.H{
+				renderHTML(context, http.StatusInternalServerError, "signup.tmpl", gin.H{
 					"error":    "There was an error creating new user",
 					"email":    email,
 					"password": password,
@@ -94,15 +98,16 @@ func signUpHandler(
 			}
 
 			newUser := models.User{
-				Name:           name,
-				Email:          email,
-				Password:       hash,
-				ValidationCode: code,
+				Name:     name,
+				Email:    email,
+				Password: hash,
+				Locale:   emails.Templates.MatchLocale(request.Header.Get("Accept-Language")),
 			}
 
-			userRepo.CreateUser(newUser)
+			userRepo.CreateUser(newUser, code)
+			recordAudit(context, provider, services.AuditSignup, email, "", "")
 
-			emailService.SendVerificationLink(email, code)
+			emailService.SendVerificationLink(email, code, newUser.Locale)
 
 			redirectUrl := fmt.Sprintf("/validate-email?email=%s", email)
 
@@ -110,7 +115,7 @@ func signUpHandler(
 			return
 		}
 
-		context.HTML(http.StatusOK, "signup.tmpl", gin.H{
+		renderHTML(context, http.StatusOK, "signup.tmpl", gin.H{
 			"error":    nil,
 			"email":    nil,
 			"password": nil,
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/mocks"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthModels "github.com/go-oauth2/oauth2/v4/models"
+	"github.com/go-oauth2/oauth2/v4/store"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var testClientID = "222222"
+var testClientSecret = "client-secret"
+var testRedirectURI = "http://localhost:3000/api/auth/callback"
+
+func SetupOauthServer(db *gorm.DB) (*services.OauthServer, oauth2.TokenStore) {
+	return SetupOauthServerWithSession(db, &mocks.MockSession{})
+}
+
+func SetupOauthServerWithSession(db *gorm.DB, session services.SessionApiType) (*services.OauthServer, oauth2.TokenStore) {
+	tokenStore, _ := store.NewMemoryTokenStore()
+
+	clock := &mocks.MockClock{Time: now}
+
+	clientStore := services.CreateClientStore(db, clock, CreateFamilyRepo(db))
+	clientStore.EnsureClient(&services.Client{
+		ID:           testClientID,
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       services.SupportedScopes,
+	}, testClientSecret)
+	clientStore.EnsureClient(&services.Client{
+		ID:           "other-client",
+		RedirectURIs: []string{"http://localhost:4000/callback"},
+		GrantTypes:   services.SupportedGrantTypes,
+		Scopes:       services.SupportedScopes,
+	}, "other-secret")
+
+	signingKey, _ := services.GenerateSigningKey()
+	keySet := services.CreateKeySet(signingKey, nil, clock)
+
+	srv := services.CreateOauthServerFromStores(
+		session,
+		tokenStore,
+		&clientStore,
+		db,
+		clock,
+		keySet,
+	)
+
+	tokenInfo := oauthModels.NewToken()
+	tokenInfo.SetClientID(testClientID)
+	tokenInfo.SetUserID(testUser)
+	tokenInfo.SetScope("all")
+	tokenInfo.SetAccess("access-token")
+	tokenInfo.SetAccessCreateAt(time.Now())
+	tokenInfo.SetAccessExpiresIn(time.Hour)
+	tokenInfo.SetRefresh("refresh-token")
+	tokenInfo.SetRefreshCreateAt(time.Now())
+	tokenInfo.SetRefreshExpiresIn(time.Hour * 24)
+
+	tokenStore.Create(context.Background(), tokenInfo)
+
+	return srv, tokenStore
+}
+
+func PostClientForm(router http.Handler, path string, form url.Values, id string, secret string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(id, secret)
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func Introspect(router http.Handler, token string) map[string]interface{} {
+	w := PostClientForm(router, "/oauth/introspect", url.Values{"token": {token}}, testClientID, testClientSecret)
+
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+
+	return data
+}
+
+func TestIntrospectAccessToken(t *testing.T) {
+	db := Setup()
+
+	t.Setenv("CLIENT_ID", testClientID)
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	data := Introspect(router, "access-token")
+
+	assert.Equal(t, true, data["active"])
+	assert.Equal(t, "Bearer", data["token_type"])
+	assert.Equal(t, testClientID, data["client_id"])
+	assert.Equal(t, testUser, data["sub"])
+	assert.Equal(t, testUser, data["email"])
+	assert.Equal(t, testName, data["name"])
+	assert.Equal(t, "all", data["scope"])
+
+	refresh := Introspect(router, "refresh-token")
+
+	assert.Equal(t, true, refresh["active"])
+	assert.Equal(t, "refresh_token", refresh["token_type"])
+}
+
+func TestIntrospectLimitedByScope(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	data := Introspect(router, "access-token")
+
+	assert.Equal(t, true, data["active"])
+	assert.Equal(t, UserSubject(db, testClientID), data["sub"])
+	assert.NotEqual(t, testUser, data["sub"])
+	assert.Nil(t, data["username"])
+	assert.Nil(t, data["email"])
+	assert.Nil(t, data["name"])
+}
+
+func TestIntrospectUnknownToken(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	data := Introspect(router, "unknown")
+
+	assert.Equal(t, map[string]interface{}{"active": false}, data)
+}
+
+func TestAdminRoleOnlyForFirstPartyClient(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	db.Exec("update users set admin = ? where email = ?", true, testUser)
+
+	t.Setenv("CLIENT_ID", "other-client")
+
+	assert.Nil(t, Introspect(router, "access-token")["roles"])
+
+	_, third := RefreshTokens(router, "refresh-token")
+
+	assert.False(t, services.TokenHasRole(third["access_token"].(string), services.AdminRole))
+
+	t.Setenv("CLIENT_ID", testClientID)
+
+	assert.Equal(t, []interface{}{services.AdminRole}, Introspect(router, third["access_token"].(string))["roles"])
+
+	_, first := RefreshTokens(router, third["refresh_token"].(string))
+
+	assert.True(t, services.TokenHasRole(first["access_token"].(string), services.AdminRole))
+}
+
+func TestIntrospectRequiresClientCredentials(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w := PostClientForm(router, "/oauth/introspect", url.Values{"token": {"access-token"}}, testClientID, "wrong")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_client")
+
+	form := url.Values{
+		"token":         {"access-token"},
+		"client_id":     {testClientID},
+		"client_secret": {testClientSecret},
+	}
+
+	formReq, _ := http.NewRequest("POST", "/oauth/introspect", strings.NewReader(form.Encode()))
+	formReq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	formW := httptest.NewRecorder()
+	router.ServeHTTP(formW, formReq)
+
+	assert.Equal(t, http.StatusOK, formW.Code)
+	assert.Contains(t, formW.Body.String(), `"active":true`)
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	form := url.Values{"token": {"refresh-token"}, "token_type_hint": {"refresh_token"}}
+
+	w := PostClientForm(router, "/oauth/revoke", form, testClientID, testClientSecret)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, false, Introspect(router, "refresh-token")["active"])
+	assert.Equal(t, false, Introspect(router, "access-token")["active"])
+}
+
+func TestRevokeIgnoresOtherClientsTokens(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w := PostClientForm(router, "/oauth/revoke", url.Values{"token": {"access-token"}}, "other-client", "other-secret")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, Introspect(router, "access-token")["active"])
+}
+
+func TestRevokeUnknownToken(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w := PostClientForm(router, "/oauth/revoke", url.Values{"token": {"unknown"}}, testClientID, testClientSecret)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
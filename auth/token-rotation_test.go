@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func RefreshTokens(router http.Handler, refresh string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refresh},
+		"client_id":     {testClientID},
+		"client_secret": {testClientSecret},
+	}
+
+	req, _ := http.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	router.ServeHTTP(w, req)
+
+	var data map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &data)
+
+	return w, data
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	w, first := RefreshTokens(router, "refresh-token")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, first["access_token"])
+	assert.NotEqual(t, "refresh-token", first["refresh_token"])
+
+	w, second := RefreshTokens(router, first["refresh_token"].(string))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, first["refresh_token"], second["refresh_token"])
+
+	_, reused := RefreshTokens(router, "refresh-token")
+
+	assert.NotEmpty(t, reused["error"])
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	_, first := RefreshTokens(router, "refresh-token")
+	_, second := RefreshTokens(router, first["refresh_token"].(string))
+
+	_, data := RefreshTokens(router, first["refresh_token"].(string))
+
+	assert.Equal(t, "invalid_grant", data["error"])
+
+	_, data = RefreshTokens(router, second["refresh_token"].(string))
+
+	assert.Equal(t, "invalid_grant", data["error"])
+
+	assert.Equal(t, false, Introspect(router, second["access_token"].(string))["active"])
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.True(t, family.Revoked)
+	assert.Equal(t, services.TokenReused, family.RevokedReason)
+	assert.Equal(t, testUser, family.UserID)
+
+	var events []string
+	db.Model(&models.TokenEvent{}).Order("id").Pluck("event", &events)
+
+	assert.Equal(t, []string{
+		services.TokenIssued,
+		services.TokenRotated,
+		services.TokenReused,
+	}, events)
+}
+
+func TestFailedRefreshCanBeRetried(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	_, first := RefreshTokens(router, "refresh-token")
+	refresh := first["refresh_token"].(string)
+
+	// the token store losing the token makes the exchange fail after the
+	// refresh token was claimed
+	ti, _ := tokenStore.GetByRefresh(context.Background(), refresh)
+	tokenStore.RemoveByRefresh(context.Background(), refresh)
+
+	_, data := RefreshTokens(router, refresh)
+
+	assert.Equal(t, "invalid_grant", data["error"])
+
+	tokenStore.Create(context.Background(), ti)
+
+	w, data := RefreshTokens(router, refresh)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, data["refresh_token"])
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.False(t, family.Revoked)
+}
+
+func TestReleaseOnlyUndoesItsOwnClaim(t *testing.T) {
+	db := Setup()
+
+	_, tokenStore := SetupOauthServer(db)
+
+	defer Teardown(db)
+
+	ti, _ := tokenStore.GetByAccess(context.Background(), "access-token")
+
+	families := CreateFamilyRepo(db)
+	families.StartFamily(ti, "")
+
+	_, claim, _ := families.ClaimRefreshToken("refresh-token")
+
+	assert.NotEmpty(t, claim)
+
+	families.ReleaseRefreshToken("refresh-token", "another-claim")
+
+	_, reclaim, _ := families.ClaimRefreshToken("refresh-token")
+
+	assert.Empty(t, reclaim)
+
+	families.ReleaseRefreshToken("refresh-token", claim)
+
+	_, reclaim, _ = families.ClaimRefreshToken("refresh-token")
+
+	assert.NotEmpty(t, reclaim)
+}
+
+func TestRefreshFailsWhenFamiliesCantBeRead(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+	defer db.AutoMigrate(&models.RefreshToken{})
+
+	db.Migrator().DropTable(&models.RefreshToken{})
+
+	w, data := RefreshTokens(router, "refresh-token")
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "server_error", data["error"])
+}
+
+func TestRevokeRevokesTokenFamily(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	_, first := RefreshTokens(router, "refresh-token")
+
+	form := url.Values{"token": {first["access_token"].(string)}}
+	PostClientForm(router, "/oauth/revoke", form, testClientID, testClientSecret)
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.True(t, family.Revoked)
+	assert.Equal(t, services.TokenRevoked, family.RevokedReason)
+}
+
+func TestTokenLifetimesFromEnv(t *testing.T) {
+	t.Setenv("ACCESS_TOKEN_TTL", "15m")
+	t.Setenv("REFRESH_TOKEN_TTL", "bad")
+
+	access, refresh := services.LoadTokenLifetimes()
+
+	assert.Equal(t, time.Minute*15, access)
+	assert.Equal(t, time.Hour*72, refresh)
+}
+
+func TestClientIPOnlyTrustsConfiguredProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	req, _ := http.NewRequest("POST", "/oauth/token", nil)
+	req.RemoteAddr = "198.51.100.1:41234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	assert.Equal(t, "198.51.100.1", services.ClientIP(req))
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+
+	assert.Equal(t, "198.51.100.1", services.ClientIP(req))
+
+	req.RemoteAddr = "10.0.0.2:41234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.9, 192.0.2.1")
+
+	assert.Equal(t, "198.51.100.9", services.ClientIP(req))
+
+	req.Header.Set("X-Forwarded-For", "not-an-ip")
+
+	assert.Equal(t, "10.0.0.2", services.ClientIP(req))
+}
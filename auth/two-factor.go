@@ -0,0 +1,289 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"server/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-session/session"
+)
+
+const (
+	pendingTwoFactorUserKey    = "PendingTwoFactorUserID"
+	pendingTwoFactorExpiresKey = "PendingTwoFactorExpires"
+	setupTwoFactorUserKey      = "TwoFactorSetupUserID"
+	setupTwoFactorSecretKey    = "TwoFactorSetupSecret"
+	setupTwoFactorExpiresKey   = "TwoFactorSetupExpires"
+)
+
+var invalidTwoFactorMessage = "Invalid authentication code"
+var setupExpiredMessage = "Your setup session has expired. Please start again."
+var currentTwoFactorMessage = "Two-factor authentication is already enabled. Enter a code from your authenticator app or a recovery code to set it up again."
+
+func CreateTwoFactorHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	csrf := csrfProtect(provider)
+
+	router.GET("/login/2fa", csrf, twoFactorLoginHandler(provider))
+	router.POST("/login/2fa", csrf, twoFactorLoginHandler(provider))
+	router.GET("/two-factor/setup", csrf, twoFactorSetupGetHandler())
+	router.POST("/two-factor/setup", csrf, twoFactorSetupPostHandler(provider))
+}
+
+// startTwoFactorLogin records that the password step passed. The second step
+// has five minutes to complete before the user has to log in again.
+func startTwoFactorLogin(
+	provider services.ServiceProviderType,
+	store session.Store,
+	email string,
+) {
+	clock := provider.GetClock()
+
+	store.Set(pendingTwoFactorUserKey, email)
+	store.Set(pendingTwoFactorExpiresKey, clock.AddTime(clock.GetCurrentTime(), 0, 5, 0).Format(time.RFC3339))
+	store.Save()
+}
+
+func twoFactorLoginHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+		userRepo := provider.GetUserRepo()
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		email, ok := sessionValueBefore(store, pendingTwoFactorUserKey, pendingTwoFactorExpiresKey, provider.GetClock())
+
+		if !ok {
+			context.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		if context.Request.Method != "POST" {
+			renderHTML(context, http.StatusOK, "two-factor.tmpl", gin.H{
+				"error": nil,
+			})
+			return
+		}
+
+		ip := context.ClientIP()
+
+		if !userRepo.LoginLockedUntil(email, ip).IsZero() {
+			renderHTML(context, http.StatusTooManyRequests, "two-factor.tmpl", gin.H{
+				"error": lockedOutMessage,
+			})
+			return
+		}
+
+		user, userErr := userRepo.GetUser(email)
+
+		if userErr != nil || user.Disabled {
+			context.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		code := context.Request.FormValue("code")
+
+		if !userRepo.VerifyTOTP(user, code) && !userRepo.UseRecoveryCode(user, code) {
+			recordFailedLogin(context, provider, email, user, "two_factor")
+
+			renderHTML(context, http.StatusBadRequest, "two-factor.tmpl", gin.H{
+				"error": invalidTwoFactorMessage,
+			})
+			return
+		}
+
+		userRepo.ClearFailedLogins(email)
+		recordAudit(context, provider, services.AuditLoginSucceeded, email, returnClientID(store), "two_factor")
+
+		store.Delete(pendingTwoFactorUserKey)
+		store.Delete(pendingTwoFactorExpiresKey)
+		store.Set("LoggedInUserID", email)
+		store.Save()
+
+		context.Redirect(http.StatusFound, "/auth")
+	}
+}
+
+func twoFactorSetupGetHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		renderHTML(context, http.StatusOK, "two-factor-setup.tmpl", gin.H{
+			"error": nil,
+		})
+	}
+}
+
+func twoFactorSetupPostHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		session := provider.GetSession()
+
+		store, err := session.Start(context, context.Writer, context.Request)
+
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if context.Request.FormValue("step") == "confirm" {
+			confirmTwoFactorSetup(context, provider, store)
+			return
+		}
+
+		email := context.Request.FormValue("email")
+		password := context.Request.FormValue("password")
+
+		user, status, message := authenticateUser(context, provider, email, password)
+
+		if user == nil || !user.Validated {
+			if user != nil {
+				status, message = http.StatusBadRequest, invalidCredentialsMessage
+			}
+
+			renderHTML(context, status, "two-factor-setup.tmpl", gin.H{
+				"error": message,
+				"email": email,
+			})
+			return
+		}
+
+		userRepo := provider.GetUserRepo()
+
+		// replacing the secret and recovery codes needs the second factor
+		// too, or a stolen password would be enough to take over 2FA
+		if user.TOTPEnabled {
+			code := context.Request.FormValue("current_code")
+
+			if code == "" || (!userRepo.VerifyTOTP(user, code) && !userRepo.UseRecoveryCode(user, code)) {
+				if code != "" {
+					recordFailedLogin(context, provider, email, user, "two_factor")
+				}
+
+				renderHTML(context, http.StatusBadRequest, "two-factor-setup.tmpl", gin.H{
+					"error": currentTwoFactorMessage,
+					"email": email,
+				})
+				return
+			}
+		}
+
+		userRepo.ClearFailedLogins(email)
+
+		clock := provider.GetClock()
+		secret := services.GenerateTOTPSecret()
+
+		store.Set(setupTwoFactorUserKey, email)
+		store.Set(setupTwoFactorSecretKey, secret)
+		store.Set(setupTwoFactorExpiresKey, clock.AddTime(clock.GetCurrentTime(), 0, 10, 0).Format(time.RFC3339))
+		store.Save()
+
+		renderTwoFactorQRCode(context, http.StatusOK, email, secret, nil)
+	}
+}
+
+func confirmTwoFactorSetup(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+	store session.Store,
+) {
+	userRepo := provider.GetUserRepo()
+	clock := provider.GetClock()
+
+	email, ok := sessionValueBefore(store, setupTwoFactorUserKey, setupTwoFactorExpiresKey, clock)
+	secret, hasSecret := store.Get(setupTwoFactorSecretKey)
+
+	if !ok || !hasSecret {
+		renderHTML(context, http.StatusBadRequest, "two-factor-setup.tmpl", gin.H{
+			"error": setupExpiredMessage,
+		})
+		return
+	}
+
+	user, userErr := userRepo.GetUser(email)
+
+	if userErr != nil {
+		renderHTML(context, http.StatusBadRequest, "two-factor-setup.tmpl", gin.H{
+			"error": setupExpiredMessage,
+		})
+		return
+	}
+
+	step, valid := services.ValidateTOTP(
+		secret.(string),
+		context.Request.FormValue("code"),
+		clock.GetCurrentTime(),
+		0,
+	)
+
+	if !valid {
+		renderTwoFactorQRCode(context, http.StatusBadRequest, email, secret.(string), invalidTwoFactorMessage)
+		return
+	}
+
+	codes := userRepo.EnableTOTP(user, secret.(string), step)
+
+	store.Delete(setupTwoFactorUserKey)
+	store.Delete(setupTwoFactorSecretKey)
+	store.Delete(setupTwoFactorExpiresKey)
+	store.Save()
+
+	renderHTML(context, http.StatusOK, "two-factor-setup.tmpl", gin.H{
+		"recoveryCodes": codes,
+	})
+}
+
+func renderTwoFactorQRCode(
+	context *gin.Context,
+	status int,
+	email string,
+	secret string,
+	message interface{},
+) {
+	qrCode, err := services.QRCodeDataURI(services.TOTPURI(secret, email))
+
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	renderHTML(context, status, "two-factor-setup.tmpl", gin.H{
+		"qrCode": template.URL(qrCode),
+		"secret": secret,
+		"error":  message,
+	})
+}
+
+// sessionValueBefore returns the string stored under key as long as the
+// RFC 3339 time stored under expiresKey hasn't passed.
+func sessionValueBefore(
+	store session.Store,
+	key string,
+	expiresKey string,
+	clock services.ClockType,
+) (string, bool) {
+	value, ok := store.Get(key)
+	expires, hasExpiry := store.Get(expiresKey)
+
+	if !ok || !hasExpiry {
+		return "", false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, expires.(string))
+
+	if err != nil || !clock.GetCurrentTime().Before(expiresAt) {
+		return "", false
+	}
+
+	return value.(string), true
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var totpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func SetupTwoFactorUser(db *gorm.DB, mockClock *mocks.MockClock) []string {
+	userRepo := services.CreateUserRepo(db, mockClock, CreateFamilyRepo(db))
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	return userRepo.EnableTOTP(user, totpSecret, services.TOTPStep(now)-5)
+}
+
+func PostForm(router http.Handler, path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	SignRequest(router, w, req)
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func CurrentTOTPCode() string {
+	code, _ := services.TOTPCode(totpSecret, services.TOTPStep(now))
+	return code
+}
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	code, err := services.TOTPCode(totpSecret, services.TOTPStep(time.Unix(59, 0)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "287082", code)
+
+	code, _ = services.TOTPCode(totpSecret, services.TOTPStep(time.Unix(1111111109, 0)))
+
+	assert.Equal(t, "081804", code)
+}
+
+func TestValidateTOTPRejectsReplay(t *testing.T) {
+	step, ok := services.ValidateTOTP(totpSecret, CurrentTOTPCode(), now, 0)
+
+	assert.True(t, ok)
+	assert.Equal(t, services.TOTPStep(now), step)
+
+	_, ok = services.ValidateTOTP(totpSecret, CurrentTOTPCode(), now, step)
+
+	assert.False(t, ok)
+
+	_, ok = services.ValidateTOTP(totpSecret, CurrentTOTPCode(), now.Add(time.Minute*2), 0)
+
+	assert.False(t, ok)
+}
+
+func TestLoginRequiresSecondStep(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	SetupTwoFactorUser(db, mockClock)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	login := PostLogin(router, testUser, testPassword)
+
+	assert.Equal(t, "/login/2fa", login.Header().Get("Location"))
+
+	cookies := login.Result().Cookies()
+
+	wrong := PostForm(router, "/login/2fa", url.Values{"code": {"000000"}}, cookies)
+
+	assert.Contains(t, wrong.Body.String(), invalidTwoFactorMessage)
+
+	w := PostForm(router, "/login/2fa", url.Values{"code": {CurrentTOTPCode()}}, cookies)
+
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+
+	authW := httptest.NewRecorder()
+	authReq, _ := http.NewRequest("GET", "/auth", nil)
+	for _, cookie := range cookies {
+		authReq.AddCookie(cookie)
+	}
+
+	router.ServeHTTP(authW, authReq)
+
+	assert.Equal(t, "/oauth/authorize", authW.Header().Get("Location"))
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.Equal(t, services.TOTPStep(now), user.TOTPLastStep)
+}
+
+func TestLoginWithRecoveryCode(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	codes := SetupTwoFactorUser(db, mockClock)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	assert.Len(t, codes, 10)
+
+	login := PostLogin(router, testUser, testPassword)
+
+	w := PostForm(router, "/login/2fa", url.Values{"code": {codes[0]}}, login.Result().Cookies())
+
+	assert.Equal(t, "/auth", w.Header().Get("Location"))
+
+	again := PostLogin(router, testUser, testPassword)
+
+	reused := PostForm(router, "/login/2fa", url.Values{"code": {codes[0]}}, again.Result().Cookies())
+
+	assert.Contains(t, reused.Body.String(), invalidTwoFactorMessage)
+}
+
+func TestSecondStepExpires(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	SetupTwoFactorUser(db, mockClock)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	login := PostLogin(router, testUser, testPassword)
+
+	mockClock.Time = now.Add(time.Minute * 6)
+
+	w := PostForm(router, "/login/2fa", url.Values{"code": {CurrentTOTPCode()}}, login.Result().Cookies())
+
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+func TestSecondStepRequiresPassword(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	w := PostForm(router, "/login/2fa", url.Values{"code": {CurrentTOTPCode()}}, nil)
+
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	start := PostForm(router, "/two-factor/setup", url.Values{
+		"email":    {testUser},
+		"password": {testPassword},
+	}, nil)
+
+	assert.Equal(t, http.StatusOK, start.Code)
+	assert.Contains(t, start.Body.String(), "data:image/png;base64,")
+
+	secret := regexp.MustCompile(`font-monospace">([A-Z2-7]+)<`).FindStringSubmatch(start.Body.String())[1]
+
+	cookies := start.Result().Cookies()
+
+	wrong := PostForm(router, "/two-factor/setup", url.Values{"step": {"confirm"}, "code": {"000000"}}, cookies)
+
+	assert.Contains(t, wrong.Body.String(), invalidTwoFactorMessage)
+
+	code, _ := services.TOTPCode(secret, services.TOTPStep(now))
+
+	w := PostForm(router, "/two-factor/setup", url.Values{"step": {"confirm"}, "code": {code}}, cookies)
+
+	assert.Contains(t, w.Body.String(), "Two-factor authentication is now enabled")
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.True(t, user.TOTPEnabled)
+	assert.Equal(t, secret, user.TOTPSecret)
+
+	var count int64
+	db.Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&count)
+
+	assert.Equal(t, int64(10), count)
+}
+
+func TestTwoFactorSetupWrongPassword(t *testing.T) {
+	db := Setup()
+
+	router := SetupRouter(db)
+
+	defer Teardown(db)
+
+	w := PostForm(router, "/two-factor/setup", url.Values{
+		"email":    {testUser},
+		"password": {"bad-password"},
+	}, nil)
+
+	assert.Contains(t, w.Body.String(), invalidCredentialsMessage)
+	assert.NotContains(t, w.Body.String(), "data:image/png")
+}
+
+func TestTwoFactorSetupReEnrollRequiresCurrentCode(t *testing.T) {
+	db := Setup()
+
+	mockClock := &mocks.MockClock{Time: now}
+
+	codes := SetupTwoFactorUser(db, mockClock)
+
+	router := SetupRouter(db, mockClock)
+
+	defer Teardown(db)
+
+	passwordOnly := PostForm(router, "/two-factor/setup", url.Values{
+		"email":    {testUser},
+		"password": {testPassword},
+	}, nil)
+
+	assert.Equal(t, http.StatusBadRequest, passwordOnly.Code)
+	assert.Contains(t, passwordOnly.Body.String(), "Two-factor authentication is already enabled")
+	assert.NotContains(t, passwordOnly.Body.String(), "data:image/png")
+
+	// the confirm step can't be reached without starting setup
+	confirm := PostForm(router, "/two-factor/setup", url.Values{"step": {"confirm"}, "code": {CurrentTOTPCode()}}, passwordOnly.Result().Cookies())
+
+	assert.Contains(t, confirm.Body.String(), setupExpiredMessage)
+
+	wrongCode := PostForm(router, "/two-factor/setup", url.Values{
+		"email":        {testUser},
+		"password":     {testPassword},
+		"current_code": {"000000"},
+	}, nil)
+
+	assert.Equal(t, http.StatusBadRequest, wrongCode.Code)
+
+	var user *models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	assert.Equal(t, totpSecret, user.TOTPSecret)
+
+	start := PostForm(router, "/two-factor/setup", url.Values{
+		"email":        {testUser},
+		"password":     {testPassword},
+		"current_code": {codes[0]},
+	}, nil)
+
+	assert.Equal(t, http.StatusOK, start.Code)
+	assert.Contains(t, start.Body.String(), "data:image/png;base64,")
+}
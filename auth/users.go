@@ -0,0 +1,266 @@
+package main
+
+import (
+	"net/http"
+	"server/models"
+	"server/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+func CreateUserAdminHandler(
+	router *gin.Engine,
+	provider services.ServiceProviderType,
+) {
+	admin := router.Group("/admin", requireAdmin(provider))
+
+	admin.GET("/users", listUsersHandler(provider))
+	admin.GET("/users/:id", getUserHandler(provider))
+	admin.POST("/users/:id/validate", validateUserHandler(provider))
+	admin.POST("/users/:id/resend-verification", resendVerificationHandler(provider))
+	admin.POST("/users/:id/disable", setUserDisabledHandler(provider, true))
+	admin.POST("/users/:id/enable", setUserDisabledHandler(provider, false))
+	admin.POST("/users/:id/reset-password", forcePasswordResetHandler(provider))
+	admin.DELETE("/users/:id", deleteUserHandler(provider))
+}
+
+func userJSON(user *models.User) gin.H {
+	return gin.H{
+		"id":                 user.ID,
+		"email":              user.Email,
+		"name":               user.Name,
+		"validated":          user.Validated,
+		"pending_email":      user.PendingEmail,
+		"disabled":           user.Disabled,
+		"reset_required":     user.ResetRequired,
+		"two_factor_enabled": user.TOTPEnabled,
+		"admin":              user.Admin,
+		"created_at":         user.CreatedAt,
+	}
+}
+
+// adminTargetUser loads the user named in the path, responding with 404
+// when there isn't one.
+func adminTargetUser(
+	context *gin.Context,
+	provider services.ServiceProviderType,
+) *models.User {
+	userRepo := provider.GetUserRepo()
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil
+	}
+
+	user, err := userRepo.GetUserByID(uint(id))
+	if err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil
+	}
+
+	return user
+}
+
+func listUsersHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+
+		page, err := strconv.Atoi(context.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+
+		limit, err := strconv.Atoi(context.DefaultQuery("limit", strconv.Itoa(defaultUserPageSize)))
+		if err != nil || limit < 1 {
+			limit = defaultUserPageSize
+		}
+
+		if limit > maxUserPageSize {
+			limit = maxUserPageSize
+		}
+
+		found, total := userRepo.SearchUsers(context.Query("q"), (page-1)*limit, limit)
+
+		users := []gin.H{}
+
+		for i := range found {
+			users = append(users, userJSON(&found[i]))
+		}
+
+		context.JSON(http.StatusOK, gin.H{
+			"users": users,
+			"total": total,
+			"page":  page,
+			"limit": limit,
+		})
+	}
+}
+
+func getUserHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user := adminTargetUser(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		context.JSON(http.StatusOK, userJSON(user))
+	}
+}
+
+func validateUserHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+
+		user := adminTargetUser(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		if !user.Validated {
+			userRepo.ActivateUser(user)
+			recordAudit(context, provider, services.AuditEmailValidated, user.Email, "", "admin:"+adminUser(context).Email)
+		}
+
+		context.JSON(http.StatusOK, userJSON(user))
+	}
+}
+
+func resendVerificationHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		codeGen := provider.GetCodeGenerator()
+		emailService := provider.GetEmailService()
+
+		user := adminTargetUser(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		// a pending email change is verified with the same kind of link
+		email := user.PendingEmail
+		if !user.Validated {
+			email = user.Email
+		}
+
+		if email == "" {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "user has nothing to verify"})
+			return
+		}
+
+		code := codeGen.GenCode()
+
+		userRepo.UpdateCode(user, code)
+
+		if err := emailService.SendVerificationLink(email, code, user.Locale); err != nil {
+			context.JSON(http.StatusBadGateway, gin.H{"error": "failed to send verification email"})
+			return
+		}
+
+		context.JSON(http.StatusAccepted, userJSON(user))
+	}
+}
+
+func setUserDisabledHandler(
+	provider services.ServiceProviderType,
+	disabled bool,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+
+		user := adminTargetUser(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		if disabled && user.ID == adminUser(context).ID {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "admins cannot disable themselves"})
+			return
+		}
+
+		userRepo.SetDisabled(user, disabled, context.ClientIP())
+
+		context.JSON(http.StatusOK, userJSON(user))
+	}
+}
+
+// forcePasswordResetHandler stops password sign in and emails the user a
+// reset link, for accounts whose password may be compromised.
+func forcePasswordResetHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		codeGen := provider.GetCodeGenerator()
+		emailService := provider.GetEmailService()
+
+		user := adminTargetUser(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		code := codeGen.GenCode()
+
+		userRepo.ForcePasswordReset(user, code, context.ClientIP())
+
+		if err := emailService.SendPasswordResetLink(user.Email, code, user.Locale); err != nil {
+			context.JSON(http.StatusBadGateway, gin.H{"error": "failed to send password reset email"})
+			return
+		}
+
+		context.JSON(http.StatusAccepted, userJSON(user))
+	}
+}
+
+// deleteUserHandler removes the user's data from the backend first, the same
+// as when users delete their own account.
+func deleteUserHandler(
+	provider services.ServiceProviderType,
+) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		userRepo := provider.GetUserRepo()
+		backendService := provider.GetBackendService()
+
+		user := adminTargetUser(context, provider)
+
+		if user == nil {
+			return
+		}
+
+		if user.ID == adminUser(context).ID {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "admins cannot delete themselves"})
+			return
+		}
+
+		if err := backendService.DeleteAccount(user.Email); err != nil {
+			context.JSON(http.StatusBadGateway, gin.H{"error": "failed to delete the user's backend data"})
+			return
+		}
+
+		if err := userRepo.DeleteUser(user, context.ClientIP()); err != nil {
+			context.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+	}
+}
@@ -1,13 +1,186 @@
 package users
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// HashParams picks the algorithm for new hashes and its cost. Hashes carry
+// their own parameters, so hashes made with older settings still verify.
+type HashParams struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32 // KiB
+	Argon2Threads uint8
+}
+
+var DefaultHashParams = HashParams{
+	Algorithm:     Bcrypt,
+	BcryptCost:    12,
+	Argon2Time:    1,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 4,
+}
+
+var params = DefaultHashParams
+
+// LoadHashParams reads PASSWORD_HASH (bcrypt or argon2id), BCRYPT_COST,
+// ARGON2_TIME, ARGON2_MEMORY in KiB and ARGON2_THREADS.
+func LoadHashParams() HashParams {
+	loaded := DefaultHashParams
+
+	switch algorithm := os.Getenv("PASSWORD_HASH"); algorithm {
+	case "":
+	case Bcrypt, Argon2id:
+		loaded.Algorithm = algorithm
+	default:
+		log.Printf("invalid PASSWORD_HASH %q, using %s", algorithm, loaded.Algorithm)
+	}
+
+	loaded.BcryptCost = int(uintEnv("BCRYPT_COST", uint64(loaded.BcryptCost), uint64(bcrypt.MinCost), uint64(bcrypt.MaxCost)))
+	loaded.Argon2Time = uint32(uintEnv("ARGON2_TIME", uint64(loaded.Argon2Time), 1, 1<<16))
+	loaded.Argon2Memory = uint32(uintEnv("ARGON2_MEMORY", uint64(loaded.Argon2Memory), 8, 1<<22))
+	loaded.Argon2Threads = uint8(uintEnv("ARGON2_THREADS", uint64(loaded.Argon2Threads), 1, 255))
+
+	return loaded
+}
+
+// Configure sets the parameters used for new hashes. It should be called
+// before the server starts handling requests.
+func Configure(hashParams HashParams) {
+	params = hashParams
+}
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	if params.Algorithm == Argon2id {
+		return hashArgon2id(password, params)
+	}
+
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
 	return string(bytes), err
 }
 
 func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return checkArgon2id(password, hash)
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// NeedsRehash is true when hash wasn't made with the current algorithm and
+// parameters.
+func NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		hashParams, _, _, err := decodeArgon2id(hash)
+		return err != nil || params.Algorithm != Argon2id ||
+			hashParams.Argon2Time != params.Argon2Time ||
+			hashParams.Argon2Memory != params.Argon2Memory ||
+			hashParams.Argon2Threads != params.Argon2Threads
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || params.Algorithm != Bcrypt || cost != params.BcryptCost
+}
+
+// hashArgon2id encodes the hash in the PHC string format,
+// $argon2id$v=19$m=65536,t=1,p=4$salt$hash.
+func hashArgon2id(password string, hashParams HashParams) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hashParams.Argon2Time, hashParams.Argon2Memory, hashParams.Argon2Threads, 32)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		hashParams.Argon2Memory,
+		hashParams.Argon2Time,
+		hashParams.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func checkArgon2id(password string, hash string) bool {
+	hashParams, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	derived := argon2.IDKey([]byte(password), salt, hashParams.Argon2Time, hashParams.Argon2Memory, hashParams.Argon2Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(derived, key) == 1
+}
+
+func decodeArgon2id(hash string) (HashParams, []byte, []byte, error) {
+	hashParams := HashParams{Algorithm: Argon2id}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return hashParams, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return hashParams, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(
+		parts[3],
+		"m=%d,t=%d,p=%d",
+		&hashParams.Argon2Memory,
+		&hashParams.Argon2Time,
+		&hashParams.Argon2Threads,
+	); err != nil {
+		return hashParams, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return hashParams, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return hashParams, nil, nil, ErrInvalidHash
+	}
+
+	return hashParams, salt, key, nil
+}
+
+func uintEnv(name string, fallback uint64, min uint64, max uint64) uint64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || parsed < min || parsed > max {
+		log.Printf("invalid %s %q, using %d", name, value, fallback)
+		return fallback
+	}
+
+	return parsed
+}
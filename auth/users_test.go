@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"server/mocks"
+	"server/models"
+	"server/services"
+	"server/users"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func CreateTestUser(db *gorm.DB, email string, name string, validated bool) uint {
+	password, _ := users.HashPassword(testPassword)
+
+	user := models.User{
+		Email:          email,
+		Name:           name,
+		Password:       password,
+		Validated:      validated,
+		CodeExpiration: now.Add(time.Hour),
+	}
+	db.Create(&user)
+
+	return user.ID
+}
+
+func TestUserAdminRequiresAdminRole(t *testing.T) {
+	db := Setup()
+
+	srv, _ := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	// an admin whose token was issued before they were promoted
+	db.Exec("update users set admin = ? where email = ?", true, testUser)
+
+	w, data := SendJSON(router, "GET", "/admin/users", nil, "access-token")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "insufficient_scope", data["error"])
+}
+
+func TestUserAdminRequiresFirstPartyClient(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	SetupAdmin(t, db, tokenStore)
+
+	// the admin consented to another client, which mustn't get admin access
+	token := StoreClientAdminToken(tokenStore, testUser, "other-client")
+
+	id := CreateTestUser(db, "alice@example.com", "Alice", true)
+
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/admin/users"},
+		{"POST", fmt.Sprintf("/admin/users/%d/disable", id)},
+		{"POST", fmt.Sprintf("/admin/users/%d/reset-password", id)},
+		{"DELETE", fmt.Sprintf("/admin/users/%d", id)},
+	} {
+		w, data := SendJSON(router, request.method, request.path, nil, token)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, request.path)
+		assert.Equal(t, "insufficient_scope", data["error"])
+	}
+
+	var user models.User
+	db.Where("id = ?", id).First(&user)
+
+	assert.False(t, user.Disabled)
+}
+
+func TestUserAdminSearch(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv)
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	CreateTestUser(db, "alice@example.com", "Alice", true)
+	CreateTestUser(db, "bob@example.com", "Bob Smith", false)
+
+	w, data := SendJSON(router, "GET", "/admin/users?q=smith", nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), data["total"])
+
+	found := data["users"].([]interface{})[0].(map[string]interface{})
+
+	assert.Equal(t, "bob@example.com", found["email"])
+	assert.Equal(t, false, found["validated"])
+	assert.Nil(t, found["password"])
+
+	_, page := SendJSON(router, "GET", "/admin/users?limit=2&page=2", nil, token)
+
+	assert.Equal(t, float64(3), page["total"])
+	assert.Len(t, page["users"], 1)
+}
+
+func TestUserAdminValidateAndResend(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupRouter(db, srv, mockEmail, &mocks.MockCodeGenerator{Code: "resent-code"}, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	id := CreateTestUser(db, "pending@example.com", "Pending", false)
+
+	w, _ := SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/resend-verification", id), nil, token)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "pending@example.com", mockEmail.VerificationEmail)
+	assert.Equal(t, "resent-code", mockEmail.ValidationCode)
+
+	w, validated := SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/validate", id), nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, validated["validated"])
+
+	w, _ = SendJSON(router, "POST", "/admin/users/999/validate", nil, token)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUserAdminDisable(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	id := CreateTestUser(db, "alice@example.com", "Alice", true)
+
+	w, disabled := SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/disable", id), nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, disabled["disabled"])
+
+	login := PostLogin(router, "alice@example.com", testPassword)
+
+	assert.Equal(t, http.StatusForbidden, login.Code)
+	assert.Contains(t, login.Body.String(), disabledMessage)
+
+	SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/enable", id), nil, token)
+
+	login = PostLogin(router, "alice@example.com", testPassword)
+
+	assert.Equal(t, http.StatusFound, login.Code)
+
+	var admin models.User
+	db.Where("email = ?", testUser).First(&admin)
+
+	w, _ = SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/disable", admin.ID), nil, token)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUserAdminDisableRevokesTokens(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+
+	router := SetupRouter(db, srv, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	_, tokens := RefreshTokens(router, "refresh-token")
+
+	// admins can't disable themselves, so another admin does it
+	adminID := CreateTestUser(db, "admin@example.com", "Other Admin", true)
+	db.Exec("update users set admin = ? where id = ?", true, adminID)
+
+	token := StoreAdminToken(t, tokenStore, "admin@example.com")
+
+	var user models.User
+	db.Where("email = ?", testUser).First(&user)
+
+	w, _ := SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/disable", user.ID), nil, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	refreshed, _ := RefreshTokens(router, tokens["refresh_token"].(string))
+
+	assert.NotEqual(t, http.StatusOK, refreshed.Code)
+
+	var family models.TokenFamily
+	db.First(&family)
+
+	assert.True(t, family.Revoked)
+	assert.Equal(t, services.UserDisabled, family.RevokedReason)
+}
+
+func TestUserAdminForcePasswordReset(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+	mockEmail := &mocks.MockEmailService{}
+
+	router := SetupRouter(db, srv, mockEmail, &mocks.MockCodeGenerator{Code: "reset-code"}, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	id := CreateTestUser(db, "alice@example.com", "Alice", true)
+
+	w, reset := SendJSON(router, "POST", fmt.Sprintf("/admin/users/%d/reset-password", id), nil, token)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, true, reset["reset_required"])
+	assert.Equal(t, "alice@example.com", mockEmail.ResetEmail)
+	assert.Equal(t, "reset-code", mockEmail.ResetCode)
+
+	var stored models.User
+	db.First(&stored, id)
+
+	assert.Equal(t, services.HashToken("reset-code"), stored.ResetCode)
+
+	login := PostLogin(router, "alice@example.com", testPassword)
+
+	assert.Equal(t, http.StatusForbidden, login.Code)
+	assert.Contains(t, login.Body.String(), resetRequiredMessage)
+
+	PostForm(router, "/reset-password", url.Values{
+		"email":    {"alice@example.com"},
+		"code":     {"reset-code"},
+		"password": {"new-password"},
+	}, nil)
+
+	login = PostLogin(router, "alice@example.com", "new-password")
+
+	assert.Equal(t, http.StatusFound, login.Code)
+}
+
+func TestUserAdminDelete(t *testing.T) {
+	db := Setup()
+
+	srv, tokenStore := SetupOauthServer(db)
+	mockBackend := &mocks.MockBackendService{}
+
+	router := SetupRouter(db, srv, mockBackend, &mocks.MockClock{Time: now})
+
+	defer Teardown(db)
+
+	token := SetupAdmin(t, db, tokenStore)
+
+	id := CreateTestUser(db, "alice@example.com", "Alice", true)
+
+	w, _ := SendJSON(router, "DELETE", fmt.Sprintf("/admin/users/%d", id), nil, token)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "alice@example.com", mockBackend.DeletedEmail)
+
+	var count int64
+	db.Model(&models.User{}).Where("id = ?", id).Count(&count)
+
+	assert.Zero(t, count)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"server/models"
@@ -13,8 +14,10 @@ func CreateValidateEmailHandler(
 	router *gin.Engine,
 	provider services.ServiceProviderType,
 ) {
-	router.POST("/validate-email", validateEmailPostHandler(provider))
-	router.GET("/validate-email", validateEmailGetHandler(provider))
+	csrf := csrfProtect(provider)
+
+	router.POST("/validate-email", csrf, validateEmailPostHandler(provider))
+	router.GET("/validate-email", csrf, validateEmailGetHandler(provider))
 }
 
 func validateEmailPostHandler(
@@ -34,10 +37,10 @@ func validateEmailPostHandler(
 			return
 		}
 
-		user, userErr = userRepo.GetUser(email)
+		user, userErr = userRepo.GetPendingUser(email)
 
 		if userErr != nil {
-			context.HTML(http.StatusBadRequest, "validate-email.tmpl", gin.H{
+			renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
 				"error": fmt.Sprintf("User %s not found", email),
 			})
 			return
@@ -45,12 +48,11 @@ func validateEmailPostHandler(
 
 		code := codeGen.GenCode()
 
-		user.ValidationCode = code
-		userRepo.UpdateCode(user)
+		userRepo.UpdateCode(user, code)
 
-		emailService.SendVerificationLink(email, code)
+		emailService.SendVerificationLink(email, code, user.Locale)
 
-		context.HTML(http.StatusAccepted, "validate-email.tmpl", gin.H{
+		renderHTML(context, http.StatusAccepted, "validate-email.tmpl", gin.H{
 			"message": "Code has been sent. Please check your email for a verification link to activate your account",
 		})
 	}
@@ -72,10 +74,15 @@ func validateEmailGetHandler(
 			return
 		}
 
-		user, userErr = userRepo.GetUser(email)
+		user, userErr = userRepo.GetPendingUser(email)
 
 		if userErr != nil {
-			context.HTML(http.StatusBadRequest, "validate-email.tmpl", gin.H{
+			if changing, err := userRepo.GetUserByPendingEmail(email); err == nil && code != "" {
+				confirmEmailChange(context, provider, changing, code)
+				return
+			}
+
+			renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
 				"error": fmt.Sprintf("User %s not found", email),
 				"email": email,
 			})
@@ -83,8 +90,18 @@ func validateEmailGetHandler(
 		}
 
 		if code != "" {
-			if code != user.ValidationCode {
-				context.HTML(http.StatusBadRequest, "validate-email.tmpl", gin.H{
+			if codeErr := userRepo.ValidateCode(user, code); codeErr != nil {
+				if errors.Is(codeErr, services.ErrCodeExpired) {
+					renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
+						"error":   "This verification link has expired. Please request a new code.",
+						"expired": true,
+						"resend":  true,
+						"email":   email,
+					})
+					return
+				}
+
+				renderHTML(context, http.StatusBadRequest, "validate-email.tmpl", gin.H{
 					"error":  "Invalid code",
 					"resend": true,
 					"email":  email,
@@ -93,15 +110,16 @@ func validateEmailGetHandler(
 			}
 
 			userRepo.ActivateUser(user)
+			recordAudit(context, provider, services.AuditEmailValidated, email, "", "")
 
-			context.HTML(http.StatusAccepted, "validate-email.tmpl", gin.H{
+			renderHTML(context, http.StatusAccepted, "validate-email.tmpl", gin.H{
 				"message": "Thank you for verifying your email. Your account is now active.",
 				"email":   email,
 			})
 			return
 		}
 
-		context.HTML(http.StatusAccepted, "validate-email.tmpl", gin.H{
+		renderHTML(context, http.StatusAccepted, "validate-email.tmpl", gin.H{
 			"message": "Please check your email for a verification link to activate your account",
 			"resend":  true,
 			"email":   email,
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"main/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChangeEmailArgs struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// CreateAccountHandler serves the auth server, which keeps profiles in step
+// with accounts. It authenticates with the first-party client credentials.
+func CreateAccountHandler(router *gin.Engine, provider services.ServiceProviderType) {
+	profilesRepo := provider.GetProfilesRepo()
+	pagesRepo := provider.GetPagesRepo()
+	bucketService := provider.GetBucketService()
+
+	accounts := router.Group("/accounts", func(context *gin.Context) {
+		clientID, clientSecret, ok := context.Request.BasicAuth()
+
+		if !ok || !validClient(clientID, clientSecret) {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid client"})
+			return
+		}
+	})
+
+	accounts.DELETE("/:email", func(context *gin.Context) {
+		email := context.Param("email")
+
+		images := append(pagesRepo.GetImages(email), profilesRepo.GetProfileImages(email)...)
+
+		if err := profilesRepo.DeleteAccount(email); err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete account"})
+			return
+		}
+
+		for _, image := range images {
+			if err := bucketService.DeleteImage(image); err != nil {
+				log.Println("Failed to delete image", image, err)
+			}
+		}
+
+		context.Status(http.StatusNoContent)
+	})
+
+	accounts.PUT("/:email", func(context *gin.Context) {
+		args := ChangeEmailArgs{}
+
+		if err := context.ShouldBindJSON(&args); err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid fields: %s", err)})
+			return
+		}
+
+		if err := profilesRepo.ChangeEmail(context.Param("email"), args.Email); err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "could not change email"})
+			return
+		}
+
+		context.Status(http.StatusNoContent)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"main/services"
 	"net/http"
+	"net/mail"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -17,7 +18,7 @@ type ContactArgs struct {
 }
 
 func CreateContactHandler(router *gin.Engine, provider services.ServiceProviderType) {
-	emailService := provider.GetEmailService()
+	outbox := provider.GetOutbox()
 	pagesRepo := provider.GetPagesRepo()
 
 	router.POST("/contact", func(context *gin.Context) {
@@ -47,12 +48,22 @@ func CreateContactHandler(router *gin.Engine, provider services.ServiceProviderT
 			to = page.Profile.Email
 		}
 
-		emailService.SendEmail(services.EmailArgs{
-			To:      to,
-			Body:    fmt.Sprintf("Message: %s\n\nName: %s\n\nEmail: %s", args.Message, args.Name, args.Email),
-			Subject: "Contact Form",
+		enqueueErr := outbox.Enqueue(services.EmailArgs{
+			To:       to,
+			ReplyTo:  (&mail.Address{Name: args.Name, Address: args.Email}).String(),
+			Template: "contact",
+			Data: map[string]string{
+				"Message": args.Message,
+				"Name":    args.Name,
+				"Email":   args.Email,
+			},
 		})
 
+		if enqueueErr != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": enqueueErr.Error()})
+			return
+		}
+
 		context.JSON(http.StatusOK, "Email sent")
 	})
 }
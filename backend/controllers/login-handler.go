@@ -1,39 +1,85 @@
 package controllers
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
+	"crypto/subtle"
 	"fmt"
+	"main/services"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func CreateLoginHandler(router *gin.Engine) {
+type VerifierArgs struct {
+	State        string `form:"state" binding:"required"`
+	ClientID     string `form:"client_id" binding:"required"`
+	ClientSecret string `form:"client_secret" binding:"required"`
+}
+
+func CreateLoginHandler(router *gin.Engine, provider services.ServiceProviderType) {
+	pkceRepo := provider.GetPKCERepo()
+
+	// every login gets its own verifier, kept server side under the state
+	// until the frontend exchanges the code
 	router.GET("/login", func(context *gin.Context) {
 		authServerURL := os.Getenv("AUTH_SERVER_URL")
 
-		oldUrl := context.Request.URL.String()
-		params := strings.Split(oldUrl, "?")[1]
-		newUrl := fmt.Sprintf("%s%s%s", authServerURL, "/oauth/authorize?", params)
+		values := context.Request.URL.Query()
+		state := values.Get("state")
 
-		finalUrl, _ := url.Parse(newUrl)
+		if state == "" {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "state is required"})
+			return
+		}
 
-		values := finalUrl.Query()
+		challenge, err := pkceRepo.CreateChallenge(state)
 
-		values.Set("code_challenge", genCodeChallengeS256())
+		if err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "could not start login"})
+			return
+		}
+
+		values.Set("code_challenge", challenge)
 		values.Set("code_challenge_method", "S256")
 
-		finalUrl.RawQuery = values.Encode()
+		context.Redirect(http.StatusFound, fmt.Sprintf("%s/oauth/authorize?%s", authServerURL, values.Encode()))
+	})
+
+	// the frontend authenticates with the same client credentials it uses
+	// at the auth server's token endpoint
+	router.POST("/login/verifier", func(context *gin.Context) {
+		args := VerifierArgs{}
 
-		context.Redirect(http.StatusFound, finalUrl.String())
+		if err := context.ShouldBind(&args); err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid fields: %s", err)})
+			return
+		}
+
+		if !validClient(args.ClientID, args.ClientSecret) {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "invalid client"})
+			return
+		}
+
+		verifier, err := pkceRepo.TakeVerifier(args.State)
+
+		if err != nil {
+			context.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		context.Header("Cache-Control", "no-store")
+		context.JSON(http.StatusOK, gin.H{"code_verifier": verifier})
 	})
 }
 
-func genCodeChallengeS256() string {
-	s256 := sha256.Sum256([]byte(os.Getenv("CODE_CHALLENGE")))
-	return base64.URLEncoding.EncodeToString(s256[:])
+func validClient(clientID string, clientSecret string) bool {
+	expectedID := os.Getenv("AUTH_CLIENT_ID")
+	expectedSecret := os.Getenv("AUTH_CLIENT_SECRET")
+
+	if expectedID == "" || expectedSecret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(clientID), []byte(expectedID)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(expectedSecret)) == 1
 }
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"main/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const outboxPageSize = 50
+
+// CreateOutboxHandler lets admins see emails that are waiting or failed to
+// send, and send dead ones again.
+func CreateOutboxHandler(router *gin.Engine, provider services.ServiceProviderType) {
+	userValidator := provider.GetUserValidator()
+	outbox := provider.GetOutbox()
+
+	admin := router.Group("/admin", func(context *gin.Context) {
+		user, ok := userValidator.Validate(context)
+
+		if !ok {
+			context.Abort()
+			return
+		}
+
+		if !user.HasRole(services.AdminRole) {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin required"})
+			return
+		}
+	})
+
+	admin.GET("/outbox", func(context *gin.Context) {
+		status := context.Query("status")
+
+		switch status {
+		case "", services.OutboxPending, services.OutboxSent, services.OutboxDead:
+		default:
+			context.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+			return
+		}
+
+		page, pageErr := strconv.Atoi(context.DefaultQuery("page", "1"))
+
+		if pageErr != nil || page < 1 {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+			return
+		}
+
+		messages, total := outbox.List(status, (page-1)*outboxPageSize, outboxPageSize)
+
+		context.JSON(http.StatusOK, gin.H{
+			"messages": messages,
+			"total":    total,
+			"page":     page,
+		})
+	})
+
+	admin.POST("/outbox/:id/retry", func(context *gin.Context) {
+		id, idErr := strconv.ParseUint(context.Param("id"), 10, 64)
+
+		if idErr != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		message, messageErr := outbox.GetMessage(uint(id))
+
+		if messageErr != nil {
+			context.JSON(http.StatusNotFound, gin.H{"error": messageErr.Error()})
+			return
+		}
+
+		if message.Status == services.OutboxSent {
+			context.JSON(http.StatusConflict, gin.H{"error": "message already sent"})
+			return
+		}
+
+		if err := outbox.Requeue(message, time.Now()); err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		context.JSON(http.StatusOK, message)
+	})
+}
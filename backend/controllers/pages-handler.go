@@ -89,7 +89,6 @@ func CreatePagesHandlers(router *gin.Engine, provider services.ServiceProviderTy
 	profilesRepo := provider.GetProfilesRepo()
 	userValidator := provider.GetUserValidator()
 	bucketService := provider.GetBucketService()
-	emailService := provider.GetEmailService()
 
 	router.GET("/active-pages", func(context *gin.Context) {
 		pagesRepo.GetActiveSlugs()
@@ -170,13 +169,24 @@ func CreatePagesHandlers(router *gin.Engine, provider services.ServiceProviderTy
 				return
 			}
 
-			dbErr = pagesRepo.CreatePage(page, profile)
-			message = "created"
-			emailService.SendEmail(services.EmailArgs{
-				To:      "jeremiah.brem@gmail.com",
-				Subject: "Page Created",
-				Body:    fmt.Sprintf("Email: %s\n\nSlug: %s", user.Email, page.Slug),
+			dbErr = provider.Transaction(func(tx services.ServiceProviderType) error {
+				txPagesRepo := tx.GetPagesRepo()
+				txOutbox := tx.GetOutbox()
+
+				if err := txPagesRepo.CreatePage(page, profile); err != nil {
+					return err
+				}
+
+				return txOutbox.Enqueue(services.EmailArgs{
+					To:       "jeremiah.brem@gmail.com",
+					Template: "page-created",
+					Data: map[string]string{
+						"Email": user.Email,
+						"Slug":  page.Slug,
+					},
+				})
 			})
+			message = "created"
 
 		} else {
 			if exists := slugAlreadyExists(pagesRepo, context, profile, page.Slug); exists {
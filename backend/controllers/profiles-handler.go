@@ -32,7 +32,6 @@ func CreateProfilesHandlers(router *gin.Engine, provider services.ServiceProvide
 	profilesRepo := provider.GetProfilesRepo()
 	userValidator := provider.GetUserValidator()
 	bucketService := provider.GetBucketService()
-	emailService := provider.GetEmailService()
 
 	router.GET("/profile", func(context *gin.Context) {
 		user, ok := userValidator.Validate(context)
@@ -146,13 +145,25 @@ func CreateProfilesHandlers(router *gin.Engine, provider services.ServiceProvide
 		var message string
 
 		if existsErr != nil {
-			dbErr = profilesRepo.CreateProfile(profile, user.Email)
-			message = "created"
-			emailService.SendEmail(services.EmailArgs{
-				To:      "jeremiah.brem@gmail.com",
-				Subject: "Profile Created",
-				Body:    fmt.Sprintf("Email: %s\n\nName: %s\n\nType: %s", user.Email, user.Name, profile.Type),
+			dbErr = provider.Transaction(func(tx services.ServiceProviderType) error {
+				txProfilesRepo := tx.GetProfilesRepo()
+				txOutbox := tx.GetOutbox()
+
+				if err := txProfilesRepo.CreateProfile(profile, user.Email); err != nil {
+					return err
+				}
+
+				return txOutbox.Enqueue(services.EmailArgs{
+					To:       "jeremiah.brem@gmail.com",
+					Template: "profile-created",
+					Data: map[string]string{
+						"Email": user.Email,
+						"Name":  user.Name,
+						"Type":  profile.Type,
+					},
+				})
 			})
+			message = "created"
 
 		} else {
 			dbErr = profilesRepo.UpdateProfile(existing, profile)
@@ -17,15 +17,17 @@ func SetupRouter(
 
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowAllOrigins = true
-	corsConfig.AllowHeaders = []string{"Authorization", "token-provider"}
+	corsConfig.AllowHeaders = []string{"Authorization"}
 
 	router.Use(cors.New(corsConfig))
 
-	CreateLoginHandler(router)
+	CreateLoginHandler(router, serviceProvider)
 	CreatePagesHandlers(router, serviceProvider)
 	CreateProfilesHandlers(router, serviceProvider)
 	CreateImageUploadHandler(router, serviceProvider)
 	CreateContactHandler(router, serviceProvider)
+	CreateAccountHandler(router, serviceProvider)
+	CreateOutboxHandler(router, serviceProvider)
 
 	return router
 }
@@ -44,6 +44,8 @@ func initializeDb(db *gorm.DB, dbErr error) {
 		&Migration{},
 		&models.Image{},
 		&models.ProfileImage{},
+		&models.PKCEVerifier{},
+		&models.OutboxMessage{},
 	)
 
 	DB = Dbinstance{
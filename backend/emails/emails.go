@@ -0,0 +1,15 @@
+package emails
+
+import (
+	"embed"
+	"mailer"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Locales are the languages emails are written in.
+var Locales = []string{"en"}
+
+// Templates renders the emails in templates, see mailer.Templates.
+var Templates = mailer.NewTemplates(templateFiles, Locales)
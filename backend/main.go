@@ -2,10 +2,14 @@ package main
 
 import (
 	"log"
+	"mailer"
 	"main/controllers"
 	"main/database"
 	"main/services"
+	"os"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
@@ -14,15 +18,41 @@ func main() {
 
 	database.ConnectDb()
 
+	mailConfig, mailErr := mailer.LoadConfig()
+
+	if mailErr != nil {
+		log.Fatal("Failed to load mail config. \n", mailErr)
+	}
+
+	mailTransport := mailer.NewTransport(mailConfig)
+	emailService := services.CreateEmailService(mailTransport, mailConfig.From)
+
+	userValidator, validatorErr := services.CreateUserValidator()
+
+	if validatorErr != nil {
+		log.Fatal("Failed to configure token validation. \n", validatorErr)
+	}
+
 	provider := services.CreateProvider(
 		database.DB.Db,
-		&services.EmailService{},
-		&services.UserValidator{},
+		emailService,
+		userValidator,
 		&services.BucketService{},
 	)
 
 	router := controllers.SetupRouter(provider)
 
+	// handlers only queue emails, the worker sends them
+	outboxWorker := services.CreateOutboxWorker(provider.GetOutbox(), emailService, services.DefaultOutboxPolicy)
+	go outboxWorker.Run(time.Second*10, nil)
+
+	// only in development, when mail isn't being delivered, see
+	// mailer.MemoryTransport. Anyone could read sign in links from it.
+	if sink, ok := mailTransport.(*mailer.MemoryTransport); ok && os.Getenv("ENVIRONMENT") == "DEV" {
+		log.Println("Mail is kept in memory and served at /dev/mail")
+		router.Any("/dev/mail", gin.WrapH(sink))
+	}
+
 	router.Run(":8080")
 
 	log.Println("Server is available at http://localhost:8080")
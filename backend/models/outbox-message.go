@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// OutboxMessage is an email waiting to be sent, or the record of one that
+// was sent or gave up. It's written in the same transaction as the change
+// that caused it.
+type OutboxMessage struct {
+	ID            uint              `gorm:"primaryKey" json:"id"`
+	Status        string            `gorm:"not null; index:idx_outbox_due,priority:1" json:"status"`
+	Recipient     string            `gorm:"not null" json:"recipient"`
+	ReplyTo       string            `json:"reply_to"`
+	Locale        string            `json:"locale"`
+	Template      string            `gorm:"not null" json:"template"`
+	Data          map[string]string `gorm:"serializer:json; type:text" json:"data"`
+	Attempts      int               `gorm:"not null; default:0" json:"attempts"`
+	NextAttemptAt time.Time         `gorm:"index:idx_outbox_due,priority:2" json:"next_attempt_at"`
+	LastError     string            `json:"last_error"`
+	SentAt        *time.Time        `json:"sent_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
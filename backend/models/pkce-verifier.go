@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// PKCEVerifier holds the code verifier of a login started through /login
+// until the frontend exchanges the authorization code.
+type PKCEVerifier struct {
+	State     string `gorm:"primaryKey"`
+	Verifier  string `gorm:"not null"`
+	ExpiresAt time.Time
+}
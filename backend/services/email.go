@@ -1,92 +1,62 @@
 package services
 
 import (
-	"crypto/tls"
-	"fmt"
-	"net"
+	"mailer"
+	"main/emails"
 	"net/mail"
-	"net/smtp"
-	"os"
+	"time"
 )
 
+// EmailArgs names the template to send and its data. Locale picks the
+// translation, see emails.Templates.Render.
 type EmailArgs struct {
-	To      string
-	Subject string
-	Body    string
+	To       string
+	ReplyTo  string
+	Locale   string
+	Template string
+	Data     map[string]string
 }
 
 type EmailServiceType interface {
 	SendEmail(args EmailArgs) error
 }
 
-type EmailService struct{}
-
-func (emailService *EmailService) SendEmail(args EmailArgs) error {
-	from := mail.Address{Name: "HomeTrainers.net", Address: "support@hometrainers.net"}
-	to := mail.Address{Name: "", Address: args.To}
-	subj := args.Subject
-	body := args.Body
-
-	headers := make(map[string]string)
-	headers["From"] = from.String()
-	headers["To"] = to.String()
-	headers["Subject"] = subj
-
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + body
-
-	servername := "smtp.zoho.com:465"
-
-	host, _, _ := net.SplitHostPort(servername)
-
-	auth := smtp.PlainAuth("", "support@hometrainers.net", os.Getenv("MAIL_PASSWORD"), host)
+type EmailService struct {
+	transport mailer.Transport
+	from      mail.Address
+}
 
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
-	}
+func CreateEmailService(transport mailer.Transport, from mail.Address) *EmailService {
+	return &EmailService{transport, from}
+}
 
-	conn, err := tls.Dial("tcp", servername, tlsconfig)
+func (emailService *EmailService) SendEmail(args EmailArgs) error {
+	to, err := mail.ParseAddress(args.To)
 	if err != nil {
 		return err
 	}
 
-	c, err := smtp.NewClient(conn, host)
+	content, err := emails.Templates.Render(args.Template, args.Locale, args.Data)
 	if err != nil {
 		return err
 	}
 
-	if err = c.Auth(auth); err != nil {
-		return err
-	}
-
-	if err = c.Mail(from.Address); err != nil {
-		return err
+	message := mailer.Message{
+		From:    emailService.from,
+		To:      *to,
+		Date:    time.Now(),
+		Content: content,
 	}
 
-	if err = c.Rcpt(to.Address); err != nil {
-		return err
+	// an unparseable reply address is dropped rather than failing the send
+	if replyTo, err := mail.ParseAddress(args.ReplyTo); err == nil {
+		message.ReplyTo = replyTo
 	}
 
-	w, err := c.Data()
+	data, err := message.Bytes()
 	if err != nil {
 		return err
 	}
 
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		return err
-	}
-
-	err = w.Close()
-	if err != nil {
-		return err
-	}
-
-	c.Quit()
-
-	return nil
+	return emailService.transport.Send(emailService.from.Address, []string{to.Address}, data)
 }
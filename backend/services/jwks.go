@@ -0,0 +1,165 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrKeySetUnavailable = errors.New("auth server key set unavailable")
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type KeySetCacheType interface {
+	GetKey(kid string) (crypto.PublicKey, error)
+}
+
+// KeySetCache keeps the auth server's published signing keys in memory. The
+// set is refetched once the ttl passes, or early when a token names a key id
+// that isn't cached yet, which is what happens right after a key rotation.
+type KeySetCache struct {
+	url         string
+	ttl         time.Duration
+	minRefetch  time.Duration
+	client      *http.Client
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+func CreateKeySetCache(url string, ttl time.Duration) *KeySetCache {
+	return &KeySetCache{
+		url:        url,
+		ttl:        ttl,
+		minRefetch: time.Minute,
+		client:     &http.Client{Timeout: time.Second * 10},
+		keys:       map[string]crypto.PublicKey{},
+	}
+}
+
+func (cache *KeySetCache) GetKey(kid string) (crypto.PublicKey, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	key, ok := cache.keys[kid]
+	stale := now.Sub(cache.fetchedAt) > cache.ttl
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if now.Sub(cache.lastAttempt) >= cache.minRefetch || stale {
+		cache.lastAttempt = now
+
+		if err := cache.refresh(); err != nil {
+			// keep serving the cached set when the auth server is briefly unreachable
+			if ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("%w: %s", ErrKeySetUnavailable, err.Error())
+		}
+	}
+
+	if key, ok = cache.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	return key, nil
+}
+
+func (cache *KeySetCache) refresh() error {
+	resp, err := cache.client.Get(cache.url)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, jwk := range jwks.Keys {
+		key, keyErr := jwk.PublicKey()
+		if keyErr != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	cache.keys = keys
+	cache.fetchedAt = time.Now()
+
+	return nil
+}
+
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, nErr := decodeSegment(jwk.N)
+		e, eErr := decodeSegment(jwk.E)
+		if nErr != nil || eErr != nil {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, xErr := decodeSegment(jwk.X)
+		y, yErr := decodeSegment(jwk.Y)
+		if xErr != nil || yErr != nil {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+func decodeSegment(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(value)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+var ErrOutboxMessageNotFound = errors.New("outbox message not found")
+
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func CreateOutboxRepo(db *gorm.DB) OutboxRepository {
+	return OutboxRepository{db}
+}
+
+// Enqueue stores an email for the worker to send. Called on a repository
+// from provider.Transaction, the email is only sent if the transaction
+// commits.
+func (repo *OutboxRepository) Enqueue(args EmailArgs) error {
+	return repo.db.Create(&models.OutboxMessage{
+		Status:        OutboxPending,
+		Recipient:     args.To,
+		ReplyTo:       args.ReplyTo,
+		Locale:        args.Locale,
+		Template:      args.Template,
+		Data:          args.Data,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// Due returns up to limit pending messages whose next attempt is at or
+// before now, oldest first.
+func (repo *OutboxRepository) Due(now time.Time, limit int) []models.OutboxMessage {
+	due := []models.OutboxMessage{}
+
+	repo.db.
+		Where("status = ? and next_attempt_at <= ?", OutboxPending, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&due)
+
+	return due
+}
+
+// Claim counts an attempt for the message and pushes its next attempt back to
+// until, so other workers skip it while it's being sent. It's false when
+// another worker claimed the message since it was read, which the attempts
+// it read no longer matching shows.
+func (repo *OutboxRepository) Claim(message *models.OutboxMessage, until time.Time) bool {
+	result := repo.db.Model(&models.OutboxMessage{}).
+		Where("id = ? and status = ? and attempts = ?", message.ID, OutboxPending, message.Attempts).
+		Updates(map[string]interface{}{
+			"attempts":        message.Attempts + 1,
+			"next_attempt_at": until,
+		})
+
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false
+	}
+
+	message.Attempts++
+	message.NextAttemptAt = until
+
+	return true
+}
+
+func (repo *OutboxRepository) MarkSent(message *models.OutboxMessage, now time.Time) error {
+	message.Status = OutboxSent
+	message.SentAt = &now
+	message.LastError = ""
+
+	return repo.db.Save(message).Error
+}
+
+func (repo *OutboxRepository) Retry(message *models.OutboxMessage, sendErr error, at time.Time) error {
+	message.NextAttemptAt = at
+	message.LastError = sendErr.Error()
+
+	return repo.db.Save(message).Error
+}
+
+// DeadLetter stops retrying the message until an admin requeues it.
+func (repo *OutboxRepository) DeadLetter(message *models.OutboxMessage, sendErr error) error {
+	message.Status = OutboxDead
+	message.LastError = sendErr.Error()
+
+	return repo.db.Save(message).Error
+}
+
+// Requeue makes a message due now with a fresh set of attempts. The last
+// error is kept until it's sent.
+func (repo *OutboxRepository) Requeue(message *models.OutboxMessage, now time.Time) error {
+	message.Status = OutboxPending
+	message.Attempts = 0
+	message.NextAttemptAt = now
+
+	return repo.db.Save(message).Error
+}
+
+func (repo *OutboxRepository) GetMessage(id uint) (*models.OutboxMessage, error) {
+	var message models.OutboxMessage
+
+	if err := repo.db.First(&message, id).Error; err != nil {
+		return nil, ErrOutboxMessageNotFound
+	}
+
+	return &message, nil
+}
+
+// List returns messages newest first, filtered by status when it isn't
+// empty, with the total number of matches.
+func (repo *OutboxRepository) List(status string, offset int, limit int) ([]models.OutboxMessage, int64) {
+	query := repo.db.Model(&models.OutboxMessage{})
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	messages := []models.OutboxMessage{}
+	query.Order("id desc").Offset(offset).Limit(limit).Find(&messages)
+
+	return messages, total
+}
+
+// DeleteSent removes messages sent before the given time. Dead messages are
+// kept until they're requeued and sent.
+func (repo *OutboxRepository) DeleteSent(before time.Time) int64 {
+	return repo.db.
+		Where("status = ? and sent_at < ?", OutboxSent, before).
+		Delete(&models.OutboxMessage{}).
+		RowsAffected
+}
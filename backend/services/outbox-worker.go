@@ -0,0 +1,123 @@
+package services
+
+import (
+	"log"
+	"main/models"
+	"time"
+)
+
+// outboxLease has to outlast a single send, including the SMTP timeout.
+const (
+	outboxLease     = time.Minute * 2
+	outboxBatchSize = 50
+	outboxRetention = time.Hour * 24 * 30
+)
+
+// OutboxPolicy is how often a message is tried before it's dead lettered,
+// and the backoff between attempts, which doubles from BaseDelay up to
+// MaxDelay.
+type OutboxPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultOutboxPolicy gives up after roughly an hour of retries.
+var DefaultOutboxPolicy = OutboxPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   time.Second * 30,
+	MaxDelay:    time.Hour,
+}
+
+// Backoff is the delay after the given number of failed attempts.
+func (policy OutboxPolicy) Backoff(attempts int) time.Duration {
+	delay := policy.BaseDelay
+
+	for i := 1; i < attempts && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+
+	return delay
+}
+
+type OutboxWorker struct {
+	outbox       OutboxRepository
+	emailService EmailServiceType
+	policy       OutboxPolicy
+}
+
+func CreateOutboxWorker(outbox OutboxRepository, emailService EmailServiceType, policy OutboxPolicy) *OutboxWorker {
+	return &OutboxWorker{outbox, emailService, policy}
+}
+
+// ProcessDue sends the messages due at now and returns how many were sent.
+// Each message is leased just before it's sent, so a slow batch can't
+// outlive the lease and have its later messages sent by another worker.
+// Delivery is at least once: a worker stopped between sending and marking
+// the message sent will send it again once the lease runs out.
+func (worker *OutboxWorker) ProcessDue(now time.Time) int {
+	sent := 0
+	started := time.Now()
+
+	for _, message := range worker.outbox.Due(now, outboxBatchSize) {
+		message := message
+
+		if !worker.outbox.Claim(&message, now.Add(time.Since(started)+outboxLease)) {
+			continue
+		}
+
+		err := worker.emailService.SendEmail(outboxEmailArgs(&message))
+
+		switch {
+		case err == nil:
+			worker.outbox.MarkSent(&message, now)
+			sent++
+		case message.Attempts >= worker.policy.MaxAttempts:
+			log.Printf("giving up on outbox message %d after %d attempts: %s", message.ID, message.Attempts, err)
+			worker.outbox.DeadLetter(&message, err)
+		default:
+			worker.outbox.Retry(&message, err, now.Add(worker.policy.Backoff(message.Attempts)))
+		}
+	}
+
+	return sent
+}
+
+// Run processes the outbox every interval, and prunes old sent messages
+// hourly, until done is closed.
+func (worker *OutboxWorker) Run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pruned time.Time
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+
+			worker.ProcessDue(now)
+
+			if now.Sub(pruned) >= time.Hour {
+				worker.outbox.DeleteSent(now.Add(-outboxRetention))
+				pruned = now
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func outboxEmailArgs(message *models.OutboxMessage) EmailArgs {
+	return EmailArgs{
+		To:       message.Recipient,
+		ReplyTo:  message.ReplyTo,
+		Locale:   message.Locale,
+		Template: message.Template,
+		Data:     message.Data,
+	}
+}
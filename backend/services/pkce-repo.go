@@ -0,0 +1,76 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"main/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const pkceVerifierTTL = time.Minute * 10
+
+var ErrVerifierNotFound = errors.New("code verifier not found")
+
+type PKCERepository struct {
+	db *gorm.DB
+}
+
+func CreatePKCERepo(db *gorm.DB) PKCERepository {
+	return PKCERepository{db}
+}
+
+// CreateChallenge stores a new verifier under state and returns its S256
+// challenge.
+func (repo *PKCERepository) CreateChallenge(state string) (string, error) {
+	now := time.Now()
+
+	repo.db.Where("expires_at < ?", now).Delete(&models.PKCEVerifier{})
+
+	verifier := GenerateCodeVerifier()
+
+	err := repo.db.Save(&models.PKCEVerifier{
+		State:     state,
+		Verifier:  verifier,
+		ExpiresAt: now.Add(pkceVerifierTTL),
+	}).Error
+
+	if err != nil {
+		return "", err
+	}
+
+	return CodeChallengeS256(verifier), nil
+}
+
+// TakeVerifier returns the verifier for state once.
+func (repo *PKCERepository) TakeVerifier(state string) (string, error) {
+	var verifier models.PKCEVerifier
+
+	if err := repo.db.Where("state = ?", state).First(&verifier).Error; err != nil {
+		return "", ErrVerifierNotFound
+	}
+
+	repo.db.Delete(&verifier)
+
+	if verifier.ExpiresAt.Before(time.Now()) {
+		return "", ErrVerifierNotFound
+	}
+
+	return verifier.Verifier, nil
+}
+
+// GenerateCodeVerifier returns 43 characters of base64url encoded entropy,
+// the shortest verifier RFC 7636 allows.
+func GenerateCodeVerifier() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func CodeChallengeS256(verifier string) string {
+	s256 := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(s256[:])
+}
@@ -221,3 +221,47 @@ func GetUniqueProfiles(profiles []*models.Profile) []*models.Profile {
 
 	return uniqueProfiles
 }
+
+// DeleteAccount removes the profile, its page and the image records of
+// email. The images themselves are left for the caller to delete.
+func (repo *ProfileRepository) DeleteAccount(email string) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var profile models.Profile
+
+		if err := tx.Where("email = ?", email).First(&profile).Error; err == nil {
+			if err := tx.Model(&profile).Association("Cities").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Model(&profile).Association("Goals").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("profile_id = ?", profile.ID).Delete(&models.Page{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&profile).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Where("email = ?", email).Delete(&models.Image{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Where("email = ?", email).Delete(&models.ProfileImage{}).Error
+	})
+}
+
+// ChangeEmail moves the profile and image records of email to newEmail.
+func (repo *ProfileRepository) ChangeEmail(email string, newEmail string) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Profile{}).Where("email = ?", email).Update("email", newEmail).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Image{}).Where("email = ?", email).Update("email", newEmail).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ProfileImage{}).Where("email = ?", email).Update("email", newEmail).Error
+	})
+}
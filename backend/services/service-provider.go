@@ -8,14 +8,20 @@ type ServiceProviderType interface {
 	GetEmailService() EmailServiceType
 	GetUserValidator() UserValidatorType
 	GetBucketService() BucketServiceType
+	GetPKCERepo() PKCERepository
+	GetOutbox() OutboxRepository
+	Transaction(fn func(tx ServiceProviderType) error) error
 }
 
 type ServiceProvider struct {
+	db            *gorm.DB
 	pagesRepo     PageRepository
 	profilesRepo  ProfileRepository
 	emailService  EmailServiceType
 	userValidator UserValidatorType
 	bucketService BucketServiceType
+	pkceRepo      PKCERepository
+	outbox        OutboxRepository
 }
 
 func (provider *ServiceProvider) GetPagesRepo() PageRepository {
@@ -33,6 +39,20 @@ func (provider *ServiceProvider) GetUserValidator() UserValidatorType {
 func (provider *ServiceProvider) GetBucketService() BucketServiceType {
 	return provider.bucketService
 }
+func (provider *ServiceProvider) GetPKCERepo() PKCERepository {
+	return provider.pkceRepo
+}
+func (provider *ServiceProvider) GetOutbox() OutboxRepository {
+	return provider.outbox
+}
+
+// Transaction runs fn with a provider whose repositories share one
+// transaction, committed when fn returns nil.
+func (provider *ServiceProvider) Transaction(fn func(tx ServiceProviderType) error) error {
+	return provider.db.Transaction(func(tx *gorm.DB) error {
+		return fn(createProvider(tx, provider.emailService, provider.userValidator, provider.bucketService))
+	})
+}
 
 func CreateProvider(
 	db *gorm.DB,
@@ -40,11 +60,23 @@ func CreateProvider(
 	userValidator UserValidatorType,
 	bucketService BucketServiceType,
 ) ServiceProviderType {
+	return createProvider(db, emailService, userValidator, bucketService)
+}
+
+func createProvider(
+	db *gorm.DB,
+	emailService EmailServiceType,
+	userValidator UserValidatorType,
+	bucketService BucketServiceType,
+) *ServiceProvider {
 	return &ServiceProvider{
+		db:            db,
 		pagesRepo:     PageRepository{db},
 		profilesRepo:  ProfileRepository{db},
 		emailService:  emailService,
 		userValidator: userValidator,
 		bucketService: bucketService,
+		pkceRepo:      PKCERepository{db},
+		outbox:        OutboxRepository{db},
 	}
 }
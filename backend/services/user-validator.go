@@ -1,141 +1,314 @@
 package services
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"google.golang.org/api/idtoken"
+	"github.com/golang-jwt/jwt/v5"
 )
 
+const AdminRole = "admin"
+
 type User struct {
-	Email string `json:"email" binding:"required"`
-	Name  string `json:"name" binding:"required"`
+	Email string   `json:"email" binding:"required"`
+	Name  string   `json:"name" binding:"required"`
+	Roles []string `json:"roles"`
 }
 
-type GoogleValidatorType interface {
-	Validate(ctx context.Context, idToken string, audience string) (*idtoken.Payload, error)
+func (user User) HasRole(role string) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
 
-type GoogleValidator struct{}
+type AuthValidatorType interface {
+	Validate(token string) (User, error)
+}
 
-func (validator *GoogleValidator) Validate(
-	ctx context.Context,
-	idToken string,
-	audience string,
-) (*idtoken.Payload, error) {
-	return idtoken.Validate(ctx, idToken, audience)
+// LocalAuthValidator verifies auth server access tokens against the auth
+// server's published signing keys without a round trip per request.
+type LocalAuthValidator struct {
+	keys      KeySetCacheType
+	issuer    string
+	audiences []string
 }
 
-type AuthValidatorType interface {
-	Validate(token string) (*http.Response, error)
+func CreateLocalAuthValidator(keys KeySetCacheType, issuer string, audiences []string) *LocalAuthValidator {
+	return &LocalAuthValidator{keys, issuer, audiences}
 }
 
-type AuthValidator struct{}
+func (validator *LocalAuthValidator) Validate(tokenString string) (User, error) {
+	user := User{}
 
-func (validator *AuthValidator) Validate(token string) (*http.Response, error) {
-	authServerURL := os.Getenv("AUTH_SERVER_URL")
-	resp, err := http.Get(fmt.Sprintf("%s/validate?access_token=%s", authServerURL, token))
-	return resp, err
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return validator.keys.GetKey(kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "ES384"}),
+		jwt.WithIssuer(validator.issuer),
+	)
+
+	if err != nil {
+		return user, err
+	}
+
+	if exp, expErr := claims.GetExpirationTime(); expErr != nil || exp == nil {
+		return user, errors.New("token is missing an expiration")
+	}
+
+	if !validAudience(claims, validator.audiences) {
+		return user, errors.New("token has invalid audience")
+	}
+
+	user.Email, _ = claims["email"].(string)
+	user.Name, _ = claims["name"].(string)
+
+	roles, _ := claims["roles"].([]interface{})
+	for _, role := range roles {
+		if r, ok := role.(string); ok {
+			user.Roles = append(user.Roles, r)
+		}
+	}
+
+	if user.Email == "" {
+		user.Email, _ = claims["sub"].(string)
+	}
+
+	return user, nil
 }
 
-type UserValidatorType interface {
-	Validate(context *gin.Context) (User, bool)
+func validAudience(claims jwt.MapClaims, audiences []string) bool {
+	tokenAudiences, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+
+	for _, aud := range tokenAudiences {
+		if containsAudience(audiences, aud) {
+			return true
+		}
+	}
+
+	return false
 }
 
-type UserValidator struct{}
+func containsAudience(audiences []string, audience string) bool {
+	for _, allowed := range audiences {
+		if audience != "" && audience == allowed {
+			return true
+		}
+	}
 
-func (validator *UserValidator) Validate(context *gin.Context) (User, bool) {
-	return GetAuthorizedUser(context, &AuthValidator{}, &GoogleValidator{})
+	return false
 }
 
-func GetAuthorizedUser(
-	context *gin.Context,
-	authVal AuthValidatorType,
-	googleVal GoogleValidatorType,
-) (User, bool) {
-	providerHeader := context.Request.Header["Token-Provider"]
+// IntrospectionAuthValidator checks the token with the auth server's RFC 7662
+// introspection endpoint, so revoked tokens are rejected immediately. Like
+// local validation, only tokens issued to one of audiences are accepted.
+type IntrospectionAuthValidator struct {
+	url          string
+	clientID     string
+	clientSecret string
+	audiences    []string
+	client       *http.Client
+}
 
+func CreateIntrospectionAuthValidator(url string, clientID string, clientSecret string, audiences []string) *IntrospectionAuthValidator {
+	return &IntrospectionAuthValidator{
+		url:          url,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		audiences:    audiences,
+		client:       &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (validator *IntrospectionAuthValidator) Validate(token string) (User, error) {
 	user := User{}
-	valid := false
 
-	if len(providerHeader) == 0 {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "invalid token provider header"})
-		return user, false
+	form := url.Values{}
+	form.Add("token", token)
+	form.Add("token_type_hint", "access_token")
+
+	req, reqErr := http.NewRequest("POST", validator.url, strings.NewReader(form.Encode()))
+	if reqErr != nil {
+		return user, reqErr
 	}
 
-	if providerHeader[0] != "auth" && providerHeader[0] != "google" {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "invalid token provider header"})
-		return user, false
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(validator.clientID, validator.clientSecret)
+
+	resp, err := validator.client.Do(req)
+	if err != nil {
+		return user, err
+	}
+
+	defer resp.Body.Close()
+
+	body, bodyErr := io.ReadAll(resp.Body)
+	if bodyErr != nil {
+		return user, bodyErr
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return user, fmt.Errorf("token introspection failed: %s", string(body))
 	}
 
-	provider := providerHeader[0]
+	var result struct {
+		Active    bool     `json:"active"`
+		TokenType string   `json:"token_type"`
+		ClientID  string   `json:"client_id"`
+		Email     string   `json:"email"`
+		Name      string   `json:"name"`
+		Roles     []string `json:"roles"`
+	}
 
-	if provider == "auth" {
-		user, valid = getAuthUser(context, authVal)
+	if jsonErr := json.Unmarshal(body, &result); jsonErr != nil {
+		return user, jsonErr
 	}
-	if provider == "google" {
-		user, valid = getGoogleUser(context, googleVal)
+
+	if !result.Active || result.TokenType == "refresh_token" {
+		return user, errors.New("token is not active")
 	}
 
-	return user, valid
+	if !containsAudience(validator.audiences, result.ClientID) {
+		return user, errors.New("token has invalid audience")
+	}
+
+	user.Email = result.Email
+	user.Name = result.Name
+	user.Roles = result.Roles
+
+	return user, nil
 }
 
-func invalidAuth(context *gin.Context, user User) (User, bool) {
-	context.JSON(http.StatusBadRequest, gin.H{"error": "invalid authorization header"})
-	return user, false
+// AuthValidator verifies tokens locally and, when enabled, falls back to
+// introspection if the signing keys can't be fetched.
+type AuthValidator struct {
+	local    AuthValidatorType
+	remote   AuthValidatorType
+	fallback bool
 }
 
-func getAuthUser(
-	context *gin.Context,
-	validator AuthValidatorType,
-) (User, bool) {
-	user := User{}
+func CreateAuthValidator(local AuthValidatorType, remote AuthValidatorType, fallback bool) *AuthValidator {
+	return &AuthValidator{local, remote, fallback}
+}
 
-	token := getToken(context)
+func (validator *AuthValidator) Validate(token string) (User, error) {
+	user, err := validator.local.Validate(token)
 
-	if token == "" {
-		return invalidAuth(context, user)
+	if err != nil && validator.fallback && errors.Is(err, ErrKeySetUnavailable) {
+		log.Println("falling back to remote token validation:", err.Error())
+		return validator.remote.Validate(token)
 	}
 
-	resp, err := validator.Validate(token)
+	return user, err
+}
 
-	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return user, false
+type UserValidatorType interface {
+	Validate(context *gin.Context) (User, bool)
+}
+
+type UserValidator struct {
+	authValidator AuthValidatorType
+}
+
+var ErrAudienceRequired = errors.New("AUTH_AUDIENCE is required to validate tokens")
+
+// CreateUserValidator fails without AUTH_AUDIENCE, since every token would be
+// rejected.
+func CreateUserValidator() (*UserValidator, error) {
+	authServerURL := os.Getenv("AUTH_SERVER_URL")
+
+	issuer := os.Getenv("AUTH_ISSUER")
+	if issuer == "" {
+		issuer = authServerURL
 	}
 
-	defer resp.Body.Close()
+	audiences := ParseAudiences(os.Getenv("AUTH_AUDIENCE"))
 
-	body, bodyErr := ioutil.ReadAll(resp.Body)
+	if len(audiences) == 0 {
+		return nil, ErrAudienceRequired
+	}
 
-	if bodyErr != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": bodyErr.Error()})
-		return user, false
+	remote := CreateIntrospectionAuthValidator(
+		fmt.Sprintf("%s/oauth/introspect", authServerURL),
+		os.Getenv("AUTH_CLIENT_ID"),
+		os.Getenv("AUTH_CLIENT_SECRET"),
+		audiences,
+	)
+
+	// AUTH_TOKEN_VALIDATION=introspect trades a round trip per request for
+	// seeing revocations before the access token expires
+	if os.Getenv("AUTH_TOKEN_VALIDATION") == "introspect" {
+		return &UserValidator{authValidator: remote}, nil
 	}
 
-	jsonErr := json.Unmarshal(body, &user)
+	keys := CreateKeySetCache(fmt.Sprintf("%s/.well-known/jwks.json", authServerURL), time.Hour)
 
-	if jsonErr != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": jsonErr.Error()})
-		return user, false
+	local := CreateLocalAuthValidator(keys, issuer, audiences)
+
+	fallback := os.Getenv("AUTH_REMOTE_FALLBACK") == "true"
+
+	return &UserValidator{
+		authValidator: CreateAuthValidator(local, remote, fallback),
+	}, nil
+}
+
+// ParseAudiences splits a comma separated list, dropping empty entries.
+func ParseAudiences(value string) []string {
+	audiences := []string{}
+
+	for _, audience := range strings.Split(value, ",") {
+		if audience = strings.TrimSpace(audience); audience != "" {
+			audiences = append(audiences, audience)
+		}
 	}
 
-	return user, true
+	return audiences
+}
+
+func (validator *UserValidator) Validate(context *gin.Context) (User, bool) {
+	return GetAuthorizedUser(context, validator.authValidator)
 }
 
-func getGoogleUser(
+// GetAuthorizedUser reads the user from the request's auth server access
+// token. Sign in with other providers is brokered by the auth server, so its
+// tokens are the only ones accepted.
+func GetAuthorizedUser(
 	context *gin.Context,
-	validator GoogleValidatorType,
+	authVal AuthValidatorType,
 ) (User, bool) {
-	user := User{}
+	return getAuthUser(context, authVal)
+}
+
+func invalidAuth(context *gin.Context, user User) (User, bool) {
+	context.JSON(http.StatusBadRequest, gin.H{"error": "invalid authorization header"})
+	return user, false
+}
 
-	clientId := os.Getenv("GOOGLE_CLIENT_ID")
+func getAuthUser(
+	context *gin.Context,
+	validator AuthValidatorType,
+) (User, bool) {
+	user := User{}
 
 	token := getToken(context)
 
@@ -143,23 +316,13 @@ func getGoogleUser(
 		return invalidAuth(context, user)
 	}
 
-	payload, err := validator.Validate(context, token, clientId)
+	user, err := validator.Validate(token)
 
 	if err != nil {
 		context.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return user, false
 	}
 
-	emailVerified := fmt.Sprintf("%v", payload.Claims["email_verified"])
-
-	if emailVerified != "true" {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "email not verifed"})
-		return user, false
-	}
-
-	user.Email = fmt.Sprintf("%v", payload.Claims["email"])
-	user.Name = fmt.Sprintf("%v", payload.Claims["name"])
-
 	return user, true
 }
 
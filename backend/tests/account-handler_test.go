@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"main/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/datatypes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var accountEmail = "trainer@example.com"
+
+func SetupAccountTests(t *testing.T) *gorm.DB {
+	t.Setenv("AUTH_CLIENT_ID", "client-id")
+	t.Setenv("AUTH_CLIENT_SECRET", "client-secret")
+
+	db, _ := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+
+	db.AutoMigrate(
+		&models.Page{},
+		&models.City{},
+		&models.Goal{},
+		&models.Profile{},
+		&models.Image{},
+		&models.ProfileImage{},
+	)
+
+	profile := models.Profile{
+		Email:  accountEmail,
+		Name:   "Trainer",
+		Type:   "trainer",
+		Cities: []*models.City{{Name: "Tulsa"}},
+		Goals:  []*models.Goal{{Name: "Strength"}},
+	}
+	db.Create(&profile)
+
+	db.Create(&models.Page{
+		Slug:        "trainer",
+		Blocks:      datatypes.JSON([]byte(`{"blocks": []}`)),
+		Title:       "Trainer",
+		Description: "Trainer page",
+		ProfileID:   profile.ID,
+	})
+	db.Create(&models.Image{Path: "page-image.png", Email: accountEmail})
+	db.Create(&models.ProfileImage{Path: "profile-image.png", Email: accountEmail})
+
+	return db
+}
+
+func TeardownAccountTests(db *gorm.DB) {
+	sql := `
+		delete from pages;
+		delete from city_profiles;
+		delete from goal_profiles;
+		delete from profiles;
+		delete from images;
+		delete from profile_images;
+		delete from goals;
+		delete from cities;
+	`
+	db.Exec(sql)
+}
+
+func SendAccountRequest(router http.Handler, method string, email string, body interface{}, secret string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	encoded, _ := json.Marshal(body)
+
+	req, _ := http.NewRequest(method, "/accounts/"+url.PathEscape(email), bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("client-id", secret)
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestDeleteAccount(t *testing.T) {
+	db := SetupAccountTests(t)
+	defer TeardownAccountTests(db)
+
+	bucketService := &MockBucketService{}
+
+	router := SetupRouter(db, bucketService)
+
+	w := SendAccountRequest(router, "DELETE", accountEmail, nil, "client-secret")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	var count int64
+
+	db.Unscoped().Model(&models.Profile{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	db.Unscoped().Model(&models.Page{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	db.Unscoped().Model(&models.Image{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	db.Unscoped().Model(&models.ProfileImage{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	db.Table("city_profiles").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	assert.Equal(t, "profile-image.png", bucketService.NameArg)
+}
+
+func TestDeleteAccountWithoutProfile(t *testing.T) {
+	db := SetupAccountTests(t)
+	defer TeardownAccountTests(db)
+
+	router := SetupRouter(db)
+
+	w := SendAccountRequest(router, "DELETE", "client@example.com", nil, "client-secret")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	var count int64
+	db.Model(&models.Profile{}).Count(&count)
+
+	assert.Equal(t, int64(1), count)
+}
+
+func TestChangeAccountEmail(t *testing.T) {
+	db := SetupAccountTests(t)
+	defer TeardownAccountTests(db)
+
+	router := SetupRouter(db)
+
+	w := SendAccountRequest(router, "PUT", accountEmail, map[string]string{"email": "new@example.com"}, "client-secret")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	var profile models.Profile
+	db.Where("email = ?", "new@example.com").First(&profile)
+
+	assert.Equal(t, "Trainer", profile.Name)
+
+	var images []string
+	db.Model(&models.Image{}).Where("email = ?", "new@example.com").Pluck("path", &images)
+
+	assert.Equal(t, []string{"page-image.png"}, images)
+}
+
+func TestAccountRequiresClient(t *testing.T) {
+	db := SetupAccountTests(t)
+	defer TeardownAccountTests(db)
+
+	router := SetupRouter(db)
+
+	w := SendAccountRequest(router, "DELETE", accountEmail, nil, "wrong")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var count int64
+	db.Model(&models.Profile{}).Count(&count)
+
+	assert.Equal(t, int64(1), count)
+}
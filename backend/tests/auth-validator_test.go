@@ -0,0 +1,295 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/services"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer   = "http://localhost:9096"
+	testAudience = "222222"
+	testKid      = "test-kid"
+)
+
+func CreateJWKSServer(key *rsa.PrivateKey) *httptest.Server {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKid,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+}
+
+func SignToken(key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKid
+
+	signed, _ := token.SignedString(key)
+
+	return signed
+}
+
+func ValidClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"sub":   "test@example.com",
+		"aud":   testAudience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"email": "test@example.com",
+		"name":  "Tester",
+	}
+}
+
+func SetupLocalValidator(t *testing.T) (*rsa.PrivateKey, *services.LocalAuthValidator) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	server := CreateJWKSServer(key)
+	t.Cleanup(server.Close)
+
+	cache := services.CreateKeySetCache(server.URL, time.Hour)
+
+	return key, services.CreateLocalAuthValidator(cache, testIssuer, []string{testAudience})
+}
+
+func TestLocalValidatorValidToken(t *testing.T) {
+	key, validator := SetupLocalValidator(t)
+
+	user, err := validator.Validate(SignToken(key, ValidClaims()))
+
+	assert.Nil(t, err)
+	assert.Equal(t, services.User{Email: "test@example.com", Name: "Tester"}, user)
+}
+
+func TestLocalValidatorReadsRoles(t *testing.T) {
+	key, validator := SetupLocalValidator(t)
+
+	claims := ValidClaims()
+	claims["roles"] = []string{services.AdminRole}
+
+	user, err := validator.Validate(SignToken(key, claims))
+
+	assert.Nil(t, err)
+	assert.True(t, user.HasRole(services.AdminRole))
+}
+
+func TestLocalValidatorExpiredToken(t *testing.T) {
+	key, validator := SetupLocalValidator(t)
+
+	claims := ValidClaims()
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+
+	_, err := validator.Validate(SignToken(key, claims))
+
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
+
+func TestLocalValidatorMissingExpiration(t *testing.T) {
+	key, validator := SetupLocalValidator(t)
+
+	claims := ValidClaims()
+	delete(claims, "exp")
+
+	_, err := validator.Validate(SignToken(key, claims))
+
+	assert.NotNil(t, err)
+}
+
+func TestLocalValidatorWrongAudience(t *testing.T) {
+	key, validator := SetupLocalValidator(t)
+
+	claims := ValidClaims()
+	claims["aud"] = "other-client"
+
+	_, err := validator.Validate(SignToken(key, claims))
+
+	assert.NotNil(t, err)
+}
+
+func TestLocalValidatorWrongIssuer(t *testing.T) {
+	key, validator := SetupLocalValidator(t)
+
+	claims := ValidClaims()
+	claims["iss"] = "https://evil.example.com"
+
+	_, err := validator.Validate(SignToken(key, claims))
+
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+}
+
+func TestLocalValidatorWrongKey(t *testing.T) {
+	_, validator := SetupLocalValidator(t)
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	_, err := validator.Validate(SignToken(other, ValidClaims()))
+
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+}
+
+func TestAuthValidatorFallback(t *testing.T) {
+	local := MockAuthValidator{
+		err: fmt.Errorf("%w: connection refused", services.ErrKeySetUnavailable),
+	}
+	remote := MockAuthValidator{
+		user: services.User{Email: "test@example.com", Name: "Tester"},
+	}
+
+	user, err := services.CreateAuthValidator(&local, &remote, true).Validate("test-token")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "test@example.com", user.Email)
+	assert.Equal(t, "test-token", remote.token)
+
+	remote.token = ""
+
+	_, err = services.CreateAuthValidator(&local, &remote, false).Validate("test-token")
+
+	assert.ErrorIs(t, err, services.ErrKeySetUnavailable)
+	assert.Equal(t, "", remote.token)
+}
+
+func TestAuthValidatorNoFallbackOnInvalidToken(t *testing.T) {
+	local := MockAuthValidator{err: errors.New("token is expired")}
+	remote := MockAuthValidator{}
+
+	_, err := services.CreateAuthValidator(&local, &remote, true).Validate("test-token")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "", remote.token)
+}
+
+func CreateIntrospectionServer(response map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != testAudience || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.ParseForm()
+		if r.PostForm.Get("token") != "test-token" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+			return
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestIntrospectionValidatorActiveToken(t *testing.T) {
+	server := CreateIntrospectionServer(map[string]interface{}{
+		"active":     true,
+		"token_type": "Bearer",
+		"client_id":  testAudience,
+		"email":      "test@example.com",
+		"name":       "Tester",
+	})
+	defer server.Close()
+
+	validator := services.CreateIntrospectionAuthValidator(server.URL, testAudience, "secret", []string{testAudience})
+
+	user, err := validator.Validate("test-token")
+
+	assert.Nil(t, err)
+	assert.Equal(t, services.User{Email: "test@example.com", Name: "Tester"}, user)
+
+	_, inactiveErr := validator.Validate("revoked-token")
+
+	assert.NotNil(t, inactiveErr)
+}
+
+func TestIntrospectionValidatorRejectsRefreshToken(t *testing.T) {
+	server := CreateIntrospectionServer(map[string]interface{}{
+		"active":     true,
+		"token_type": "refresh_token",
+		"client_id":  testAudience,
+		"email":      "test@example.com",
+	})
+	defer server.Close()
+
+	validator := services.CreateIntrospectionAuthValidator(server.URL, testAudience, "secret", []string{testAudience})
+
+	_, err := validator.Validate("test-token")
+
+	assert.NotNil(t, err)
+}
+
+func TestIntrospectionValidatorRejectsOtherClient(t *testing.T) {
+	server := CreateIntrospectionServer(map[string]interface{}{
+		"active":     true,
+		"token_type": "Bearer",
+		"client_id":  "other-client",
+		"email":      "test@example.com",
+	})
+	defer server.Close()
+
+	validator := services.CreateIntrospectionAuthValidator(server.URL, testAudience, "secret", []string{testAudience})
+
+	_, err := validator.Validate("test-token")
+
+	assert.NotNil(t, err)
+}
+
+func TestIntrospectionValidatorBadCredentials(t *testing.T) {
+	server := CreateIntrospectionServer(map[string]interface{}{"active": true})
+	defer server.Close()
+
+	validator := services.CreateIntrospectionAuthValidator(server.URL, testAudience, "wrong", []string{testAudience})
+
+	_, err := validator.Validate("test-token")
+
+	assert.NotNil(t, err)
+}
+
+func TestUserValidatorRequiresAudience(t *testing.T) {
+	t.Setenv("AUTH_TOKEN_VALIDATION", "")
+
+	for _, audience := range []string{"", " , "} {
+		t.Setenv("AUTH_AUDIENCE", audience)
+
+		_, err := services.CreateUserValidator()
+
+		assert.Equal(t, services.ErrAudienceRequired, err)
+	}
+
+	t.Setenv("AUTH_AUDIENCE", "first-party, ,other-client")
+
+	_, err := services.CreateUserValidator()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first-party", "other-client"}, services.ParseAudiences("first-party, ,other-client"))
+
+	t.Setenv("AUTH_AUDIENCE", "")
+	t.Setenv("AUTH_TOKEN_VALIDATION", "introspect")
+
+	_, err = services.CreateUserValidator()
+
+	assert.Equal(t, services.ErrAudienceRequired, err)
+
+	t.Setenv("AUTH_AUDIENCE", "first-party")
+
+	_, err = services.CreateUserValidator()
+
+	assert.Nil(t, err)
+}
@@ -22,7 +22,7 @@ func SetupContactTests() *gorm.DB {
 
 	db, _ := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 
-	db.AutoMigrate(&models.Page{}, &models.Goal{}, &models.Profile{})
+	db.AutoMigrate(&models.Page{}, &models.Goal{}, &models.Profile{}, &models.OutboxMessage{})
 
 	db.Exec(
 		"insert into profiles (email, name, type) values(?,?,?)",
@@ -44,6 +44,7 @@ func TeardownContactTests(db *gorm.DB) {
 		delete from images;
 		delete from pages;
 		delete from profiles;
+		delete from outbox_messages;
 	`
 	db.Exec(sql)
 }
@@ -75,13 +76,21 @@ func TestPostContact(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	expectedArgs := services.EmailArgs{
-		To:      "support@hometrainers.net",
-		Subject: "Contact Form",
-		Body:    "Message: This is a contact form message\n\nName: Tester\n\nEmail: test@example.com",
-	}
+	var queued models.OutboxMessage
+
+	db.Model(&models.OutboxMessage{}).First(&queued)
+
+	assert.Equal(t, services.OutboxPending, queued.Status)
+	assert.Equal(t, "support@hometrainers.net", queued.Recipient)
+	assert.Equal(t, `"Tester" <test@example.com>`, queued.ReplyTo)
+	assert.Equal(t, "contact", queued.Template)
+	assert.Equal(t, map[string]string{
+		"Message": "This is a contact form message",
+		"Name":    "Tester",
+		"Email":   "test@example.com",
+	}, queued.Data)
 
-	assert.Equal(t, expectedArgs, mockEmailService.Args)
+	assert.Equal(t, services.EmailArgs{}, mockEmailService.Args)
 }
 
 func TestPostContactTrainerPage(t *testing.T) {
@@ -123,11 +132,19 @@ func TestPostContactTrainerPage(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	expectedArgs := services.EmailArgs{
-		To:      trainerEmail,
-		Subject: "Contact Form",
-		Body:    "Message: This is a contact form message\n\nName: Tester\n\nEmail: test@example.com",
-	}
+	var queued models.OutboxMessage
+
+	db.Model(&models.OutboxMessage{}).First(&queued)
+
+	assert.Equal(t, services.OutboxPending, queued.Status)
+	assert.Equal(t, trainerEmail, queued.Recipient)
+	assert.Equal(t, `"Tester" <test@example.com>`, queued.ReplyTo)
+	assert.Equal(t, "contact", queued.Template)
+	assert.Equal(t, map[string]string{
+		"Message": "This is a contact form message",
+		"Name":    "Tester",
+		"Email":   "test@example.com",
+	}, queued.Data)
 
-	assert.Equal(t, expectedArgs, mockEmailService.Args)
+	assert.Equal(t, services.EmailArgs{}, mockEmailService.Args)
 }
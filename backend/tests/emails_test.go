@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"bytes"
+	"mailer"
+	"main/emails"
+	"main/services"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderEmails(t *testing.T) {
+	content, err := emails.Templates.Render("contact", "", map[string]string{
+		"Message": "<b>Hi</b>",
+		"Name":    "Tester",
+		"Email":   "test@example.com",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Contact Form", content.Subject)
+	assert.Equal(t, "Message: <b>Hi</b>\n\nName: Tester\n\nEmail: test@example.com\n", content.Text)
+	assert.Contains(t, content.HTML, "&lt;b&gt;Hi&lt;/b&gt;")
+
+	for _, name := range []string{"profile-created", "page-created"} {
+		content, err := emails.Templates.Render(name, "es", map[string]string{"Email": "test@example.com"})
+
+		assert.Nil(t, err, name)
+		assert.Contains(t, content.Text, "test@example.com", name)
+		assert.Contains(t, content.HTML, `lang="en"`, name)
+	}
+}
+
+func TestEmailHeaders(t *testing.T) {
+	content, _ := emails.Templates.Render("contact", "", map[string]string{"Name": "Tester"})
+
+	message := mailer.Message{
+		From:    mail.Address{Name: "HomeTrainers.net", Address: "support@hometrainers.net"},
+		To:      mail.Address{Address: "trainer@example.com"},
+		ReplyTo: &mail.Address{Name: "Tester", Address: "test@example.com"},
+		Date:    time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC),
+		Content: content,
+	}
+
+	data, err := message.Bytes()
+
+	assert.Nil(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Contact Form", parsed.Header.Get("Subject"))
+	assert.Equal(t, "Sat, 01 Apr 2023 10:00:00 +0000", parsed.Header.Get("Date"))
+	assert.Equal(t, `"Tester" <test@example.com>`, parsed.Header.Get("Reply-To"))
+	assert.NotEmpty(t, parsed.Header.Get("Message-ID"))
+	assert.Contains(t, parsed.Header.Get("Content-Type"), "multipart/alternative; boundary=")
+}
+
+func TestEmailServiceSendsThroughTransport(t *testing.T) {
+	sink := mailer.NewMemoryTransport()
+	from := mail.Address{Name: "HomeTrainers.net", Address: "support@hometrainers.net"}
+
+	emailService := services.CreateEmailService(sink, from)
+
+	err := emailService.SendEmail(services.EmailArgs{
+		To:       "trainer@example.com",
+		ReplyTo:  `"Tester" <test@example.com>`,
+		Template: "contact",
+		Data:     map[string]string{"Message": "Hello", "Name": "Tester", "Email": "test@example.com"},
+	})
+
+	assert.Nil(t, err)
+
+	messages := sink.Messages("trainer@example.com")
+
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "support@hometrainers.net", messages[0].From)
+	assert.Equal(t, "Contact Form", messages[0].Subject)
+	assert.Contains(t, messages[0].Text, "Message: Hello")
+	assert.Contains(t, messages[0].Raw, "Reply-To: \"Tester\" <test@example.com>\r\n")
+
+	err = emailService.SendEmail(services.EmailArgs{To: "not an address", Template: "contact"})
+
+	assert.Error(t, err)
+	assert.Len(t, sink.Messages(""), 1)
+}
@@ -1,26 +1,76 @@
 package tests
 
 import (
-	"main/controllers"
+	"encoding/json"
+	"main/models"
 	"main/services"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
-func TestLoginRedirect(t *testing.T) {
-	controllers.SetupRouter(&services.ServiceProvider{})
+func SetupLoginTests(t *testing.T) *gorm.DB {
+	t.Setenv("AUTH_CLIENT_ID", "client-id")
+	t.Setenv("AUTH_CLIENT_SECRET", "client-secret")
+
+	db, _ := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+
+	db.AutoMigrate(&models.PKCEVerifier{})
+
+	return db
+}
+
+func TeardownLoginTests(db *gorm.DB) {
+	db.Exec("delete from pkce_verifiers")
+}
 
+func GetLogin(router http.Handler, state string) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 
-	router := controllers.SetupRouter(&services.ServiceProvider{})
+	req, _ := http.NewRequest("GET", "/login?"+url.Values{"state": {state}}.Encode(), nil)
 
-	req, _ := http.NewRequest("GET", "/login?state=abc123", nil)
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func PostVerifier(router http.Handler, state string, secret string) (*httptest.ResponseRecorder, map[string]string) {
+	w := httptest.NewRecorder()
+
+	form := url.Values{
+		"state":         {state},
+		"client_id":     {"client-id"},
+		"client_secret": {secret},
+	}
+
+	req, _ := http.NewRequest("POST", "/login/verifier", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	router.ServeHTTP(w, req)
 
+	var data map[string]string
+	json.Unmarshal(w.Body.Bytes(), &data)
+
+	return w, data
+}
+
+func TestLoginRedirect(t *testing.T) {
+	db := SetupLoginTests(t)
+
+	t.Setenv("AUTH_SERVER_URL", "http://localhost:9096")
+
+	defer TeardownLoginTests(db)
+
+	router := SetupRouter(db)
+
+	w := GetLogin(router, "abc123")
+
 	expected := []string{
 		"http://localhost:9096/oauth/authorize",
 		"code_challenge=",
@@ -31,3 +81,67 @@ func TestLoginRedirect(t *testing.T) {
 		assert.Contains(t, w.Header().Get("Location"), val)
 	}
 }
+
+func TestLoginRequiresState(t *testing.T) {
+	db := SetupLoginTests(t)
+
+	defer TeardownLoginTests(db)
+
+	router := SetupRouter(db)
+
+	w := GetLogin(router, "")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLoginChallengeIsPerRequest(t *testing.T) {
+	db := SetupLoginTests(t)
+
+	defer TeardownLoginTests(db)
+
+	router := SetupRouter(db)
+
+	first, _ := url.Parse(GetLogin(router, "first").Header().Get("Location"))
+	second, _ := url.Parse(GetLogin(router, "second").Header().Get("Location"))
+
+	assert.NotEmpty(t, first.Query().Get("code_challenge"))
+	assert.NotEqual(t, first.Query().Get("code_challenge"), second.Query().Get("code_challenge"))
+}
+
+func TestLoginVerifierMatchesChallenge(t *testing.T) {
+	db := SetupLoginTests(t)
+
+	defer TeardownLoginTests(db)
+
+	router := SetupRouter(db)
+
+	location, _ := url.Parse(GetLogin(router, "abc123").Header().Get("Location"))
+
+	w, data := PostVerifier(router, "abc123", "client-secret")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, location.Query().Get("code_challenge"), services.CodeChallengeS256(data["code_verifier"]))
+
+	w, _ = PostVerifier(router, "abc123", "client-secret")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestLoginVerifierRequiresClient(t *testing.T) {
+	db := SetupLoginTests(t)
+
+	defer TeardownLoginTests(db)
+
+	router := SetupRouter(db)
+
+	GetLogin(router, "abc123")
+
+	w, data := PostVerifier(router, "abc123", "wrong")
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, data["code_verifier"])
+
+	w, _ = PostVerifier(router, "abc123", "client-secret")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -18,11 +18,12 @@ func (validator *MockUserValidator) Validate(context *gin.Context) (services.Use
 
 type MockEmailService struct {
 	Args services.EmailArgs
+	Err  error
 }
 
 func (emailService *MockEmailService) SendEmail(args services.EmailArgs) error {
 	emailService.Args = args
-	return nil
+	return emailService.Err
 }
 
 type MockBucketService struct {
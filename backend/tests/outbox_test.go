@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"main/models"
+	"main/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var testPolicy = services.OutboxPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Minute,
+	MaxDelay:    time.Hour,
+}
+
+func SetupOutboxTests() *gorm.DB {
+	godotenv.Load("../.env")
+
+	db, _ := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+
+	db.AutoMigrate(&models.OutboxMessage{})
+
+	return db
+}
+
+func TeardownOutboxTests(db *gorm.DB) {
+	db.Exec("delete from outbox_messages")
+}
+
+func EnqueueTestEmail(db *gorm.DB) models.OutboxMessage {
+	outbox := services.CreateOutboxRepo(db)
+
+	outbox.Enqueue(services.EmailArgs{
+		To:       trainerEmail,
+		Template: "contact",
+		Data:     map[string]string{"Message": "Hello"},
+	})
+
+	var message models.OutboxMessage
+	db.Order("id desc").First(&message)
+
+	return message
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, testPolicy.Backoff(1))
+	assert.Equal(t, time.Minute*2, testPolicy.Backoff(2))
+	assert.Equal(t, time.Minute*32, testPolicy.Backoff(6))
+	assert.Equal(t, time.Hour, testPolicy.Backoff(7))
+	assert.Equal(t, time.Hour, testPolicy.Backoff(100))
+}
+
+func TestOutboxWorkerSends(t *testing.T) {
+	db := SetupOutboxTests()
+	defer TeardownOutboxTests(db)
+
+	queued := EnqueueTestEmail(db)
+
+	mockEmailService := MockEmailService{}
+	worker := services.CreateOutboxWorker(services.CreateOutboxRepo(db), &mockEmailService, testPolicy)
+
+	assert.Equal(t, 1, worker.ProcessDue(time.Now()))
+	assert.Equal(t, trainerEmail, mockEmailService.Args.To)
+	assert.Equal(t, "Hello", mockEmailService.Args.Data["Message"])
+
+	var message models.OutboxMessage
+	db.First(&message, queued.ID)
+
+	assert.Equal(t, services.OutboxSent, message.Status)
+	assert.Equal(t, 1, message.Attempts)
+	assert.NotNil(t, message.SentAt)
+
+	assert.Equal(t, 0, worker.ProcessDue(time.Now()))
+
+	outbox := services.CreateOutboxRepo(db)
+
+	assert.Equal(t, int64(0), outbox.DeleteSent(time.Now().Add(-time.Hour)))
+	assert.Equal(t, int64(1), outbox.DeleteSent(time.Now().Add(time.Hour)))
+}
+
+func TestOutboxWorkerRetriesAndDeadLetters(t *testing.T) {
+	db := SetupOutboxTests()
+	defer TeardownOutboxTests(db)
+
+	queued := EnqueueTestEmail(db)
+
+	mockEmailService := MockEmailService{Err: errors.New("421 service not available")}
+	worker := services.CreateOutboxWorker(services.CreateOutboxRepo(db), &mockEmailService, testPolicy)
+
+	now := time.Now()
+
+	assert.Equal(t, 0, worker.ProcessDue(now))
+
+	var message models.OutboxMessage
+	db.First(&message, queued.ID)
+
+	assert.Equal(t, services.OutboxPending, message.Status)
+	assert.Equal(t, 1, message.Attempts)
+	assert.Equal(t, "421 service not available", message.LastError)
+	assert.WithinDuration(t, now.Add(time.Minute), message.NextAttemptAt, time.Second)
+
+	// not due yet
+	mockEmailService.Args = services.EmailArgs{}
+	worker.ProcessDue(now.Add(time.Second * 30))
+
+	assert.Equal(t, "", mockEmailService.Args.To)
+
+	worker.ProcessDue(now.Add(time.Minute))
+	worker.ProcessDue(now.Add(time.Minute * 3))
+
+	db.First(&message, queued.ID)
+
+	assert.Equal(t, services.OutboxDead, message.Status)
+	assert.Equal(t, 3, message.Attempts)
+
+	mockEmailService.Args = services.EmailArgs{}
+	worker.ProcessDue(now.Add(time.Hour * 24))
+
+	assert.Equal(t, "", mockEmailService.Args.To)
+}
+
+func TestOutboxClaimSkipsLeasedMessages(t *testing.T) {
+	db := SetupOutboxTests()
+	defer TeardownOutboxTests(db)
+
+	EnqueueTestEmail(db)
+
+	outbox := services.CreateOutboxRepo(db)
+	now := time.Now()
+
+	due := outbox.Due(now, 10)
+	stale := due[0]
+
+	assert.Len(t, due, 1)
+	assert.True(t, outbox.Claim(&due[0], now.Add(time.Minute)))
+	assert.Equal(t, 1, due[0].Attempts)
+
+	// another worker that read the message before it was claimed
+	assert.False(t, outbox.Claim(&stale, now.Add(time.Minute)))
+
+	assert.Len(t, outbox.Due(now, 10), 0)
+	assert.Len(t, outbox.Due(now.Add(time.Minute), 10), 1)
+}
+
+func TestAdminOutboxRequiresAdmin(t *testing.T) {
+	db := SetupOutboxTests()
+	defer TeardownOutboxTests(db)
+
+	userValidator := MockUserValidator{
+		User:  services.User{Email: "admin@example.com"},
+		Valid: true,
+	}
+
+	router := SetupRouter(db, &userValidator)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/outbox", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAdminOutboxListAndRetry(t *testing.T) {
+	db := SetupOutboxTests()
+	defer TeardownOutboxTests(db)
+
+	sent := EnqueueTestEmail(db)
+	dead := EnqueueTestEmail(db)
+
+	db.Model(&sent).Update("status", services.OutboxSent)
+	db.Model(&dead).Updates(map[string]interface{}{
+		"status":     services.OutboxDead,
+		"attempts":   3,
+		"last_error": "550 mailbox unavailable",
+	})
+
+	userValidator := MockUserValidator{
+		User:  services.User{Email: "admin@example.com", Roles: []string{services.AdminRole}},
+		Valid: true,
+	}
+
+	router := SetupRouter(db, &userValidator)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/outbox?status=dead", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result struct {
+		Messages []models.OutboxMessage `json:"messages"`
+		Total    int64                  `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &result)
+
+	assert.Equal(t, int64(1), result.Total)
+	assert.Equal(t, dead.ID, result.Messages[0].ID)
+	assert.Equal(t, "550 mailbox unavailable", result.Messages[0].LastError)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/outbox?status=lost", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/outbox/%d/retry", sent.ID), nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/outbox/%d/retry", dead.ID), nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var message models.OutboxMessage
+	db.First(&message, dead.ID)
+
+	assert.Equal(t, services.OutboxPending, message.Status)
+	assert.Equal(t, 0, message.Attempts)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/outbox/999/retry", nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
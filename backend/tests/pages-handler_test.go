@@ -25,7 +25,7 @@ func SetupPagesTests() *gorm.DB {
 
 	db, _ := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 
-	db.AutoMigrate(&models.Page{}, &models.Goal{}, &models.Profile{}, &models.Image{})
+	db.AutoMigrate(&models.Page{}, &models.Goal{}, &models.Profile{}, &models.Image{}, &models.OutboxMessage{})
 
 	db.Exec(
 		"insert into profiles (email, name, type) values(?,?,?)",
@@ -47,6 +47,7 @@ func TeardownPagesTests(db *gorm.DB) {
 		delete from images;
 		delete from pages;
 		delete from profiles;
+		delete from outbox_messages;
 	`
 	db.Exec(sql)
 }
@@ -28,6 +28,7 @@ func SetupProfilesTests() *gorm.DB {
 		&models.Goal{},
 		&models.Profile{},
 		&models.ProfileImage{},
+		&models.OutboxMessage{},
 	)
 
 	return db
@@ -40,6 +41,7 @@ func TeardownProfilesTests(db *gorm.DB) {
 		delete from profile_images;
 		delete from goals;
 		delete from cities;
+		delete from outbox_messages;
 	`
 	db.Exec(sql)
 }
@@ -433,6 +435,14 @@ func TestCreateProfile(t *testing.T) {
 	}
 
 	assert.Equal(t, args.Goals, newProfileGoals)
+
+	var queued models.OutboxMessage
+
+	db.Model(&models.OutboxMessage{}).First(&queued)
+
+	assert.Equal(t, services.OutboxPending, queued.Status)
+	assert.Equal(t, "profile-created", queued.Template)
+	assert.Equal(t, userEmail, queued.Data["Email"])
 }
 
 func TestUpdateProfile(t *testing.T) {
@@ -1,7 +1,7 @@
 package tests
 
 import (
-	"context"
+	"errors"
 	"main/services"
 	"net/http"
 	"net/http/httptest"
@@ -9,81 +9,29 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
-
-	"google.golang.org/api/idtoken"
 )
 
-type MockGoogleValidator struct {
-	claims map[string]interface{}
-}
-
-func (validator *MockGoogleValidator) Validate(ctx context.Context, idToken string, audience string) (*idtoken.Payload, error) {
-	payload := idtoken.Payload{
-		Claims: validator.claims,
-	}
-
-	return &payload, nil
-}
-
 type MockAuthValidator struct {
-	response *http.Response
+	user  services.User
+	err   error
+	token string
 }
 
-func (validator *MockAuthValidator) Validate(token string) (*http.Response, error) {
-	return validator.response, nil
+func (validator *MockAuthValidator) Validate(token string) (services.User, error) {
+	validator.token = token
+	return validator.user, validator.err
 }
 
-func TestValidateGoogle(t *testing.T) {
+func TestValidateAuthToken(t *testing.T) {
 	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
 
-	claims := map[string]interface{}{
-		"email_verified": true,
-		"email":          "test@example.com",
-		"name":           "Tester",
-	}
-
-	mockGoogleValidator := MockGoogleValidator{
-		claims: claims,
-	}
-
-	mockAuthValidator := MockAuthValidator{
-		response: &http.Response{},
-	}
-
-	ctx.Request = &http.Request{
-		Header: make(http.Header),
-	}
-
-	ctx.Request.Header.Set("Authorization", "Bearer test-token")
-	ctx.Request.Header.Set("token-provider", "google")
-
-	user, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator, &mockGoogleValidator)
-
 	expectedUser := services.User{
 		Name:  "Tester",
 		Email: "test@example.com",
 	}
 
-	assert.True(t, ok)
-	assert.Equal(t, expectedUser, user)
-}
-
-func TestGoogleEmailUnverified(t *testing.T) {
-	w := httptest.NewRecorder()
-	ctx, _ := gin.CreateTestContext(w)
-
-	claims := map[string]interface{}{
-		"email_verified": false,
-		"email":          "test@example.com",
-		"name":           "Tester",
-	}
-
-	mockGoogleValidator := MockGoogleValidator{
-		claims: claims,
-	}
-
 	mockAuthValidator := MockAuthValidator{
-		response: &http.Response{},
+		user: expectedUser,
 	}
 
 	ctx.Request = &http.Request{
@@ -91,65 +39,48 @@ func TestGoogleEmailUnverified(t *testing.T) {
 	}
 
 	ctx.Request.Header.Set("Authorization", "Bearer test-token")
-	ctx.Request.Header.Set("token-provider", "google")
 
-	_, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator, &mockGoogleValidator)
+	user, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator)
 
-	assert.False(t, ok)
+	assert.True(t, ok)
+	assert.Equal(t, expectedUser, user)
+	assert.Equal(t, "test-token", mockAuthValidator.token)
 }
 
-func TestGoogleNoAuthToken(t *testing.T) {
+func TestValidateIgnoresTokenProvider(t *testing.T) {
 	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
 
-	claims := map[string]interface{}{
-		"email_verified": "false",
-		"email":          "test@example.com",
-		"name":           "Tester",
-	}
-
-	mockGoogleValidator := MockGoogleValidator{
-		claims: claims,
-	}
-
 	mockAuthValidator := MockAuthValidator{
-		response: &http.Response{},
+		err: errors.New("token is malformed"),
 	}
 
 	ctx.Request = &http.Request{
 		Header: make(http.Header),
 	}
 
+	// tokens from other providers aren't accepted even when labelled
+	ctx.Request.Header.Set("Authorization", "Bearer google-token")
 	ctx.Request.Header.Set("token-provider", "google")
 
-	_, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator, &mockGoogleValidator)
+	_, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator)
 
 	assert.False(t, ok)
+	assert.Equal(t, "google-token", mockAuthValidator.token)
+	assert.Equal(t, http.StatusUnauthorized, ctx.Writer.Status())
 }
 
-func TestGoogleNoProviderToken(t *testing.T) {
+func TestValidateNoAuthToken(t *testing.T) {
 	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
 
-	claims := map[string]interface{}{
-		"email_verified": "false",
-		"email":          "test@example.com",
-		"name":           "Tester",
-	}
-
-	mockGoogleValidator := MockGoogleValidator{
-		claims: claims,
-	}
-
-	mockAuthValidator := MockAuthValidator{
-		response: &http.Response{},
-	}
+	mockAuthValidator := MockAuthValidator{}
 
 	ctx.Request = &http.Request{
 		Header: make(http.Header),
 	}
 
-	ctx.Request.Header.Set("Authorization", "Bearer test-token")
-
-	_, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator, &mockGoogleValidator)
+	_, ok := services.GetAuthorizedUser(ctx, &mockAuthValidator)
 
 	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, ctx.Writer.Status())
+	assert.Equal(t, "", mockAuthValidator.token)
 }
@@ -1,9 +1,8 @@
 'use client'
 
-import { signIn, signOut } from 'next-auth/react'
+import { signIn, useSession } from 'next-auth/react'
 import styles from './profileProvider.module.scss'
 import { createContext, useContext, useEffect, useState } from 'react'
-import Image from 'next/image'
 import classnames from 'classnames'
 import { useIsLoggedIn } from '@/utils/useIsLoggedIn'
 import { useAlert } from '../alerts'
@@ -14,6 +13,8 @@ import { useFetchProfile } from './useFetchProfile'
 import { Loading } from '../loading'
 import Link from 'next/link'
 import { useRouter } from 'next/router'
+import { signOutOfAuthServer } from '@/utils/signOutOfAuthServer'
+import { SessionType } from '../header/types'
 
 const roboto = Roboto({
   subsets: ['latin'],
@@ -64,6 +65,7 @@ export const ProfileProvider: React.FC<ProfileProviderProps> = ({ children }) =>
   const router = useRouter()
 
   const isLoggedIn = useIsLoggedIn()
+  const session = useSession().data as SessionType | null
   const { fetchProfile, loading } = useFetchProfile(updateProfile)
 
   const resetProfile = async () => {
@@ -172,7 +174,7 @@ export const ProfileProvider: React.FC<ProfileProviderProps> = ({ children }) =>
             <div className={styles.scrim} />
 
             {isLoggedIn && (<>
-              <button className={styles.profileButton} onClick={onButtonClick(signOut)}>
+              <button className={styles.profileButton} onClick={onButtonClick(signOutOfAuthServer)}>
                 Sign out
               </button>
 
@@ -192,6 +194,13 @@ export const ProfileProvider: React.FC<ProfileProviderProps> = ({ children }) =>
                 My Page
               </Link>}
 
+              {session?.provider === 'auth' && <a
+                href={`${process.env.NEXT_PUBLIC_AUTH_SERVER}/account`}
+                className={styles.profileButton}
+              >
+                Account
+              </a>}
+
             </>)}
 
             {!isLoggedIn && <>
@@ -199,18 +208,7 @@ export const ProfileProvider: React.FC<ProfileProviderProps> = ({ children }) =>
                 className={styles.profileButton}
                 onClick={onButtonClick(() => signIn('auth'))}
               >
-                Sign in with email
-              </button>
-              <button
-                className={styles.googleSignIn}
-                onClick={onButtonClick(() => signIn('google'))}
-              >
-                <Image
-                  src={'/google-signin.png'}
-                  alt={'sign in with google'}
-                  fill={true}
-                  className={styles.googleIcon}
-                />
+                Sign in
               </button>
             </>}
           </div>
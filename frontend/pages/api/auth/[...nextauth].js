@@ -1,31 +1,19 @@
 import NextAuth from 'next-auth'
-import GoogleProvider from 'next-auth/providers/google'
-import { googleJwtCallback, authJwtCallback } from  './handleJwt'
+import { authJwtCallback, revokeAuthToken, fetchCodeVerifier } from  './handleJwt'
 import { clientId, clientSecret } from './clientInfo'
 
 const authServer = process.env.NEXT_PUBLIC_AUTH_SERVER
 const redirectUri = process.env.NEXTAUTH_URL
-const codeChallenge = process.env.NEXT_PUBLIC_CODE_CHALLENGE
 
 export default NextAuth({
   providers: [
-    GoogleProvider({
-      clientId: process.env.NEXT_PUBLIC_GOOGLE_CLIENT_ID,
-      clientSecret: process.env.NEXT_PUBLIC_GOOGLE_CLIENT_SECRET,
-      authorization: {
-        params: {
-          prompt: 'consent',
-          access_type: 'offline',
-          response_type: 'code'
-        }
-      }
-    }),
     {
       id: 'auth',
       name: 'Auth',
       type: 'oauth',
       version: '2.0',
       scope: '',
+      checks: ['state'],
       authorization: {
         url: process.env.NEXT_PUBLIC_LOGIN_URL,
         params: { grant_type: 'authorization_code' },
@@ -33,6 +21,7 @@ export default NextAuth({
       token: {
         url: `${authServer}/oauth/token`,
         async request(context) {
+          const codeVerifier = await fetchCodeVerifier(context.params.state)
           const url =
           `${process.env.NEXT_PUBLIC_AUTH_SERVER}/oauth/token?` +
             new URLSearchParams({
@@ -40,7 +29,7 @@ export default NextAuth({
               client_secret: clientSecret,
               grant_type: 'authorization_code',
               code: context.params.code,
-              code_verifier: codeChallenge,
+              code_verifier: codeVerifier,
               redirect_uri: `${redirectUri}/api/auth/callback/auth`
             })
           
@@ -82,10 +71,6 @@ export default NextAuth({
     async jwt({ token, account }) {
       const provider = account?.provider ?? token.provider
  
-      if (provider === 'google') {
-        return googleJwtCallback(token, account)
-      }
-
       if (provider === 'auth') {
         return await authJwtCallback(token, account)
       }
@@ -96,4 +81,9 @@ export default NextAuth({
       return url
     }
   },
+  events: {
+    async signOut({ token }) {
+      await revokeAuthToken(token)
+    }
+  },
 })
\ No newline at end of file
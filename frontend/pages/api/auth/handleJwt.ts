@@ -9,47 +9,6 @@ export type Token = {
   provider: string
 }
 
-async function refreshGoogleToken(token: Token) {
-  try {
-    const url =
-      'https://oauth2.googleapis.com/token?' +
-      new URLSearchParams({
-        client_id: process.env.NEXT_PUBLIC_GOOGLE_CLIENT_ID,
-        client_secret: process.env.NEXT_PUBLIC_GOOGLE_CLIENT_SECRET,
-        grant_type: 'refresh_token',
-        refresh_token: token.refreshToken,
-      } as Record<string, string>)
-
-    const response = await fetch(url, {
-      headers: {
-        'Content-Type': 'application/x-www-form-urlencoded',
-      },
-      method: 'POST',
-    })
-
-    const refreshedTokens = await response.json()
-
-    if (!response.ok) {
-      throw refreshedTokens
-    }
-
-    return {
-      ...token,
-      accessToken: refreshedTokens.access_token,
-      idToken: refreshedTokens.idToken,
-      accessTokenExpires: refreshedTokens.expires_in * 1000,
-      refreshToken: refreshedTokens.refresh_token ?? token.refreshToken
-    }
-  } catch (error) {
-    console.log(error)
-
-    return {
-      ...token,
-      error: 'RefreshAccessTokenError',
-    }
-  }
-}
-
 async function refreshAuthToken(token: Token) {
   try {
     const url =
@@ -91,31 +50,61 @@ async function refreshAuthToken(token: Token) {
   }
 }
 
-export function googleJwtCallback(token: Token, account?: Account) {
+export async function authJwtCallback(token: Token, account?: Account) {
   if (account) {
-    token.idToken = account.id_token as string
+    token.accessToken = account.access_token as string
     token.refreshToken = account.refresh_token as string
     token.accessTokenExpires = (account.expires_at as number) * 1000
     token.provider = account.provider
   }
+
   if (Date.now() < token.accessTokenExpires) {
     return token
   }
 
-  return refreshGoogleToken(token)
+  return refreshAuthToken(token)
 }
 
-export async function authJwtCallback(token: Token, account?: Account) {
-  if (account) {
-    token.accessToken = account.access_token as string
-    token.refreshToken = account.refresh_token as string
-    token.accessTokenExpires = (account.expires_at as number) * 1000
-    token.provider = account.provider
+export async function revokeAuthToken(token: Token) {
+  if (token.provider !== 'auth' || !token.refreshToken) {
+    return
   }
 
-  if (Date.now() < token.accessTokenExpires) {
-    return token
+  try {
+    await fetch(`${process.env.NEXT_PUBLIC_AUTH_SERVER}/oauth/revoke`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/x-www-form-urlencoded',
+      },
+      body: new URLSearchParams({
+        client_id: clientId,
+        client_secret: clientSecret,
+        token: token.refreshToken,
+        token_type_hint: 'refresh_token',
+      } as Record<string, string>)
+    })
+  } catch (error) {
+    console.log(error)
+  }
+}
+
+export async function fetchCodeVerifier(state: string) {
+  const response = await fetch(`${process.env.NEXT_PUBLIC_API_URL}/login/verifier`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/x-www-form-urlencoded',
+    },
+    body: new URLSearchParams({
+      client_id: clientId,
+      client_secret: clientSecret,
+      state,
+    } as Record<string, string>)
+  })
+
+  if (!response.ok) {
+    throw new Error('Unable to fetch code verifier')
   }
 
-  return refreshAuthToken(token)
-}
\ No newline at end of file
+  const { code_verifier } = await response.json()
+  return code_verifier as string
+}
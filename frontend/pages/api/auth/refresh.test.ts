@@ -1,5 +1,5 @@
 import { Account } from 'next-auth';
-import { Token, authJwtCallback } from './handleJwt';
+import { Token, authJwtCallback, revokeAuthToken, fetchCodeVerifier } from './handleJwt';
 import { clientId, clientSecret } from './clientInfo';
 
 const now = new Date(2023, 4, 1)
@@ -110,4 +110,76 @@ describe('token refresh', () => {
       })
     })
   })
-})
\ No newline at end of file
+
+  describe('sign out', () => {
+    it('revokes the auth refresh token', async () => {
+      global.fetch = jest.fn(() => Promise.resolve({ ok: true } as Response))
+
+      await revokeAuthToken({
+        accessToken: 'test-token',
+        refreshToken: 'refresh-token',
+        provider: 'auth'
+      } as Token)
+
+      expect(global.fetch).toHaveBeenCalledWith(
+        `${process.env.NEXT_PUBLIC_AUTH_SERVER}/oauth/revoke`,
+        {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/x-www-form-urlencoded'
+          },
+          body: new URLSearchParams({
+            client_id: clientId,
+            client_secret: clientSecret,
+            token: 'refresh-token',
+            token_type_hint: 'refresh_token'
+          } as Record<string, string>)
+        }
+      )
+    })
+
+    it('skips google tokens', async () => {
+      global.fetch = jest.fn()
+
+      await revokeAuthToken({ refreshToken: 'refresh-token', provider: 'google' } as Token)
+
+      expect(global.fetch).not.toHaveBeenCalled()
+    })
+  })
+
+  describe('code verifier', () => {
+    it('exchanges the state for its verifier', async () => {
+      global.fetch = jest.fn(() => Promise.resolve({
+        json: () => Promise.resolve({ code_verifier: 'verifier' }),
+        ok: true
+      } as Response))
+
+      const result = await fetchCodeVerifier('state')
+
+      expect(result).toEqual('verifier')
+      expect(global.fetch).toHaveBeenCalledWith(
+        `${process.env.NEXT_PUBLIC_API_URL}/login/verifier`,
+        {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/x-www-form-urlencoded'
+          },
+          body: new URLSearchParams({
+            client_id: clientId,
+            client_secret: clientSecret,
+            state: 'state'
+          } as Record<string, string>)
+        }
+      )
+    })
+
+    it('throws when the state is unknown', async () => {
+      global.fetch = jest.fn(() => Promise.resolve({
+        json: () => Promise.resolve({}),
+        ok: false
+      } as Response))
+
+      await expect(fetchCodeVerifier('state')).rejects.toThrow()
+    })
+  })
+})
@@ -0,0 +1,14 @@
+import { signOut } from 'next-auth/react'
+
+// Signs out of NextAuth, then ends the auth server session. Without an
+// id_token_hint the auth server asks the user to confirm, then sends the
+// browser back to the site.
+export async function signOutOfAuthServer() {
+  await signOut({ redirect: false })
+
+  window.location.href = `${process.env.NEXT_PUBLIC_AUTH_SERVER}/logout?` +
+    new URLSearchParams({
+      client_id: process.env.NEXT_PUBLIC_CLIENT_ID,
+      post_logout_redirect_uri: process.env.NEXT_PUBLIC_DOMAIN_URL,
+    } as Record<string, string>)
+}
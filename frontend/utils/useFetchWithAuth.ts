@@ -16,15 +16,10 @@ export function useFetchWithAuth(): (args: FetchWithAuthArgs) => Promise<Respons
 
     const data = session.data as SessionType | null
 
-    const token = data?.provider === 'google'
-      ? data?.idToken
-      : data?.accessToken
-
     const result = await fetch(`${API}${path}`, {
       method,
       headers: {
-        authorization: `Bearer ${token ?? ''}`,
-        "token-provider": data?.provider ?? ''
+        authorization: `Bearer ${data?.accessToken ?? ''}`
       },
       body
     })
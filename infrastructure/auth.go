@@ -25,8 +25,9 @@ func AuthService(
 		Registry:  docker.RegistryArgs{},
 		ImageName: pulumi.Sprintf("%s/%s", repoUrl, authImageName),
 		Build: &docker.DockerBuildArgs{
-			Context:  pulumi.String("../auth"),
-			Platform: pulumi.String("linux/amd64"),
+			Context:    pulumi.String(".."),
+			Dockerfile: pulumi.String("../auth/Dockerfile"),
+			Platform:   pulumi.String("linux/amd64"),
 		},
 	}, pulumi.DependsOn([]pulumi.Resource{enableCloudRun, enableSqlAdmin}))
 
@@ -47,9 +48,53 @@ func AuthService(
 						Image: auth.ImageName,
 						Envs: cloudrun.ServiceTemplateSpecContainerEnvArray{
 							SetEnv("NEXTAUTH_URL", "NEXTAUTH_URL"),
+							SetEnv("BACKEND_URL", "API_URL"),
 							SetEnv("MAIL_PASSWORD", "MAIL_PASSWORD"),
+							SetEnv("MAIL_DRIVER", "MAIL_DRIVER"),
+							SetEnv("MAIL_HOST", "MAIL_HOST"),
+							SetEnv("MAIL_PORT", "MAIL_PORT"),
+							SetEnv("MAIL_TLS", "MAIL_TLS"),
+							SetEnv("MAIL_USERNAME", "MAIL_USERNAME"),
+							SetEnv("MAIL_FROM", "MAIL_FROM"),
 							SetEnv("CLIENT_ID", "CLIENT_ID"),
 							SetEnv("CLIENT_SECRET", "CLIENT_SECRET"),
+							SetEnv("JWT_SIGNING_KEY", "JWT_SIGNING_KEY"),
+							SetEnv("JWT_SIGNING_KEY_ID", "JWT_SIGNING_KEY_ID"),
+							SetEnv("JWT_RETIRED_KEYS", "JWT_RETIRED_KEYS"),
+							SetEnv("ACCESS_TOKEN_TTL", "ACCESS_TOKEN_TTL"),
+							SetEnv("REFRESH_TOKEN_TTL", "REFRESH_TOKEN_TTL"),
+							SetEnv("TRUSTED_PROXIES", "TRUSTED_PROXIES"),
+							SetEnv("SESSION_SECRET", "SESSION_SECRET"),
+							SetEnv("SESSION_TTL", "SESSION_TTL"),
+							SetEnv("SESSION_COOKIE_TTL", "SESSION_COOKIE_TTL"),
+							SetEnv("CORS_ORIGINS", "CORS_ORIGINS"),
+							SetEnv("CLIENT_REDIRECT_URIS", "CLIENT_REDIRECT_URIS"),
+							SetEnv("CLIENT_POST_LOGOUT_REDIRECT_URIS", "CLIENT_POST_LOGOUT_REDIRECT_URIS"),
+							SetEnv("ADMIN_EMAILS", "ADMIN_EMAILS"),
+							SetEnv("AUDIT_RETENTION", "AUDIT_RETENTION"),
+							SetEnv("PASSWORD_MIN_LENGTH", "PASSWORD_MIN_LENGTH"),
+							SetEnv("PASSWORD_MAX_LENGTH", "PASSWORD_MAX_LENGTH"),
+							SetEnv("PASSWORD_MIN_CLASSES", "PASSWORD_MIN_CLASSES"),
+							SetEnv("BREACHED_PASSWORDS_FILE", "BREACHED_PASSWORDS_FILE"),
+							SetEnv("PASSWORD_HASH", "PASSWORD_HASH"),
+							SetEnv("BCRYPT_COST", "BCRYPT_COST"),
+							SetEnv("ARGON2_TIME", "ARGON2_TIME"),
+							SetEnv("ARGON2_MEMORY", "ARGON2_MEMORY"),
+							SetEnv("ARGON2_THREADS", "ARGON2_THREADS"),
+							SetEnv("DYNAMIC_CLIENT_REGISTRATION", "DYNAMIC_CLIENT_REGISTRATION"),
+							SetEnv("CLIENT_REGISTRATION_TOKEN", "CLIENT_REGISTRATION_TOKEN"),
+							SetEnv("UPSTREAM_GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_ID"),
+							SetEnv("UPSTREAM_GOOGLE_CLIENT_SECRET", "GOOGLE_CLIENT_SECRET"),
+							cloudrun.ServiceTemplateSpecContainerEnvArgs{
+								Name:      pulumi.String("UPSTREAM_PROVIDERS"),
+								Value:     pulumi.String("google"),
+								ValueFrom: nil,
+							},
+							cloudrun.ServiceTemplateSpecContainerEnvArgs{
+								Name:      pulumi.String("UPSTREAM_GOOGLE_ISSUER"),
+								Value:     pulumi.String("https://accounts.google.com"),
+								ValueFrom: nil,
+							},
 							cloudrun.ServiceTemplateSpecContainerEnvArgs{
 								Name:      pulumi.String("POSTGRES_USER"),
 								Value:     dbUser,
@@ -24,8 +24,9 @@ func BackendService(
 		Registry:  docker.RegistryArgs{},
 		ImageName: pulumi.Sprintf("%s/%s", repoUrl, backendImageName),
 		Build: &docker.DockerBuildArgs{
-			Context:  pulumi.String("../backend"),
-			Platform: pulumi.String("linux/amd64"),
+			Context:    pulumi.String(".."),
+			Dockerfile: pulumi.String("../backend/Dockerfile"),
+			Platform:   pulumi.String("linux/amd64"),
 		},
 	}, pulumi.DependsOn(dependsOn))
 
@@ -40,12 +41,20 @@ func BackendService(
 					&cloudrun.ServiceTemplateSpecContainerArgs{
 						Image: backend.ImageName,
 						Envs: cloudrun.ServiceTemplateSpecContainerEnvArray{
-							SetEnv("GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_ID"),
-							SetEnv("GOOGLE_CLIENT_SECRET", "GOOGLE_CLIENT_SECRET"),
 							SetEnv("AUTH_SERVER_URL", "AUTH_SERVER_URL"),
+							SetEnv("AUTH_AUDIENCE", "CLIENT_ID"),
+							SetEnv("AUTH_REMOTE_FALLBACK", "AUTH_REMOTE_FALLBACK"),
+							SetEnv("AUTH_TOKEN_VALIDATION", "AUTH_TOKEN_VALIDATION"),
+							SetEnv("AUTH_CLIENT_ID", "CLIENT_ID"),
+							SetEnv("AUTH_CLIENT_SECRET", "CLIENT_SECRET"),
 							SetEnv("BACKEND_REDIRECT_URL", "BACKEND_REDIRECT_URL"),
-							SetEnv("CODE_CHALLENGE", "CODE_CHALLENGE"),
 							SetEnv("MAIL_PASSWORD", "MAIL_PASSWORD"),
+							SetEnv("MAIL_DRIVER", "MAIL_DRIVER"),
+							SetEnv("MAIL_HOST", "MAIL_HOST"),
+							SetEnv("MAIL_PORT", "MAIL_PORT"),
+							SetEnv("MAIL_TLS", "MAIL_TLS"),
+							SetEnv("MAIL_USERNAME", "MAIL_USERNAME"),
+							SetEnv("MAIL_FROM", "MAIL_FROM"),
 							cloudrun.ServiceTemplateSpecContainerEnvArgs{
 								Name:      pulumi.String("POSTGRES_USER"),
 								Value:     dbUser,
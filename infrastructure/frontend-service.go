@@ -21,10 +21,11 @@ func FrontendService(
 	var frontendArgs = make(map[string]pulumi.StringInput)
 	frontendArgs["NEXTAUTH_URL"] = pulumi.String(os.Getenv("NEXTAUTH_URL"))
 	frontendArgs["NEXTAUTH_SECRET"] = pulumi.String(os.Getenv("NEXTAUTH_SECRET"))
-	frontendArgs["NEXT_PUBLIC_GOOGLE_CLIENT_SECRET"] = pulumi.String(os.Getenv("GOOGLE_CLIENT_SECRET"))
-	frontendArgs["NEXT_PUBLIC_GOOGLE_CLIENT_ID"] = pulumi.String(os.Getenv("GOOGLE_CLIENT_ID"))
 	frontendArgs["NEXT_PUBLIC_API_URL"] = pulumi.String(os.Getenv("API_URL"))
 	frontendArgs["NEXT_PUBLIC_IMAGES_BUCKET"] = bucketName
+	frontendArgs["NEXT_PUBLIC_AUTH_SERVER"] = pulumi.String(os.Getenv("AUTH_SERVER_URL"))
+	frontendArgs["NEXT_PUBLIC_CLIENT_ID"] = pulumi.String(os.Getenv("CLIENT_ID"))
+	frontendArgs["NEXT_PUBLIC_DOMAIN_URL"] = pulumi.String(os.Getenv("NEXTAUTH_URL"))
 	frontendArgs["ENVIRONMENT"] = pulumi.String("PROD")
 
 	frontend, _ := docker.NewImage(ctx, "frontend", &docker.ImageArgs{
@@ -50,8 +51,6 @@ func FrontendService(
 					&cloudrun.ServiceTemplateSpecContainerArgs{
 						Image: frontend.ImageName,
 						Envs: cloudrun.ServiceTemplateSpecContainerEnvArray{
-							SetEnv("NEXT_PUBLIC_GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_ID"),
-							SetEnv("NEXT_PUBLIC_GOOGLE_CLIENT_SECRET", "GOOGLE_CLIENT_SECRET"),
 							SetEnv("NEXT_PUBLIC_API_URL", "API_URL"),
 							SetEnv("NEXTAUTH_URL", "NEXTAUTH_URL"),
 							SetEnv("NEXT_PUBLIC_DOMAIN_URL", "NEXTAUTH_URL"),
@@ -60,7 +59,6 @@ func FrontendService(
 							SetEnv("NEXTAUTH_SECRET", "NEXTAUTH_SECRET"),
 							SetEnv("NEXT_PUBLIC_CLIENT_ID", "CLIENT_ID"),
 							SetEnv("NEXT_PUBLIC_CLIENT_SECRET", "CLIENT_SECRET"),
-							SetEnv("NEXT_PUBLIC_CODE_CHALLENGE", "CODE_CHALLENGE"),
 							cloudrun.ServiceTemplateSpecContainerEnvArgs{
 								Name:      pulumi.String("NEXT_PUBLIC_IMAGES_BUCKET"),
 								Value:     bucketName,
@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strconv"
+)
+
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+
+	TLSImplicit = "tls"
+	TLSStartTLS = "starttls"
+	TLSNone     = "none"
+)
+
+var (
+	ErrMailDirRequired    = errors.New("MAIL_DIR is required for the file mail driver")
+	ErrMemoryDriverInProd = errors.New("the memory mail driver can't be used in production")
+)
+
+// Transport delivers an encoded message to the envelope recipients.
+type Transport interface {
+	Send(from string, to []string, message []byte) error
+}
+
+type Config struct {
+	Driver   string
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     mail.Address
+	Dir      string
+}
+
+// LoadConfig reads MAIL_DRIVER (smtp, file or memory), MAIL_HOST, MAIL_PORT,
+// MAIL_TLS (tls, starttls or none), MAIL_USERNAME, MAIL_PASSWORD, MAIL_FROM
+// and, for the file driver, MAIL_DIR. MAIL_TLS defaults to tls on port 465
+// and starttls otherwise, and MAIL_USERNAME to the from address. The memory
+// driver drops every message, so it's refused when ENVIRONMENT is PROD.
+func LoadConfig() (Config, error) {
+	config := Config{
+		Driver:   envOr("MAIL_DRIVER", DriverSMTP),
+		Host:     envOr("MAIL_HOST", "smtp.zoho.com"),
+		Port:     465,
+		Password: os.Getenv("MAIL_PASSWORD"),
+		Dir:      os.Getenv("MAIL_DIR"),
+	}
+
+	if value := os.Getenv("MAIL_PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return config, fmt.Errorf("invalid MAIL_PORT %q", value)
+		}
+		config.Port = port
+	}
+
+	from, err := mail.ParseAddress(envOr("MAIL_FROM", "HomeTrainers.net <support@hometrainers.net>"))
+	if err != nil {
+		return config, fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+	config.From = *from
+
+	config.Username = envOr("MAIL_USERNAME", config.From.Address)
+
+	defaultTLS := TLSStartTLS
+	if config.Port == 465 {
+		defaultTLS = TLSImplicit
+	}
+	config.TLS = envOr("MAIL_TLS", defaultTLS)
+
+	switch config.TLS {
+	case TLSImplicit, TLSStartTLS, TLSNone:
+	default:
+		return config, fmt.Errorf("invalid MAIL_TLS %q", config.TLS)
+	}
+
+	switch config.Driver {
+	case DriverSMTP:
+	case DriverMemory:
+		if os.Getenv("ENVIRONMENT") == "PROD" {
+			return config, ErrMemoryDriverInProd
+		}
+	case DriverFile:
+		if config.Dir == "" {
+			return config, ErrMailDirRequired
+		}
+	default:
+		return config, fmt.Errorf("invalid MAIL_DRIVER %q", config.Driver)
+	}
+
+	return config, nil
+}
+
+func NewTransport(config Config) Transport {
+	switch config.Driver {
+	case DriverFile:
+		return &MaildirTransport{dir: config.Dir}
+	case DriverMemory:
+		return NewMemoryTransport()
+	default:
+		return &SMTPTransport{config: config}
+	}
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,11 @@
+module mailer
+
+go 1.20
+
+require github.com/stretchr/testify v1.8.3
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
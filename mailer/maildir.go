@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaildirTransport writes each message to a maildir, so mail sent during
+// development can be read with any mail client.
+type MaildirTransport struct {
+	dir string
+}
+
+func (transport *MaildirTransport) Send(from string, to []string, message []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(transport.dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(random), hostname)
+
+	// the envelope isn't part of the message, so it's recorded the way a
+	// delivery agent would
+	var delivered bytes.Buffer
+	fmt.Fprintf(&delivered, "Return-Path: <%s>\r\n", from)
+	for _, recipient := range to {
+		fmt.Fprintf(&delivered, "Delivered-To: %s\r\n", recipient)
+	}
+	delivered.Write(message)
+
+	tmp := filepath.Join(transport.dir, "tmp", name)
+
+	if err := os.WriteFile(tmp, delivered.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(transport.dir, "new", name))
+}
@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SentMessage struct {
+	From    string    `json:"from"`
+	To      []string  `json:"to"`
+	Subject string    `json:"subject"`
+	Text    string    `json:"text"`
+	HTML    string    `json:"html"`
+	Raw     string    `json:"raw"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// MemoryTransport keeps sent mail in memory instead of delivering it. It's
+// an http.Handler so local development and e2e tests can read the mail:
+// GET lists messages, optionally filtered with ?to=, and DELETE clears them.
+type MemoryTransport struct {
+	mutex    sync.Mutex
+	messages []SentMessage
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{messages: []SentMessage{}}
+}
+
+func (transport *MemoryTransport) Send(from string, to []string, message []byte) error {
+	sent := SentMessage{
+		From:   from,
+		To:     to,
+		Raw:    string(message),
+		SentAt: time.Now(),
+	}
+
+	parseMessage(message, &sent)
+
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	transport.messages = append(transport.messages, sent)
+
+	return nil
+}
+
+// Messages returns the messages sent to recipient, or all of them when
+// recipient is empty, oldest first.
+func (transport *MemoryTransport) Messages(recipient string) []SentMessage {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	messages := []SentMessage{}
+
+	for _, message := range transport.messages {
+		for _, to := range message.To {
+			if recipient == "" || strings.EqualFold(to, recipient) {
+				messages = append(messages, message)
+				break
+			}
+		}
+	}
+
+	return messages
+}
+
+func (transport *MemoryTransport) Reset() {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	transport.messages = []SentMessage{}
+}
+
+func (transport *MemoryTransport) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(transport.Messages(request.URL.Query().Get("to")))
+	case http.MethodDelete:
+		transport.Reset()
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.Header().Set("Allow", "GET, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// parseMessage fills in the subject and bodies where the message can be
+// parsed. The raw message is always kept.
+func parseMessage(data []byte, sent *SentMessage) {
+	message, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+
+	decoder := new(mime.WordDecoder)
+
+	if subject, err := decoder.DecodeHeader(message.Header.Get("Subject")); err == nil {
+		sent.Subject = subject
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		body, _ := io.ReadAll(message.Body)
+		sent.Text = string(body)
+		return
+	}
+
+	// quoted-printable parts are decoded by the reader
+	reader := multipart.NewReader(message.Body, params["boundary"])
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return
+		}
+
+		body, _ := io.ReadAll(part)
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		switch partType {
+		case "text/plain":
+			sent.Text = strings.ReplaceAll(string(body), "\r\n", "\n")
+		case "text/html":
+			sent.HTML = strings.ReplaceAll(string(body), "\r\n", "\n")
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is a multipart/alternative email with a plain text and an HTML
+// part.
+type Message struct {
+	From    mail.Address
+	To      mail.Address
+	ReplyTo *mail.Address
+	Date    time.Time
+	Content Content
+}
+
+// Bytes encodes the message for SMTP DATA, with CRLF line endings and
+// headers in a fixed order.
+func (message *Message) Bytes() ([]byte, error) {
+	var body bytes.Buffer
+
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", message.Content.Text},
+		{"text/html; charset=UTF-8", message.Content.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := quotedprintable.NewWriter(writer)
+
+		if _, err := encoder.Write([]byte(crlf(part.content))); err != nil {
+			return nil, err
+		}
+
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID, err := newMessageID(message.From.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := [][2]string{
+		{"From", message.From.String()},
+		{"To", message.To.String()},
+	}
+
+	if message.ReplyTo != nil {
+		headers = append(headers, [2]string{"Reply-To", message.ReplyTo.String()})
+	}
+
+	headers = append(headers,
+		[2]string{"Subject", mime.QEncoding.Encode("UTF-8", message.Content.Subject)},
+		[2]string{"Date", message.Date.Format(time.RFC1123Z)},
+		[2]string{"Message-ID", messageID},
+		[2]string{"MIME-Version", "1.0"},
+		[2]string{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", parts.Boundary())},
+	)
+
+	var encoded bytes.Buffer
+
+	for _, header := range headers {
+		fmt.Fprintf(&encoded, "%s: %s\r\n", header[0], header[1])
+	}
+
+	encoded.WriteString("\r\n")
+	encoded.Write(body.Bytes())
+
+	return encoded.Bytes(), nil
+}
+
+func newMessageID(from string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain), nil
+}
+
+func crlf(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
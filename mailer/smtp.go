@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// SMTPTransport sends through an SMTP server, over implicit TLS, STARTTLS
+// or, for local sinks, plain TCP. Server certificates are always verified.
+type SMTPTransport struct {
+	config Config
+}
+
+func (transport *SMTPTransport) Send(from string, to []string, message []byte) error {
+	config := transport.config
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+
+	if config.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.TLS == TLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if config.Password != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,141 @@
+package mailer
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+const DefaultLocale = "en"
+
+// Content is a rendered email. Each template has a NAME.txt file, which
+// also defines the subject, and a NAME.html file that defines the content
+// of the HTML layout.
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Templates renders emails from a templates directory with a layout.html
+// and a directory of NAME.txt and NAME.html files for each locale.
+type Templates struct {
+	files   fs.FS
+	locales []string
+}
+
+func NewTemplates(files fs.FS, locales []string) *Templates {
+	return &Templates{files, locales}
+}
+
+// Render renders the named email in locale, falling back to the default
+// locale when the email hasn't been translated.
+func (templates *Templates) Render(name string, locale string, data interface{}) (Content, error) {
+	locale = templates.SupportedLocale(locale)
+
+	if _, err := fs.Stat(templates.files, templatePath(locale, name+".txt")); err != nil {
+		locale = DefaultLocale
+	}
+
+	var content Content
+
+	text, err := texttemplate.ParseFS(templates.files, templatePath(locale, name+".txt"))
+	if err != nil {
+		return content, err
+	}
+
+	var subject, body bytes.Buffer
+
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return content, err
+	}
+
+	if err := text.Execute(&body, data); err != nil {
+		return content, err
+	}
+
+	html, err := htmltemplate.ParseFS(templates.files, "templates/layout.html", templatePath(locale, name+".html"))
+	if err != nil {
+		return content, err
+	}
+
+	var htmlBody bytes.Buffer
+
+	if err := html.ExecuteTemplate(&htmlBody, "layout.html", map[string]interface{}{"Lang": locale, "Data": data}); err != nil {
+		return content, err
+	}
+
+	content.Subject = strings.TrimSpace(subject.String())
+	content.Text = strings.TrimSpace(body.String()) + "\n"
+	content.HTML = htmlBody.String()
+
+	return content, nil
+}
+
+// SupportedLocale is locale when emails are written in it, or in its base
+// language, and the default locale otherwise.
+func (templates *Templates) SupportedLocale(locale string) string {
+	if supported, ok := templates.findLocale(locale); ok {
+		return supported
+	}
+	return DefaultLocale
+}
+
+// MatchLocale picks the supported locale the client prefers most from an
+// Accept-Language header.
+func (templates *Templates) MatchLocale(acceptLanguage string) string {
+	type preference struct {
+		locale string
+		weight float64
+	}
+
+	var preferences []preference
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(params[2:], 64); err == nil {
+				weight = parsed
+			}
+		}
+
+		if tag != "" && tag != "*" && weight > 0 {
+			preferences = append(preferences, preference{tag, weight})
+		}
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].weight > preferences[j].weight
+	})
+
+	for _, preferred := range preferences {
+		if supported, ok := templates.findLocale(preferred.locale); ok {
+			return supported
+		}
+	}
+
+	return DefaultLocale
+}
+
+func templatePath(locale string, name string) string {
+	return path.Join("templates", locale, name)
+}
+
+func (templates *Templates) findLocale(tag string) (string, bool) {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+
+	for _, supported := range templates.locales {
+		if base == supported {
+			return supported, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,249 @@
+package mailer
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFrom = mail.Address{Name: "HomeTrainers.net", Address: "support@hometrainers.net"}
+
+type smtpSession struct {
+	commands []string
+	data     string
+}
+
+// FakeSMTPServer accepts one plain SMTP session and reports what the client
+// sent.
+func FakeSMTPServer(t *testing.T) (int, chan smtpSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	sessions := make(chan smtpSession, 1)
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		text := textproto.NewConn(conn)
+		defer text.Close()
+
+		var session smtpSession
+
+		text.PrintfLine("220 localhost ESMTP")
+
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				break
+			}
+
+			session.commands = append(session.commands, line)
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch verb {
+			case "EHLO":
+				text.PrintfLine("250-localhost")
+				text.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				text.PrintfLine("235 authenticated")
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, _ := text.ReadDotBytes()
+				session.data = string(data)
+				text.PrintfLine("250 queued")
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				sessions <- session
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+		}
+
+		sessions <- session
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, sessions
+}
+
+// SendMessage encodes a message with content to the address and sends it
+// with transport.
+func SendMessage(t *testing.T, transport Transport, to string, content Content) error {
+	message := Message{
+		From:    testFrom,
+		To:      mail.Address{Address: to},
+		Date:    time.Now(),
+		Content: content,
+	}
+
+	data, err := message.Bytes()
+	assert.Nil(t, err)
+
+	return transport.Send(testFrom.Address, []string{to}, data)
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, name := range []string{"MAIL_DRIVER", "MAIL_HOST", "MAIL_PORT", "MAIL_TLS", "MAIL_USERNAME", "MAIL_FROM", "ENVIRONMENT"} {
+		t.Setenv(name, "")
+	}
+
+	config, err := LoadConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, DriverSMTP, config.Driver)
+	assert.Equal(t, "smtp.zoho.com", config.Host)
+	assert.Equal(t, TLSImplicit, config.TLS)
+	assert.Equal(t, "support@hometrainers.net", config.Username)
+
+	t.Setenv("MAIL_PORT", "587")
+	t.Setenv("MAIL_FROM", "Trainers <hello@example.com>")
+
+	config, err = LoadConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, TLSStartTLS, config.TLS)
+	assert.Equal(t, "hello@example.com", config.Username)
+	assert.Equal(t, "Trainers", config.From.Name)
+
+	t.Setenv("MAIL_TLS", "sometimes")
+
+	_, err = LoadConfig()
+
+	assert.Error(t, err)
+
+	t.Setenv("MAIL_TLS", "")
+	t.Setenv("MAIL_DRIVER", DriverFile)
+
+	_, err = LoadConfig()
+
+	assert.Equal(t, ErrMailDirRequired, err)
+
+	t.Setenv("MAIL_DRIVER", DriverMemory)
+
+	config, err = LoadConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, DriverMemory, config.Driver)
+
+	t.Setenv("ENVIRONMENT", "PROD")
+
+	_, err = LoadConfig()
+
+	assert.Equal(t, ErrMemoryDriverInProd, err)
+}
+
+func TestSMTPTransport(t *testing.T) {
+	port, sessions := FakeSMTPServer(t)
+
+	transport := NewTransport(Config{
+		Driver:   DriverSMTP,
+		Host:     "127.0.0.1",
+		Port:     port,
+		TLS:      TLSNone,
+		Username: "support@hometrainers.net",
+		Password: "mail-password",
+		From:     testFrom,
+	})
+
+	err := SendMessage(t, transport, "user@example.com", Content{
+		Subject: "Sign in link",
+		Text:    "code=login-code",
+		HTML:    "<p>code=login-code</p>",
+	})
+
+	assert.Nil(t, err)
+
+	session := <-sessions
+
+	assert.Contains(t, session.commands, "MAIL FROM:<support@hometrainers.net>")
+	assert.Contains(t, session.commands, "RCPT TO:<user@example.com>")
+	assert.Contains(t, session.data, "Subject: Sign in link")
+	assert.Contains(t, session.data, "login-code")
+}
+
+func TestMaildirTransport(t *testing.T) {
+	dir := t.TempDir()
+
+	transport := NewTransport(Config{
+		Driver: DriverFile,
+		Dir:    dir,
+	})
+
+	err := SendMessage(t, transport, "user@example.com", Content{
+		Subject: "Account temporarily locked",
+		Text:    "Your account is locked.",
+		HTML:    "<p>Your account is locked.</p>",
+	})
+
+	assert.Nil(t, err)
+
+	delivered, _ := os.ReadDir(filepath.Join(dir, "new"))
+	pending, _ := os.ReadDir(filepath.Join(dir, "tmp"))
+
+	assert.Len(t, delivered, 1)
+	assert.Len(t, pending, 0)
+
+	data, _ := os.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+
+	assert.True(t, strings.HasPrefix(string(data), "Return-Path: <support@hometrainers.net>\r\nDelivered-To: user@example.com\r\n"))
+	assert.Contains(t, string(data), "Subject: Account temporarily locked")
+}
+
+func TestMemoryTransport(t *testing.T) {
+	sink := NewMemoryTransport()
+
+	SendMessage(t, sink, "user@example.com", Content{
+		Subject: "Verifica tu correo electrónico",
+		Text:    "code=verify-code&email=user%40example.com",
+		HTML:    `<html lang="es"></html>`,
+	})
+	SendMessage(t, sink, "other@example.com", Content{
+		Subject: "Reset your password",
+		Text:    "code=reset-code",
+		HTML:    `<html lang="en"></html>`,
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/dev/mail?to=user@example.com", nil)
+
+	sink.ServeHTTP(w, req)
+
+	var messages []SentMessage
+	json.Unmarshal(w.Body.Bytes(), &messages)
+
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "Verifica tu correo electrónico", messages[0].Subject)
+	assert.Contains(t, messages[0].Text, "code=verify-code&email=user%40example.com")
+	assert.Contains(t, messages[0].HTML, `lang="es"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/dev/mail", nil)
+
+	sink.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Len(t, sink.Messages(""), 0)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/dev/mail", nil)
+
+	sink.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}